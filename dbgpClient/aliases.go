@@ -0,0 +1,257 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"github.com/derickr/dbgp-tools/lib/pathmap"
+	"github.com/derickr/dbgp-tools/lib/protocol"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/*
+ * Short, gdb-style, commands that expand to DBGp commands:
+ *
+ *   b file:line          breakpoint_set -t line -f file -n line
+ *   b [class::]function  breakpoint_set -t call -m function [-a class]
+ *   c                    run
+ *   n                    step_over
+ *   s                    step_into
+ *   finish               step_out
+ *   bt                   stack_get
+ *   p $var               property_get -n $var (or eval, for other expressions)
+ *   l [line]             source [-b line-n -e line+n], with n from --source-lines
+ */
+
+// A variable, with array elements and properties, such as $a['b']->c or $a::$b. Anything else,
+// such as $a-1, is an expression.
+var variableRegexp = regexp.MustCompile(`^\$[\w\[\]'"]+(?:(?:->|::)\$?[\w\[\]'"]+)*$`)
+
+func expandAlias(line string) (string, error) {
+	name, rest, _ := strings.Cut(strings.TrimSpace(line), " ")
+	rest = strings.TrimSpace(rest)
+
+	switch name {
+	case "c", "continue":
+		return "run", nil
+	case "n", "next":
+		return "step_over", nil
+	case "s", "step":
+		return "step_into", nil
+	case "finish":
+		return "step_out", nil
+	case "bt", "backtrace":
+		return "stack_get", nil
+
+	case "p", "print":
+		if rest == "" {
+			return "", fmt.Errorf("Usage: p $variable, or p expression")
+		}
+		if variableRegexp.MatchString(rest) {
+			return protocol.NewCommandLine("property_get", "-n", rest).String(), nil
+		}
+		return "eval -- " + rest, nil
+
+	case "b": // no long form, as 'break' is a DBGp command
+		return expandBreak(rest)
+
+	case "l", "list":
+		if rest == "" {
+			return "source", nil
+		}
+
+		lineNo, err := strconv.Atoi(rest)
+		if err != nil || lineNo <= 0 {
+			return "", fmt.Errorf("Usage: l [line]")
+		}
+		context := max(sourceContext, 1)
+		return fmt.Sprintf("source -b %d -e %d", max(1, lineNo-context), lineNo+context), nil
+	}
+
+	return line, nil
+}
+
+func expandBreak(location string) (string, error) {
+	if location == "" {
+		return "", fmt.Errorf("Usage: b file:line, or b [class::]function")
+	}
+
+	if separator := strings.LastIndex(location, ":"); separator > 0 && location[separator-1] != ':' {
+		lineNo, err := strconv.Atoi(location[separator+1:])
+		if err != nil || lineNo <= 0 {
+			return "", fmt.Errorf("Invalid line number '%s'", location[separator+1:])
+		}
+
+		return protocol.NewCommandLine("breakpoint_set", "-t", "line", "-f", pathmap.FileURI(location[:separator]), "-n", strconv.Itoa(lineNo)).String(), nil
+	}
+
+	cl := protocol.NewCommandLine("breakpoint_set", "-t", "call")
+	if class, function, found := strings.Cut(location, "::"); found {
+		cl.Arguments = append(cl.Arguments, "-m", function, "-a", class)
+	} else {
+		cl.Arguments = append(cl.Arguments, "-m", location)
+	}
+
+	return cl.String(), nil
+}
+
+/*
+ * Macros are read from a file (dbgpClient.macros in the "xdebug" directory of
+ * the user configuration directory by default, or the one given with
+ * --macros). Each one runs several commands, which can be DBGp commands,
+ * aliases, 'bp', or other macros. $arg0, $arg1, and so on, are replaced by
+ * the arguments that the macro is called with, or removed when the macro is
+ * called with fewer arguments:
+ *
+ *   # Show a variable, and its parent
+ *   define show
+ *   p $arg0
+ *   p $this
+ *   end
+ */
+var macros = map[string][]string{}
+
+var macroArgument = regexp.MustCompile(`\$arg(\d+)\b`)
+
+const maxMacroDepth = 10
+
+func defaultMacroFile() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+
+	return filepath.Join(dir, "xdebug", "dbgpClient.macros")
+}
+
+// Reads the macros from 'path', or from the default file. Only a file that was asked for
+// explicitly has to exist.
+func loadMacros(path string) error {
+	required := path != ""
+	if !required {
+		path = defaultMacroFile()
+		if path == "" {
+			return nil
+		}
+	}
+
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) && !required {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("Can not read macros: %w", err)
+	}
+	defer file.Close()
+
+	name := ""
+	lineNo := 0
+	scanner := bufio.NewScanner(file)
+
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+
+		if line == "" || line[0] == '#' {
+			continue
+		}
+
+		keyword, rest, _ := strings.Cut(line, " ")
+
+		switch {
+		case name == "" && keyword == "define":
+			name = strings.TrimSpace(rest)
+			if name == "" || strings.Contains(name, " ") {
+				return fmt.Errorf("%s:%d: Expected 'define name'", path, lineNo)
+			}
+			macros[name] = []string{}
+
+		case name == "":
+			return fmt.Errorf("%s:%d: Expected 'define name', but found '%s'", path, lineNo, line)
+
+		case line == "end":
+			name = ""
+
+		default:
+			macros[name] = append(macros[name], line)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("Can not read macros from '%s': %w", path, err)
+	}
+	if name != "" {
+		return fmt.Errorf("%s: The macro '%s' is missing its 'end'", path, name)
+	}
+
+	return nil
+}
+
+func macroNames(line string) []string {
+	names := []string{}
+	for name := range macros {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+func isMacro(line string) bool {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return false
+	}
+
+	_, ok := macros[fields[0]]
+	return ok
+}
+
+// Expands a line that the user typed into the commands to run, one after the other
+func expandInput(line string) ([]string, error) {
+	return expandInputAt(line, 0)
+}
+
+func expandInputAt(line string, depth int) ([]string, error) {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return nil, nil
+	}
+
+	commands, ok := macros[fields[0]]
+	if !ok {
+		expanded, err := expandAlias(line)
+		if err != nil {
+			return nil, err
+		}
+		return []string{expanded}, nil
+	}
+
+	if depth >= maxMacroDepth {
+		return nil, fmt.Errorf("Macros are nested more than %d levels deep", maxMacroDepth)
+	}
+
+	lines := []string{}
+	for _, command := range commands {
+		command = macroArgument.ReplaceAllStringFunc(command, func(argument string) string {
+			index, err := strconv.Atoi(argument[len("$arg"):])
+			if err != nil || index+1 >= len(fields) {
+				return ""
+			}
+			return fields[index+1]
+		})
+
+		expanded, err := expandInputAt(command, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		lines = append(lines, expanded...)
+	}
+
+	return lines, nil
+}
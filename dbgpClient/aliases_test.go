@@ -0,0 +1,137 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/derickr/dbgp-tools/lib/pathmap"
+)
+
+func TestExpandAlias(t *testing.T) {
+	tests := []struct {
+		line     string
+		expected string
+	}{
+		{"c", "run"},
+		{"bt", "stack_get"},
+		{"b Class::method", "breakpoint_set -t call -m method -a Class"},
+		{"b strlen", "breakpoint_set -t call -m strlen"},
+		{"b file.php:12", "breakpoint_set -t line -f " + pathmap.FileURI("file.php") + " -n 12"},
+		{"p $a", "property_get -n $a"},
+		{"p $a->b", "property_get -n $a->b"},
+		{"p $a['b']->c::$d", "property_get -n $a['b']->c::$d"},
+		{"p $a-1", "eval -- $a-1"},
+		{"p $a->", "eval -- $a->"},
+		{"p $a > 1", "eval -- $a > 1"},
+		{"p count($a)", "eval -- count($a)"},
+		{"stack_get -d 1", "stack_get -d 1"},
+	}
+
+	for _, test := range tests {
+		expanded, err := expandAlias(test.line)
+		if err != nil {
+			t.Errorf("'%s': %s", test.line, err)
+			continue
+		}
+		if expanded != test.expected {
+			t.Errorf("'%s': got '%s', expected '%s'", test.line, expanded, test.expected)
+		}
+	}
+
+	for _, line := range []string{"p", "b", "b file.php:0", "b file.php:x", "l x"} {
+		if expanded, err := expandAlias(line); err == nil {
+			t.Errorf("'%s' is invalid, but expanded to '%s'", line, expanded)
+		}
+	}
+}
+
+func writeMacros(t *testing.T, contents string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "dbgpClient.macros")
+	if err := os.WriteFile(path, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	macros = map[string][]string{}
+	t.Cleanup(func() { macros = map[string][]string{} })
+
+	return path
+}
+
+func TestMacros(t *testing.T) {
+	path := writeMacros(t, `
+# Shows a variable, and a property of it
+define show
+p $arg0
+p $arg0->$arg1
+end
+
+define both
+show $arg1 $arg0
+c
+end
+`)
+
+	if err := loadMacros(path); err != nil {
+		t.Fatalf("loading macros failed: %s", err)
+	}
+
+	tests := []struct {
+		line     string
+		expected []string
+	}{
+		{"show $a b", []string{"property_get -n $a", "property_get -n $a->b"}},
+		// Missing arguments are removed, so the second line is no longer a variable
+		{"show $a", []string{"property_get -n $a", "eval -- $a->"}},
+		{"both name $user", []string{"property_get -n $user", "property_get -n $user->name", "run"}},
+		{"n", []string{"step_over"}},
+	}
+
+	for _, test := range tests {
+		lines, err := expandInput(test.line)
+		if err != nil {
+			t.Errorf("'%s': %s", test.line, err)
+			continue
+		}
+		if strings.Join(lines, "\n") != strings.Join(test.expected, "\n") {
+			t.Errorf("'%s': got %q, expected %q", test.line, lines, test.expected)
+		}
+	}
+}
+
+func TestMacroNesting(t *testing.T) {
+	path := writeMacros(t, "define loop\nloop\nend\n")
+
+	if err := loadMacros(path); err != nil {
+		t.Fatalf("loading macros failed: %s", err)
+	}
+
+	if lines, err := expandInput("loop"); err == nil || !strings.Contains(err.Error(), "nested") {
+		t.Errorf("a macro that calls itself expanded to %q, %v", lines, err)
+	}
+}
+
+func TestLoadMacrosErrors(t *testing.T) {
+	tests := []struct {
+		contents string
+		message  string
+	}{
+		{"define show\np $arg0\n", "missing its 'end'"},
+		{"p $this\n", "Expected 'define name'"},
+		{"define\nend\n", "Expected 'define name'"},
+	}
+
+	for _, test := range tests {
+		err := loadMacros(writeMacros(t, test.contents))
+		if err == nil || !strings.Contains(err.Error(), test.message) {
+			t.Errorf("%q: expected an error with '%s', got %v", test.contents, test.message, err)
+		}
+	}
+
+	if err := loadMacros(filepath.Join(t.TempDir(), "missing.macros")); err == nil {
+		t.Errorf("a macro file that was asked for, but does not exist, was accepted")
+	}
+}
@@ -0,0 +1,393 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/derickr/dbgp-tools/lib/config"
+	"github.com/derickr/dbgp-tools/lib/dbgpxml"
+	"github.com/derickr/dbgp-tools/lib/pathmap"
+	"github.com/derickr/dbgp-tools/lib/protocol"
+	. "github.com/logrusorgru/aurora" // WTFPL
+	"os"
+	"strconv"
+	"strings"
+)
+
+/*
+ * The client keeps its own list of breakpoints, which is stored in a file
+ * (.dbgp-breakpoints.json in the current directory by default), so that they
+ * survive the end of a debugging session. After each <init> packet, they are
+ * all set again with 'breakpoint_set'.
+ */
+type storedBreakpoint struct {
+	ID         int    `json:"id"`
+	Type       string `json:"type"` // line, conditional, call, return, or exception
+	Filename   string `json:"filename,omitempty"`
+	LineNo     int    `json:"lineno,omitempty"`
+	Class      string `json:"class,omitempty"`
+	Function   string `json:"function,omitempty"`
+	Exception  string `json:"exception,omitempty"`
+	Expression string `json:"expression,omitempty"`
+	Disabled   bool   `json:"disabled,omitempty"`
+
+	// What the engine of the current debugging session knows about the breakpoint
+	engineID     string
+	resolvedLine int
+}
+
+type breakpointList struct {
+	path        string
+	nextID      int
+	breakpoints []*storedBreakpoint
+}
+
+// The parts of the connection to the engine that the client's own commands use
+type connection interface {
+	SendCommandLine(cl *protocol.CommandLine) error
+	ReadResponse() (string, error)
+	ParseXML(rawXmlData string) (protocol.Response, error)
+}
+
+func loadBreakpoints(path string) (*breakpointList, error) {
+	list := &breakpointList{path: path, nextID: 1}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return list, nil
+	}
+	if err != nil {
+		return list, err
+	}
+
+	if err := json.Unmarshal(data, &list.breakpoints); err != nil {
+		return list, fmt.Errorf("Can not read breakpoints from '%s': %w", path, err)
+	}
+
+	for _, bp := range list.breakpoints {
+		if bp.ID >= list.nextID {
+			list.nextID = bp.ID + 1
+		}
+	}
+
+	return list, nil
+}
+
+func (list *breakpointList) save() error {
+	data, err := json.MarshalIndent(list.breakpoints, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	return config.WriteFile(list.path, append(data, '\n'), 0644)
+}
+
+func (list *breakpointList) find(id string) (*storedBreakpoint, error) {
+	for _, bp := range list.breakpoints {
+		if strconv.Itoa(bp.ID) == id {
+			return bp, nil
+		}
+	}
+
+	return nil, fmt.Errorf("There is no breakpoint with ID '%s'", id)
+}
+
+func (list *breakpointList) findByEngineID(engineID string) *storedBreakpoint {
+	for _, bp := range list.breakpoints {
+		if bp.engineID != "" && bp.engineID == engineID {
+			return bp
+		}
+	}
+
+	return nil
+}
+
+// Returns the line and conditional breakpoints in a file, as the engine knows it, by the line
+// that they are on. When the engine resolved a breakpoint to another line, that line is used.
+func (list *breakpointList) linesIn(fileURI string) map[int]*storedBreakpoint {
+	lines := map[int]*storedBreakpoint{}
+
+	for _, bp := range list.breakpoints {
+		if bp.Filename == "" || pathMappings.ToRemote(bp.Filename) != fileURI {
+			continue
+		}
+
+		if bp.resolvedLine > 0 {
+			lines[bp.resolvedLine] = bp
+		} else {
+			lines[bp.LineNo] = bp
+		}
+	}
+
+	return lines
+}
+
+/*
+ * Parses the arguments of 'bp add':
+ *
+ *   file:line [if expression]
+ *   call [class::]function
+ *   return [class::]function
+ *   exception name
+ */
+func parseBreakpoint(arguments string) (*storedBreakpoint, error) {
+	kind, rest, _ := strings.Cut(strings.TrimSpace(arguments), " ")
+	rest = strings.TrimSpace(rest)
+
+	switch kind {
+	case "call", "return":
+		if rest == "" {
+			return nil, fmt.Errorf("Usage: bp add %s [class::]function", kind)
+		}
+
+		bp := &storedBreakpoint{Type: kind, Function: rest}
+		if class, function, found := strings.Cut(rest, "::"); found {
+			bp.Class, bp.Function = class, function
+		}
+		return bp, nil
+
+	case "exception":
+		if rest == "" {
+			return nil, fmt.Errorf("Usage: bp add exception name")
+		}
+		return &storedBreakpoint{Type: "exception", Exception: rest}, nil
+	}
+
+	separator := strings.LastIndex(kind, ":")
+	if separator <= 0 {
+		return nil, fmt.Errorf("Usage: bp add file:line [if expression]")
+	}
+
+	line, err := strconv.Atoi(kind[separator+1:])
+	if err != nil || line <= 0 {
+		return nil, fmt.Errorf("Invalid line number '%s'", kind[separator+1:])
+	}
+
+	bp := &storedBreakpoint{Type: "line", Filename: pathmap.FileURI(kind[:separator]), LineNo: line}
+
+	if rest != "" {
+		expression, found := strings.CutPrefix(rest, "if ")
+		if !found {
+			return nil, fmt.Errorf("Usage: bp add file:line [if expression]")
+		}
+		bp.Type = "conditional"
+		bp.Expression = strings.TrimSpace(expression)
+	}
+
+	return bp, nil
+}
+
+// The breakpoint as the engine should set it, with the file name as the engine knows it
+func (bp *storedBreakpoint) spec() protocol.BreakpointSpec {
+	options := protocol.BreakpointOptions{Disabled: bp.Disabled}
+	filename := pathMappings.ToRemote(bp.Filename)
+
+	switch bp.Type {
+	case "conditional":
+		return protocol.ConditionalBreakpoint{BreakpointOptions: options, Filename: filename, LineNo: bp.LineNo, Expression: bp.Expression}
+	case "call":
+		return protocol.CallBreakpoint{BreakpointOptions: options, Classname: bp.Class, Function: bp.Function}
+	case "return":
+		return protocol.ReturnBreakpoint{BreakpointOptions: options, Classname: bp.Class, Function: bp.Function}
+	case "exception":
+		return protocol.ExceptionBreakpoint{BreakpointOptions: options, Exception: bp.Exception}
+	}
+
+	return protocol.LineBreakpoint{BreakpointOptions: options, Filename: filename, LineNo: bp.LineNo}
+}
+
+func (bp *storedBreakpoint) String() string {
+	content := fmt.Sprintf("%s ", Bold(BrightGreen("●")))
+	if bp.Disabled {
+		content = fmt.Sprintf("%s ", Bold(BrightRed("○")))
+	}
+
+	content += fmt.Sprintf("%d %s: ", Bold(Green(bp.ID)), Yellow(bp.Type))
+
+	switch bp.Type {
+	case "line", "conditional":
+		content += fmt.Sprintf("%s:%d", Green(bp.Filename), Bold(Green(bp.LineNo)))
+	case "call", "return":
+		if bp.Class != "" {
+			content += fmt.Sprintf("%s::", Bold(Green(bp.Class)))
+		}
+		content += fmt.Sprintf("%s()", Bold(Green(bp.Function)))
+	case "exception":
+		content += fmt.Sprintf("%s", Bold(Green(bp.Exception)))
+	}
+
+	if bp.Expression != "" {
+		content += fmt.Sprintf(" cond: %s", Yellow(bp.Expression))
+	}
+
+	if bp.engineID != "" {
+		content += fmt.Sprintf(" %s", Faint(fmt.Sprintf("(engine ID %s)", bp.engineID)))
+	}
+	if bp.resolvedLine > 0 && bp.resolvedLine != bp.LineNo {
+		content += fmt.Sprintf(" %s %d", Faint("resolved to line"), Bold(Green(bp.resolvedLine)))
+	}
+
+	return content
+}
+
+// Sends a command that the client issues itself, and waits for its response, showing the
+// notifications and streams that arrive in the meantime
+func runClientCommand(conn connection, cl *protocol.CommandLine) (dbgpxml.Response, error) {
+	if err := conn.SendCommandLine(cl); err != nil {
+		return dbgpxml.Response{}, err
+	}
+	tid, _ := cl.GetOption("-i")
+
+	for {
+		data, err := conn.ReadResponse()
+		if err != nil {
+			return dbgpxml.Response{}, err
+		}
+
+		if showXML {
+			fmt.Fprintf(output, "%s\n", Faint(data))
+		}
+
+		packet, err := conn.ParseXML(data)
+		if err != nil {
+			return dbgpxml.Response{}, err
+		}
+
+		response, ok := packet.(dbgpxml.Response)
+		if !ok {
+			fmt.Fprintln(output, packet)
+			if notify, ok := packet.(dbgpxml.Notify); ok {
+				storedBreakpoints.resolved(notify)
+			}
+			continue
+		}
+
+		if response.TID != tid {
+			fmt.Fprintf(output, "%s\n", BrightYellow(fmt.Sprintf("Ignoring the response to transaction ID '%s' (%s), while waiting for '%s'", response.TID, response.Command, tid)))
+			continue
+		}
+
+		if response.Error != nil && response.Error.Code != 0 {
+			return response, &protocol.CommandError{Command: cl.Name, Code: response.Error.Code, Message: response.Error.Message.Text}
+		}
+
+		return response, nil
+	}
+}
+
+func (list *breakpointList) set(conn connection, bp *storedBreakpoint) error {
+	bp.engineID = ""
+	bp.resolvedLine = 0
+
+	response, err := runClientCommand(conn, protocol.BreakpointSetCommand(bp.spec()))
+	if err != nil {
+		return err
+	}
+
+	bp.engineID = response.ID
+
+	return nil
+}
+
+// Sets all stored breakpoints in a new debugging session
+func (list *breakpointList) replay(conn connection) {
+	if len(list.breakpoints) == 0 {
+		return
+	}
+
+	// Without this, the engine does not tell where the breakpoints ended up
+	runClientCommand(conn, protocol.NewCommandLine("feature_set", "-n", "resolved_breakpoints", "-v", "1"))
+
+	for _, bp := range list.breakpoints {
+		if err := list.set(conn, bp); err != nil {
+			fmt.Fprintf(output, "%s %d: %s\n", BrightRed("Can not set breakpoint"), bp.ID, BrightRed(err.Error()))
+			continue
+		}
+		fmt.Fprintf(output, "%s\n", bp)
+	}
+}
+
+// Shows which stored breakpoint a 'breakpoint_resolved' notification is about
+func (list *breakpointList) resolved(notify dbgpxml.Notify) {
+	if notify.Name != "breakpoint_resolved" {
+		return
+	}
+
+	bp := list.findByEngineID(strconv.Itoa(notify.Breakpoint.ID))
+	if bp == nil {
+		return
+	}
+
+	bp.resolvedLine = notify.Breakpoint.LineNo
+	fmt.Fprintf(output, "%s %s\n", Faint("Stored breakpoint:"), bp)
+}
+
+// Handles the 'bp' command, which manages the stored breakpoints
+func (list *breakpointList) command(conn connection, arguments string) error {
+	action, rest, _ := strings.Cut(strings.TrimSpace(arguments), " ")
+	rest = strings.TrimSpace(rest)
+
+	switch action {
+	case "", "list":
+		if len(list.breakpoints) == 0 {
+			fmt.Fprintf(output, "%s\n", Faint(fmt.Sprintf("No breakpoints stored in '%s'", list.path)))
+		}
+		for _, bp := range list.breakpoints {
+			fmt.Fprintf(output, "%s\n", bp)
+		}
+		return nil
+
+	case "add":
+		bp, err := parseBreakpoint(rest)
+		if err != nil {
+			return err
+		}
+
+		bp.ID = list.nextID
+		list.nextID++
+		list.breakpoints = append(list.breakpoints, bp)
+
+		if err := list.set(conn, bp); err != nil {
+			fmt.Fprintf(output, "%s: %s\n", BrightYellow("Stored, but the engine did not accept it"), BrightRed(err.Error()))
+		}
+		fmt.Fprintf(output, "%s\n", bp)
+
+	case "remove":
+		bp, err := list.find(rest)
+		if err != nil {
+			return err
+		}
+
+		if bp.engineID != "" {
+			runClientCommand(conn, protocol.NewCommandLine("breakpoint_remove", "-d", bp.engineID))
+		}
+
+		for i, candidate := range list.breakpoints {
+			if candidate == bp {
+				list.breakpoints = append(list.breakpoints[:i], list.breakpoints[i+1:]...)
+				break
+			}
+		}
+
+	case "enable", "disable":
+		bp, err := list.find(rest)
+		if err != nil {
+			return err
+		}
+
+		bp.Disabled = action == "disable"
+
+		if bp.engineID != "" {
+			_, err = runClientCommand(conn, protocol.NewCommandLine("breakpoint_update", "-d", bp.engineID, "-s", action+"d"))
+			if err != nil {
+				fmt.Fprintf(output, "%s: %s\n", BrightYellow("Stored, but the engine did not accept it"), BrightRed(err.Error()))
+			}
+		}
+		fmt.Fprintf(output, "%s\n", bp)
+
+	default:
+		return fmt.Errorf("Unknown action '%s', use one of list, add, remove, enable, or disable", action)
+	}
+
+	return list.save()
+}
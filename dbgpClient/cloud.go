@@ -3,6 +3,8 @@
 package main
 
 import (
+	"errors"
+	"github.com/derickr/dbgp-tools/lib/config"
 	"github.com/pborman/getopt/v2" // BSD-3
 )
 
@@ -16,3 +18,11 @@ func handleCloudArguments() {
 		ssl = true
 	}
 }
+
+func applyCloudConfig(cfg *config.Config) error {
+	return errors.Join(
+		cfg.String("cloud", "domain", &CloudDomain),
+		cfg.String("cloud", "port", &CloudPort),
+		cfg.String("cloud", "user", &cloudUser),
+	)
+}
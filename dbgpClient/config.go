@@ -0,0 +1,60 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/derickr/dbgp-tools/lib/config"
+	. "github.com/logrusorgru/aurora" // WTFPL
+	"os"
+)
+
+/*
+ * Settings are read from /etc/xdebug/dbgpClient.ini and then from
+ * ~/.config/xdebug/dbgpClient.ini, or only from the file given with --config.
+ * Command line flags override them. For example:
+ *
+ *   [client]
+ *   port = 9003
+ *   show-xml = yes
+ *   path-map = /var/www=/home/derick/project
+ *
+ *   [proxy]
+ *   address = proxy.example.com:9001
+ *
+ *   [proxy-ssl]
+ *   ca = /etc/ssl/proxy-ca.pem
+ */
+func loadConfig() {
+	path, _ := config.FindFlag(os.Args[1:], "config")
+
+	cfg, err := config.Load("dbgpClient", path)
+	if err == nil {
+		err = errors.Join(
+			cfg.Int("client", "port", &port),
+			cfg.Bool("client", "once", &once),
+			cfg.Bool("client", "show-xml", &showXML),
+			cfg.Bool("client", "raw-data", &rawData),
+			cfg.Bool("client", "ssl", &ssl),
+			cfg.String("client", "history-file", &historyFile),
+			cfg.String("client", "record", &recordDir),
+			cfg.String("client", "breakpoints", &bpFile),
+			cfg.String("client", "path-map", &pathMapConfig),
+			cfg.String("client", "macros", &macroFile),
+			cfg.Int("client", "source-lines", &sourceContext),
+			cfg.String("ssl", "certificate", &sslCertFile),
+			cfg.String("ssl", "key", &sslKeyFile),
+			cfg.String("ssl", "min-version", &sslMinVersion),
+			applyProxyConfig(cfg),
+			applyCloudConfig(cfg),
+		)
+	}
+
+	if err != nil {
+		fmt.Fprintf(output, "%s: %s\n", BrightRed(Bold("Error reading configuration")), BrightRed(err.Error()))
+		os.Exit(2)
+	}
+
+	for _, unused := range cfg.Unused() {
+		fmt.Fprintf(output, "%s\n", BrightYellow(unused))
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"github.com/derickr/dbgp-tools/lib/dbgpxml"
+	"github.com/derickr/dbgp-tools/lib/protocol"
+	. "github.com/logrusorgru/aurora" // WTFPL
+	"os"
+	"strconv"
+	"strings"
+)
+
+/*
+ * After the engine breaks, the client shows the source code around the
+ * current line (sourceContext lines before and after it), with an arrow on
+ * the current line, and the stored breakpoints marked. When a path mapping
+ * points to a local copy of the file, that is read instead of asking the
+ * engine with 'source'.
+ */
+func showSourceListing(conn connection, response dbgpxml.Response) {
+	if sourceContext <= 0 || response.Status != "break" || response.Message.Filename == "" {
+		return
+	}
+
+	switch response.Command {
+	case "run", "step_into", "step_over", "step_out":
+	default:
+		return
+	}
+
+	filename := response.Message.Filename
+	current := response.Message.LineNo
+	begin := max(1, current-sourceContext)
+	end := current + sourceContext
+
+	lines, err := readSourceLines(conn, filename, begin, end)
+	if err != nil {
+		fmt.Fprintf(output, "%s: %s\n", BrightYellow("Can not show the source code"), BrightRed(err.Error()))
+		return
+	}
+
+	breakpoints := storedBreakpoints.linesIn(filename)
+
+	fmt.Fprint(output, dbgpxml.FormatSourceLines(lines, begin, func(lineno int) string {
+		marker := "  "
+		if bp, ok := breakpoints[lineno]; ok {
+			if bp.Disabled {
+				marker = fmt.Sprintf("%s ", Bold(BrightRed("○")))
+			} else {
+				marker = fmt.Sprintf("%s ", Bold(BrightGreen("●")))
+			}
+		}
+
+		if lineno == current {
+			return marker + fmt.Sprintf("%s", Bold(BrightYellow("→")))
+		}
+
+		return marker + " "
+	}))
+	fmt.Fprintln(output)
+}
+
+// Returns the lines 'begin' to 'end' of a file, or fewer when the file is shorter
+func readSourceLines(conn connection, filename string, begin int, end int) ([]string, error) {
+	if local, ok := pathMappings.ToLocal(filename); ok {
+		if data, err := os.ReadFile(local); err == nil {
+			lines := strings.Split(strings.TrimRight(string(data), " \n"), "\n")
+			if begin > len(lines) {
+				return nil, fmt.Errorf("'%s' has only %d lines", local, len(lines))
+			}
+			return lines[begin-1 : min(end, len(lines))], nil
+		}
+	}
+
+	response, err := runClientCommand(conn, protocol.NewCommandLine("source", "-f", filename, "-b", strconv.Itoa(begin), "-e", strconv.Itoa(end)))
+	if err != nil {
+		return nil, err
+	}
+
+	return response.SourceLines(), nil
+}
@@ -9,11 +9,14 @@ import (
 	"github.com/derickr/dbgp-tools/lib/dbgpxml"
 	"github.com/derickr/dbgp-tools/lib/logger"
 	"github.com/derickr/dbgp-tools/lib/protocol"
+	"github.com/derickr/dbgp-tools/lib/recorder"
+	"github.com/derickr/dbgp-tools/lib/tlsconfig"
 	. "github.com/logrusorgru/aurora" // WTFPL
 	"github.com/pborman/getopt/v2"    // BSD-3
 	"net"
 	"os"
 	"os/signal"
+	"strings"
 	"time"
 )
 
@@ -34,6 +37,53 @@ documentation at https://xdebug.org/docs/dbgpClient
 
 You can use <tab> for auto completing commands, and find out which one
 exist.
+
+The data after '--', for example for 'eval', 'property_set', and
+'breakpoint_set -t conditional', is base64 encoded for you.
+Start the client with --raw-data if you want to paste already encoded data.
+
+Default settings are read from /etc/xdebug/dbgpClient.ini and from
+dbgpClient.ini in the "xdebug" directory of your user configuration
+directory, or only from the file given with --config.
+
+When PHP runs in a container, or on another machine, use --path-map (or
+'path-map' in the [client] section) to map its directories to local ones.
+File names in 'breakpoint_set -f', 'source -f', and 'bp add' are then
+translated to the engine's, and the engine's are shown as local paths.
+Without mappings, one is guessed from the script that the engine runs.
+
+When the engine breaks, the lines of source code around the current line
+are shown, with stored breakpoints marked. Use --source-lines (or
+'source-lines' in the [client] section) to change how many lines are shown
+before and after the current one, or set it to 0 to turn this off.
+
+Breakpoints that are managed with the 'bp' command are stored in a file
+(.dbgp-breakpoints.json in the current directory, or the one given with
+--breakpoints), and are set again at the start of each debugging session:
+
+  bp [list]                          List the stored breakpoints
+  bp add file:line [if expression]   Add a line or conditional breakpoint
+  bp add call [class::]function      Break when a function is called
+  bp add return [class::]function    Break when a function returns
+  bp add exception name              Break when an exception is thrown
+  bp remove|enable|disable id        Change a stored breakpoint
+
+There are also short versions of common commands, like in gdb:
+
+  b file:line, b [class::]function   Set a line, or a function breakpoint
+  c, n, s, finish                    run, step_over, step_into, step_out
+  bt                                 Show the stack (stack_get)
+  p $variable, p expression          Show a variable, or evaluate code
+  l [line]                           Show the source code (around 'line')
+
+Macros, which run several commands at once, are read from dbgpClient.macros
+in the "xdebug" directory of your user configuration directory, or from the
+file given with --macros. $arg0, $arg1, ... are replaced by their arguments:
+
+  define locals
+  context_get
+  p $arg0
+  end
 `)
 }
 
@@ -53,12 +103,19 @@ func setupSignalHandler(protocol CommandRunner) {
 
 func handleConnection(c net.Conn, rl *readline.Instance) (bool, error) {
 	var lastCommand string
+	var pending []string   // commands from a macro that still have to run
+	var expectedTID string // the transaction ID of the command that was sent last
 
 	reader := protocol.NewDbgpClient(c, logOutput)
+	reader.SetRawData(rawData)
 
 	setupSignalHandler(reader)
 	defer signal.Reset()
 
+	var packetRecorder *recorder.Recorder
+	defer func() { packetRecorder.Close() }() // it is only created once the init packet is read
+	recording := false
+
 	for {
 		var formattedResponse protocol.Response
 
@@ -75,6 +132,13 @@ func handleConnection(c net.Conn, rl *readline.Instance) (bool, error) {
 			return false, err
 		}
 
+		if recordDir != "" && !recording {
+			recording = true
+			init, _ := reader.ParseInitXML(response)
+			packetRecorder = startRecording(init, response)
+			reader.SetRecorder(packetRecorder)
+		}
+
 		if showXML {
 			fmt.Fprintf(output, "%s\n", Faint(response))
 		}
@@ -83,13 +147,28 @@ func handleConnection(c net.Conn, rl *readline.Instance) (bool, error) {
 			return false, fmt.Errorf("The received XML is not valid, closing connection: %s", response)
 		}
 
-		formattedResponse = reader.FormatXML(response)
+		formattedResponse, err = reader.ParseXML(response)
 
-		if formattedResponse == nil {
-			return false, fmt.Errorf("Could not interpret XML, closing connection.")
+		if err != nil {
+			return false, fmt.Errorf("Could not interpret XML, closing connection: %w", err)
+		}
+
+		if packet, ok := formattedResponse.(dbgpxml.Response); ok && expectedTID != "" && packet.TID != expectedTID {
+			fmt.Fprintf(output, "%s\n", BrightYellow(fmt.Sprintf("Ignoring the response to transaction ID '%s' (%s), while waiting for '%s'", packet.TID, packet.Command, expectedTID)))
+			continue
 		}
 		fmt.Fprintln(output, formattedResponse)
 
+		switch packet := formattedResponse.(type) {
+		case dbgpxml.Init:
+			guessPathMapping(packet)
+			storedBreakpoints.replay(reader)
+		case dbgpxml.Notify:
+			storedBreakpoints.resolved(packet)
+		case dbgpxml.Response:
+			showSourceListing(reader, packet)
+		}
+
 		if formattedResponse.ExpectMoreResponses() {
 			if !formattedResponse.IsSuccess() {
 				return false, fmt.Errorf("Another response expected, but it wasn't a successful response")
@@ -103,10 +182,36 @@ func handleConnection(c net.Conn, rl *readline.Instance) (bool, error) {
 		}
 
 	ReadInput:
-		line, err := rl.Readline()
+		var line string
 
-		if err != nil { // io.EOF
-			return false, err
+		if len(pending) > 0 {
+			line, pending = pending[0], pending[1:]
+			fmt.Fprintf(output, "%s\n", Faint(line))
+		} else {
+			typed, err := rl.Readline()
+
+			if err != nil { // io.EOF
+				return false, err
+			}
+
+			if typed == "" {
+				typed = lastCommand
+			}
+			lastCommand = typed
+
+			pending, err = expandInput(typed)
+			if err != nil {
+				fmt.Fprintf(output, "%s\n", BrightRed(err.Error()))
+				goto ReadInput
+			}
+			if len(pending) == 0 {
+				goto ReadInput
+			}
+			line, pending = pending[0], pending[1:]
+
+			if isMacro(typed) {
+				fmt.Fprintf(output, "%s\n", Faint(line))
+			}
 		}
 
 		if line == "help" {
@@ -114,39 +219,76 @@ func handleConnection(c net.Conn, rl *readline.Instance) (bool, error) {
 			goto ReadInput
 		}
 
-		if line == "" {
-			line = lastCommand
+		if line == "bp" || strings.HasPrefix(line, "bp ") {
+			err = storedBreakpoints.command(reader, strings.TrimPrefix(line, "bp"))
+			if err != nil {
+				fmt.Fprintf(output, "%s\n", BrightRed(err.Error()))
+			}
+			goto ReadInput
 		}
 
-		err = reader.SendCommand(line)
+		expectedTID, err = sendCommand(reader, line)
 		if err != nil {
 			return false, err
 		}
-
-		lastCommand = line
 	}
 
 	return false, nil
 }
 
+// Starts recording the session, now that the IDE key is known from the init packet
+func startRecording(init dbgpxml.Init, initPacket string) *recorder.Recorder {
+	ideKey := init.IDEKey
+	if ideKey == "" {
+		ideKey = init.CloudUserID
+	}
+
+	packetRecorder, err := recorder.NewRecorder(recordDir, ideKey)
+	if err != nil {
+		fmt.Fprintf(output, "%s\n", BrightYellow(fmt.Sprintf("Not recording session: %s", err)))
+		return nil
+	}
+
+	fmt.Fprintf(output, "%s\n", Faint(fmt.Sprintf("Recording session to '%s'", packetRecorder.Path())))
+	packetRecorder.RecordResponse(initPacket)
+
+	return packetRecorder
+}
+
 var (
-	cloudUser    = ""
-	disCloudUser = ""
-	CloudDomain  = "cloud.xdebug.com"
-	CloudPort    = "9021"
-	help         = false
-	once         = false
-	port         = 9003
-	proxy        = "localhost:9001"
-	register     = ""
-	showXML      = false
-	ssl          = false
-	sslPort      = 9013
-	sslProxy     = "localhost:9011"
-	version      = false
-	unregister   = ""
-	output       = ansicon.Convert(os.Stdout)
-	logOutput    = logger.NewConsoleLogger(output)
+	bpFile        = ".dbgp-breakpoints.json"
+	cloudUser     = ""
+	disCloudUser  = ""
+	CloudDomain   = "cloud.xdebug.com"
+	CloudPort     = "9021"
+	configFile    = ""
+	help          = false
+	historyFile   = ""
+	macroFile     = ""
+	once          = false
+	port          = 9003
+	proxy         = "localhost:9001"
+	proxyInfo     = ""
+	proxyList     = false
+	rawData       = false
+	recordDir     = ""
+	register      = ""
+	showXML       = false
+	sourceContext = 5
+	ssl           = false
+	sslPort       = 9013
+	sslCertFile   = "certs/fullchain.pem"
+	sslKeyFile    = "certs/privkey.pem"
+	sslMinVersion = "1.2"
+	sslProxy      = "localhost:9011"
+	version       = false
+	unregister    = ""
+	pathMaps      = []string{}
+	pathMapConfig = ""
+	output        = ansicon.Convert(os.Stdout)
+	logOutput     = logger.NewConsoleLogger(output)
+
+	storedBreakpoints *breakpointList
 )
 
 func printVersion() {
@@ -160,10 +302,20 @@ func printStartUp() {
 
 func handleArguments() {
 	getopt.Flag(&help, 'h', "Show this help")
+	getopt.FlagLong(&configFile, "config", 0, "Read settings from this file, instead of from the system-wide and per-user configuration files", "file")
 	getopt.Flag(&port, 'p', "Specify the port to listen on")
 	getopt.Flag(&version, 'v', "Show version number and exit")
 	getopt.Flag(&showXML, 'x', "Show protocol XML")
 	getopt.Flag(&once, '1', "Debug once and then exit")
+	getopt.FlagLong(&rawData, "raw-data", 0, "Send data after '--' as-is, as it is already base64 encoded")
+	getopt.FlagLong(&bpFile, "breakpoints", 0, "The file to store the breakpoints that are managed with the 'bp' command in", "file")
+	getopt.FlagLong(&macroFile, "macros", 0, "Read macros from this file, instead of from dbgpClient.macros in the per-user configuration directory", "file")
+	getopt.FlagLong(&pathMaps, "path-map", 0, "Map a directory on the debugging engine's side to a local one, such as /var/www=/home/me/project (can be repeated)", "remote=local")
+	getopt.FlagLong(&sourceContext, "source-lines", 0, "The number of lines of source code to show before and after the current line when the engine breaks, or 0 to not show them", "count")
+	getopt.FlagLong(&recordDir, "record", 0, "Record all commands and responses of each debugging session to a file in this directory", "dir")
+	getopt.FlagLong(&sslCertFile, "ssl-cert", 0, "The certificate (chain) to use when listening for SSL connections", "file")
+	getopt.FlagLong(&sslKeyFile, "ssl-key", 0, "The private key to use when listening for SSL connections", "file")
+	getopt.FlagLong(&sslMinVersion, "ssl-min-version", 0, "The minimum TLS version to accept: 1.0, 1.1, 1.2, or 1.3", "version")
 
 	handleProxyFlags()
 	handleCloudFlags()
@@ -184,29 +336,22 @@ func handleArguments() {
 	handleCloudArguments()
 }
 
-func accept(l net.Listener) (net.Conn, error) {
+func accept(l net.Listener, config *tls.Config) (net.Conn, error) {
 	c, err := l.Accept()
 
 	if err != nil {
 		return nil, err
 	}
 
-	if ssl {
-		cert, err := tls.LoadX509KeyPair("certs/fullchain.pem", "certs/privkey.pem")
-		if err != nil {
-			fmt.Printf("server: loadkeys: %s", err)
-			panic(err)
-		}
-		config := tls.Config{Certificates: []tls.Certificate{cert}}
-
-		return tls.Server(c, &config), nil
+	if config != nil {
+		return tls.Server(c, config), nil
 	} else {
 		return c, nil
 	}
 }
 
-func doNormalConnectionLoop(l net.Listener, rl *readline.Instance) {
-	c, err := accept(l)
+func doNormalConnectionLoop(l net.Listener, config *tls.Config, rl *readline.Instance) {
+	c, err := accept(l, config)
 	if err != nil {
 		fmt.Fprintln(output, err)
 		return
@@ -224,6 +369,19 @@ func doNormalConnectionLoop(l net.Listener, rl *readline.Instance) {
 }
 
 func runAsNormalClient() {
+	var config *tls.Config
+
+	if ssl {
+		settings := tlsconfig.Settings{CertFile: sslCertFile, KeyFile: sslKeyFile, MinVersion: sslMinVersion}
+
+		var err error
+		config, err = settings.ServerConfig()
+		if err != nil {
+			fmt.Fprintf(output, "%s: %s\n", BrightRed("Can not enable SSL"), BrightRed(err.Error()))
+			return
+		}
+	}
+
 	portString := fmt.Sprintf(":%v", port)
 	l, err := net.Listen("tcp", portString)
 	if err != nil {
@@ -238,7 +396,7 @@ func runAsNormalClient() {
 	defer rl.Close()
 
 	for {
-		doNormalConnectionLoop(l, rl)
+		doNormalConnectionLoop(l, config, rl)
 
 		if once {
 			break
@@ -261,7 +419,7 @@ func runAsCloudClient(logger logger.Logger) {
 	rl := initReadline()
 	defer rl.Close()
 
-	command := "cloudinit -u " + cloudUser
+	command := protocol.NewCommandLine("cloudinit", "-u", cloudUser).String()
 	err = proto.SendCommand(command)
 	if err != nil {
 		fmt.Fprintf(output, "%s: %s\n", BrightRed("Could not send 'cloudinit' command"), BrightRed(err.Error()))
@@ -287,7 +445,7 @@ func runAsCloudClient(logger logger.Logger) {
 
 		if abortClient {
 			// We don't care if this fails, as we're aborting anyway
-			command = "cloudstop -u " + cloudUser
+			command = protocol.NewCommandLine("cloudstop", "-u", cloudUser).String()
 			proto.SendCommand(command)
 			proto.ReadResponse()
 			return
@@ -299,9 +457,27 @@ func runAsCloudClient(logger logger.Logger) {
 
 func main() {
 	printVersion()
+	loadConfig()
 	handleArguments()
 	printStartUp()
 
+	if err := setupPathMappings(); err != nil {
+		fmt.Fprintf(output, "%s: %s\n", BrightRed(Bold("Error in path mappings")), BrightRed(err.Error()))
+		os.Exit(2)
+	}
+
+	if err := loadMacros(macroFile); err != nil {
+		fmt.Fprintf(output, "%s: %s\n", BrightRed(Bold("Error reading macros")), BrightRed(err.Error()))
+		os.Exit(2)
+	}
+
+	var err error
+	storedBreakpoints, err = loadBreakpoints(bpFile)
+	if err != nil {
+		fmt.Fprintf(output, "%s: %s\n", BrightRed(Bold("Error reading breakpoints")), BrightRed(err.Error()))
+		os.Exit(2)
+	}
+
 	log := logger.NewConsoleLogger(os.Stdout)
 
 	if disCloudUser != "" {
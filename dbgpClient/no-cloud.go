@@ -2,8 +2,16 @@
 
 package main
 
+import (
+	"github.com/derickr/dbgp-tools/lib/config"
+)
+
 func handleCloudFlags() {
 }
 
 func handleCloudArguments() {
 }
+
+func applyCloudConfig(cfg *config.Config) error {
+	return nil
+}
@@ -2,8 +2,16 @@
 
 package main
 
+import (
+	"github.com/derickr/dbgp-tools/lib/config"
+)
+
 func handleProxyFlags() {
 }
 
 func handleProxyArguments() {
 }
+
+func applyProxyConfig(cfg *config.Config) error {
+	return nil
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"github.com/derickr/dbgp-tools/lib/dbgpxml"
+	"github.com/derickr/dbgp-tools/lib/pathmap"
+	"github.com/derickr/dbgp-tools/lib/protocol"
+	. "github.com/logrusorgru/aurora" // WTFPL
+	"os"
+	"strings"
+)
+
+var (
+	configuredMappings = pathmap.Mappings{}
+	pathMappings       = pathmap.Mappings{} // the ones for the current debugging session
+)
+
+// Combines the mappings from the configuration file with the ones from --path-map
+func setupPathMappings() error {
+	mappings, err := pathmap.ParseList(pathMapConfig)
+	if err != nil {
+		return err
+	}
+
+	for _, spec := range pathMaps {
+		mapping, err := pathmap.Parse(spec)
+		if err != nil {
+			return err
+		}
+		mappings = append(mappings, mapping)
+	}
+
+	configuredMappings = mappings
+	pathMappings = mappings
+	dbgpxml.SetFilenameFormatter(displayFilename)
+
+	return nil
+}
+
+func displayFilename(fileURI string) string {
+	if local, ok := pathMappings.ToLocal(fileURI); ok {
+		return local
+	}
+
+	return fileURI
+}
+
+// Without configured mappings, tries to find the engine's script in the current directory. A
+// guess only applies to the debugging session that it was made for, as the next one might run
+// a script from a different project.
+func guessPathMapping(init dbgpxml.Init) {
+	pathMappings = configuredMappings
+	if len(pathMappings) > 0 {
+		return
+	}
+
+	directory, err := os.Getwd()
+	if err != nil {
+		return
+	}
+
+	mapping, ok := pathmap.Guess(init.FileURI, directory)
+	if !ok || mapping.Remote == mapping.Local {
+		return
+	}
+
+	pathMappings = pathmap.Mappings{mapping}
+	fmt.Fprintf(output, "%s %s %s\n", Faint("Guessed path mapping for this session:"), BrightYellow(mapping), Faint(fmt.Sprintf("(use --path-map=%s to always use it)", mapping)))
+}
+
+// Sends a command that the user typed, with local file names translated to the engine's, and
+// returns its transaction ID
+func sendCommand(conn connection, line string) (string, error) {
+	cl, err := protocol.ParseCommandLine(strings.TrimSpace(line))
+	if err != nil {
+		logOutput.LogError("dbgp-client", "Error parsing command: %s", err.Error())
+		return "", err
+	}
+
+	switch cl.Name {
+	case "breakpoint_set", "source":
+		if filename, ok := cl.GetOption("-f"); ok && filename != "" && len(pathMappings) > 0 {
+			cl.SetOption("-f", pathMappings.ToRemote(filename))
+		}
+	}
+
+	if err := conn.SendCommandLine(cl); err != nil {
+		return "", err
+	}
+
+	tid, _ := cl.GetOption("-i")
+	return tid, nil
+}
@@ -3,23 +3,53 @@
 package main
 
 import (
+	"crypto/tls"
+	"errors"
 	"fmt"
+	"github.com/derickr/dbgp-tools/lib/config"
 	"github.com/derickr/dbgp-tools/lib/connections"
 	"github.com/derickr/dbgp-tools/lib/protocol"
+	"github.com/derickr/dbgp-tools/lib/tlsconfig"
 	. "github.com/logrusorgru/aurora" // WTFPL
 	"github.com/pborman/getopt/v2"    // BSD-3
+	"net"
 	"os"
 	"strconv"
 )
 
+var (
+	proxySSLCertFile = ""
+	proxySSLKeyFile  = ""
+	proxySSLCAFile   = ""
+)
+
 func handleProxyFlags() {
 	getopt.FlagLong(&proxy, "proxy", 'y', "Register with a DBGp proxy", "host:port")
 	getopt.FlagLong(&register, "register", 'r', "Register client with DBGp proxy", "idekey")
 	getopt.FlagLong(&unregister, "unregister", 'u', "Unregister client with DBGp proxy", "idekey")
 	getopt.FlagLong(&ssl, "ssl", 's', "Enable SSL")
+	getopt.FlagLong(&proxyList, "proxy-list", 0, "List the IDEs that are registered with the DBGp proxy")
+	getopt.FlagLong(&proxyInfo, "proxy-info", 0, "Show the registration of an IDE key with the DBGp proxy", "idekey")
+	getopt.FlagLong(&proxySSLCertFile, "proxy-ssl-cert", 0, "The client certificate to present when connecting to the DBGp proxy over SSL", "file")
+	getopt.FlagLong(&proxySSLKeyFile, "proxy-ssl-key", 0, "The private key for --proxy-ssl-cert", "file")
+	getopt.FlagLong(&proxySSLCAFile, "proxy-ssl-ca", 0, "The CA bundle to verify the DBGp proxy's certificate with, instead of the system's", "file")
 }
 
 func handleProxyArguments() {
+	if proxyList || proxyInfo != "" {
+		if cloudUser != "" {
+			fmt.Fprintf(output, "%s\n", BrightRed(Bold("Refusing to query proxy because we're connecting to Xdebug Cloud")))
+			os.Exit(2)
+		}
+
+		err := queryProxy(proxy, proxyInfo)
+		if err != nil {
+			fmt.Fprintf(output, "%s: %s\n", BrightRed(Bold("Error querying proxy")), BrightRed(err.Error()))
+			os.Exit(2)
+		}
+		os.Exit(0)
+	}
+
 	if register != "" {
 		if cloudUser != "" {
 			fmt.Fprintf(output, "%s\n", BrightRed(Bold("Refusing to register to proxy because we're connecting to Xdebug Cloud")))
@@ -47,29 +77,73 @@ func handleProxyArguments() {
 	}
 }
 
+// Returns the configuration for connecting to the proxy over SSL, or nil without --ssl
+func proxyTLSConfig() (*tls.Config, error) {
+	if !ssl {
+		return nil, nil
+	}
+
+	settings := tlsconfig.Settings{CertFile: proxySSLCertFile, KeyFile: proxySSLKeyFile, CAFile: proxySSLCAFile, MinVersion: sslMinVersion}
+
+	return settings.ClientConfig()
+}
+
+func connectToProxy(address string) (net.Conn, error) {
+	config, err := proxyTLSConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	return connections.ConnectTo(address, config)
+}
+
 func registerWithProxy(address string, idekey string) error {
-	conn, err := connections.ConnectTo(address, ssl)
+	conn, err := connectToProxy(address)
 	if err != nil {
 		return err
 	}
 	defer conn.Close()
 
-	command := "proxyinit -m 1 -k " + idekey + " -p " + strconv.Itoa(port)
+	command := protocol.NewCommandLine("proxyinit", "-m", "1", "-k", idekey, "-p", strconv.Itoa(port))
 	if ssl {
-		command = command + " -s 1"
+		command.Arguments = append(command.Arguments, "-s", "1")
 	}
 
-	return protocol.RunAndQuit(conn, command, output, logOutput, showXML)
+	return protocol.RunAndQuit(conn, command.String(), output, logOutput, showXML)
 }
 
 func unregisterWithProxy(address string, idekey string) error {
-	conn, err := connections.ConnectTo(address, ssl)
+	conn, err := connectToProxy(address)
 	if err != nil {
 		return err
 	}
 	defer conn.Close()
 
-	command := "proxystop -k " + idekey
+	command := protocol.NewCommandLine("proxystop", "-k", idekey).String()
 
 	return protocol.RunAndQuit(conn, command, output, logOutput, showXML)
 }
+
+func queryProxy(address string, idekey string) error {
+	conn, err := connectToProxy(address)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	command := protocol.NewCommandLine("proxylist")
+	if idekey != "" {
+		command = protocol.NewCommandLine("proxyinfo", "-k", idekey)
+	}
+
+	return protocol.RunAndQuit(conn, command.String(), output, logOutput, showXML)
+}
+
+func applyProxyConfig(cfg *config.Config) error {
+	return errors.Join(
+		cfg.String("proxy", "address", &proxy),
+		cfg.String("proxy-ssl", "certificate", &proxySSLCertFile),
+		cfg.String("proxy-ssl", "key", &proxySSLKeyFile),
+		cfg.String("proxy-ssl", "ca", &proxySSLCAFile),
+	)
+}
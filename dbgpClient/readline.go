@@ -143,17 +143,44 @@ var completer = readline.NewPrefixCompleter(
 	readline.PcItem("stop"),
 	readline.PcItem("detach"),
 
+	readline.PcItem("bp",
+		readline.PcItem("list"),
+		readline.PcItem("add",
+			readline.PcItem("call"),
+			readline.PcItem("return"),
+			readline.PcItem("exception"),
+		),
+		readline.PcItem("remove"),
+		readline.PcItem("enable"),
+		readline.PcItem("disable"),
+	),
+
+	// Aliases, see aliases.go
+	readline.PcItem("b"),
+	readline.PcItem("bt"),
+	readline.PcItem("c"),
+	readline.PcItem("finish"),
+	readline.PcItem("l"),
+	readline.PcItem("n"),
+	readline.PcItem("p"),
+	readline.PcItem("s"),
+
+	// User-defined macros
+	readline.PcItemDynamic(macroNames),
+
 	readline.PcItem("help"),
 )
 
 func initReadline() *readline.Instance {
-	usr, _ := user.Current()
-	dir := usr.HomeDir
+	if historyFile == "" {
+		usr, _ := user.Current()
+		historyFile = usr.HomeDir + "/.xdebug-debugclient.hist"
+	}
 
 	rl, err := readline.NewEx(&readline.Config{
 		Prompt:          fmt.Sprintf("%s", Bold("(cmd) ")),
 		Stdout:          output,
-		HistoryFile:     dir + "/.xdebug-debugclient.hist",
+		HistoryFile:     historyFile,
 		AutoComplete:    completer,
 		InterruptPrompt: "^C",
 	})
@@ -0,0 +1,822 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/derickr/dbgp-tools/lib/dap"
+	"github.com/derickr/dbgp-tools/lib/dbgpxml"
+	"github.com/derickr/dbgp-tools/lib/logger"
+	"github.com/derickr/dbgp-tools/lib/pathmap"
+	"github.com/derickr/dbgp-tools/lib/protocol"
+)
+
+// Where to get debugging sessions from, set with flags, and overridden by the 'launch' or 'attach' arguments
+type settings struct {
+	port        int
+	proxy       string
+	ideKey      string
+	cloudUser   string
+	stopOnEntry bool
+	pathMaps    pathmap.Mappings
+}
+
+type launchArguments struct {
+	Port        *int   `json:"port"`
+	Proxy       string `json:"proxy"`
+	IDEKey      string `json:"ideKey"`
+	CloudUser   string `json:"cloudUser"`
+	StopOnEntry *bool  `json:"stopOnEntry"`
+
+	// Maps directories on the engine's side to local ones, such as {"/var/www": "/home/me/project"}
+	PathMappings map[string]string `json:"pathMappings"`
+}
+
+/*
+ * An adapter translates between one editor, talking DAP, and the debugging
+ * engines that connect to it, talking DBGp.
+ */
+type adapter struct {
+	sync.Mutex
+	conn      *dap.Conn
+	logger    logger.Logger
+	logWriter io.Writer
+	settings  settings
+	handles   *handles
+	verbose   bool
+
+	threads    map[int]*thread
+	nextThread int
+
+	breakpoints *breakpointStore
+
+	started        bool
+	configured     chan struct{}
+	configuredOnce sync.Once
+
+	listener   net.Listener
+	registered bool
+	cloud      *protocol.Session
+}
+
+var errNoSession = errors.New("There is no debugging session")
+var errRunning = errors.New("The script is running, pause it first")
+
+func newAdapter(conn *dap.Conn, defaults settings, logger logger.Logger, logWriter io.Writer) *adapter {
+	return &adapter{
+		conn:        conn,
+		logger:      logger,
+		logWriter:   logWriter,
+		settings:    defaults,
+		handles:     newHandles(),
+		threads:     map[int]*thread{},
+		breakpoints: newBreakpointStore(),
+		configured:  make(chan struct{}),
+	}
+}
+
+// Handles requests until the editor disconnects
+func (adapter *adapter) serve() error {
+	defer adapter.stopEngines()
+
+	for {
+		request, err := adapter.conn.ReadRequest()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if adapter.verbose {
+			adapter.logger.LogInfo("dap", "Request %d: %s %s", request.Seq, request.Command, string(request.Arguments))
+		}
+
+		body, err := adapter.handle(request)
+		if err != nil {
+			adapter.conn.SendError(request, err)
+		} else {
+			adapter.conn.SendResponse(request, body)
+		}
+
+		switch request.Command {
+		case "initialize":
+			if err == nil {
+				adapter.conn.SendEvent("initialized", nil)
+			}
+		case "disconnect":
+			return nil
+		}
+	}
+}
+
+func arguments(request *dap.Request, arguments interface{}) error {
+	if len(request.Arguments) == 0 {
+		return nil
+	}
+
+	if err := json.Unmarshal(request.Arguments, arguments); err != nil {
+		return fmt.Errorf("Invalid arguments for '%s': %w", request.Command, err)
+	}
+
+	return nil
+}
+
+func (adapter *adapter) handle(request *dap.Request) (interface{}, error) {
+	switch request.Command {
+	case "initialize":
+		return dap.Capabilities{
+			SupportsConfigurationDoneRequest:  true,
+			SupportsFunctionBreakpoints:       true,
+			SupportsConditionalBreakpoints:    true,
+			SupportsHitConditionalBreakpoints: true,
+			SupportsEvaluateForHovers:         true,
+			SupportsSetVariable:               true,
+			SupportsTerminateRequest:          true,
+			ExceptionBreakpointFilters:        []dap.ExceptionBreakpointsFilter{{Filter: "*", Label: "All exceptions"}},
+		}, nil
+
+	case "launch", "attach":
+		return nil, adapter.launch(request)
+
+	case "configurationDone":
+		adapter.configuredOnce.Do(func() { close(adapter.configured) })
+		return nil, nil
+
+	case "setBreakpoints":
+		return adapter.setBreakpoints(request)
+
+	case "setFunctionBreakpoints":
+		return adapter.setFunctionBreakpoints(request)
+
+	case "setExceptionBreakpoints":
+		return nil, adapter.setExceptionBreakpoints(request)
+
+	case "threads":
+		return adapter.threadList(), nil
+
+	case "stackTrace":
+		return adapter.stackTrace(request)
+
+	case "scopes":
+		return adapter.scopes(request)
+
+	case "variables":
+		return adapter.variables(request)
+
+	case "setVariable":
+		return adapter.setVariable(request)
+
+	case "evaluate":
+		return adapter.evaluate(request)
+
+	case "source":
+		return adapter.source(request)
+
+	case "continue", "next", "stepIn", "stepOut":
+		return adapter.continueThread(request)
+
+	case "pause":
+		return nil, adapter.pause(request)
+
+	case "terminate":
+		adapter.terminate()
+		return nil, nil
+
+	case "disconnect":
+		args := dap.DisconnectArguments{}
+		arguments(request, &args)
+		adapter.disconnect(args.TerminateDebuggee)
+		return nil, nil
+	}
+
+	return nil, fmt.Errorf("Unsupported request '%s'", request.Command)
+}
+
+func (adapter *adapter) launch(request *dap.Request) error {
+	args := launchArguments{}
+	if err := arguments(request, &args); err != nil {
+		return err
+	}
+
+	adapter.Lock()
+	defer adapter.Unlock()
+
+	if adapter.started {
+		return fmt.Errorf("Already waiting for debugging sessions")
+	}
+
+	if args.Port != nil {
+		adapter.settings.port = *args.Port
+	}
+	if args.Proxy != "" {
+		adapter.settings.proxy = args.Proxy
+	}
+	if args.IDEKey != "" {
+		adapter.settings.ideKey = args.IDEKey
+	}
+	if args.CloudUser != "" {
+		adapter.settings.cloudUser = args.CloudUser
+	}
+	if args.StopOnEntry != nil {
+		adapter.settings.stopOnEntry = *args.StopOnEntry
+	}
+	if len(args.PathMappings) > 0 {
+		mappings, err := parsePathMappings(args.PathMappings)
+		if err != nil {
+			return err
+		}
+		adapter.settings.pathMaps = mappings
+	}
+
+	if err := adapter.startEngines(); err != nil {
+		return err
+	}
+	adapter.started = true
+
+	return nil
+}
+
+func (adapter *adapter) threadFor(id int) (*thread, error) {
+	adapter.Lock()
+	defer adapter.Unlock()
+
+	thread, ok := adapter.threads[id]
+	if !ok {
+		return nil, errNoSession
+	}
+
+	return thread, nil
+}
+
+// Finds the thread to inspect, which must be stopped
+func (adapter *adapter) stoppedThread(id int) (*thread, error) {
+	thread, err := adapter.threadFor(id)
+	if err != nil {
+		return nil, err
+	}
+	if thread.isRunning() {
+		return nil, errRunning
+	}
+
+	return thread, nil
+}
+
+func (adapter *adapter) allThreads() []*thread {
+	adapter.Lock()
+	defer adapter.Unlock()
+
+	threads := []*thread{}
+	for _, thread := range adapter.threads {
+		threads = append(threads, thread)
+	}
+	sort.Slice(threads, func(i, j int) bool { return threads[i].id < threads[j].id })
+
+	return threads
+}
+
+func (adapter *adapter) threadList() dap.ThreadsResponseBody {
+	body := dap.ThreadsResponseBody{Threads: []dap.Thread{}}
+	for _, thread := range adapter.allThreads() {
+		body.Threads = append(body.Threads, dap.Thread{ID: thread.id, Name: thread.name})
+	}
+
+	return body
+}
+
+/* Breakpoints */
+
+func (adapter *adapter) setBreakpoints(request *dap.Request) (interface{}, error) {
+	args := dap.SetBreakpointsArguments{}
+	if err := arguments(request, &args); err != nil {
+		return nil, err
+	}
+	if args.Source.Path == "" {
+		return nil, fmt.Errorf("Breakpoints can only be set in files")
+	}
+
+	adapter.Lock()
+	uri := adapter.settings.pathMaps.ToRemote(args.Source.Path)
+	stored := adapter.breakpoints.setLines(uri, args.Source, args.Breakpoints)
+	adapter.Unlock()
+
+	threads := adapter.allThreads()
+
+	// Without a stopped debugging session, the breakpoints are set once an engine connects, or
+	// before the script continues, which is reported with 'breakpoint' events
+	results := []dap.Breakpoint{}
+	for _, breakpoint := range stored {
+		results = append(results, dap.Breakpoint{ID: breakpoint.id, Line: breakpoint.Line, Message: waitingMessage(threads)})
+	}
+
+	for _, thread := range threads {
+		if threadResults, changed := thread.changeBreakpoints(uri, func() []dap.Breakpoint { return adapter.setLineBreakpoints(thread, uri) }); changed {
+			results = mergeBreakpointResults(results, threadResults)
+		}
+	}
+
+	return dap.SetBreakpointsResponseBody{Breakpoints: results}, nil
+}
+
+func waitingMessage(threads []*thread) string {
+	if len(threads) == 0 {
+		return "Waiting for a debugging session"
+	}
+
+	return "Waiting for the script to pause"
+}
+
+// Combines the results of setting the same breakpoints in several debugging sessions. A
+// breakpoint is verified if any of them verified it.
+func mergeBreakpointResults(results []dap.Breakpoint, more []dap.Breakpoint) []dap.Breakpoint {
+	for i := range results {
+		if i < len(more) && !results[i].Verified {
+			results[i] = more[i]
+		}
+	}
+
+	return results
+}
+
+// Replaces the breakpoints in a file with the stored ones. Must only be called through
+// changeBreakpoints, while the script is stopped.
+func (adapter *adapter) setLineBreakpoints(thread *thread, uri string) []dap.Breakpoint {
+	adapter.Lock()
+	source := adapter.breakpoints.sources[uri]
+	breakpoints := append([]lineBreakpoint{}, adapter.breakpoints.lines[uri]...)
+	adapter.Unlock()
+
+	thread.Lock()
+	old := thread.lineBreakpoints[uri]
+	delete(thread.lineBreakpoints, uri)
+	thread.Unlock()
+
+	for _, id := range old {
+		thread.session.BreakpointRemove(id)
+	}
+
+	results := []dap.Breakpoint{}
+
+	for _, breakpoint := range breakpoints {
+		result := dap.Breakpoint{ID: breakpoint.id, Source: &source, Line: breakpoint.Line}
+
+		spec, err := lineBreakpointSpec(uri, breakpoint)
+		if err == nil {
+			var engineID string
+
+			engineID, err = thread.session.BreakpointSet(spec)
+			if err == nil {
+				thread.Lock()
+				thread.lineBreakpoints[uri] = append(thread.lineBreakpoints[uri], engineID)
+				thread.editorIDs[engineID] = breakpoint.id
+				thread.Unlock()
+			}
+		}
+
+		result.Verified = err == nil
+		if err != nil {
+			result.Message = err.Error()
+		}
+		results = append(results, result)
+	}
+
+	return results
+}
+
+func (adapter *adapter) sendBreakpointEvents(breakpoints []dap.Breakpoint) {
+	for _, breakpoint := range breakpoints {
+		adapter.conn.SendEvent("breakpoint", dap.BreakpointEventBody{Reason: "changed", Breakpoint: breakpoint})
+	}
+}
+
+// Replaces the function and exception breakpoints with the stored ones. Must only be called
+// through changeBreakpoints, while the script is stopped.
+func (adapter *adapter) setOtherBreakpoints(thread *thread) []dap.Breakpoint {
+	adapter.Lock()
+	functions := append([]dap.FunctionBreakpoint{}, adapter.breakpoints.functions...)
+	functionIDs := append([]int{}, adapter.breakpoints.functionIDs...)
+	exceptions := adapter.breakpoints.exceptions
+	adapter.Unlock()
+
+	thread.Lock()
+	old := thread.otherBreakpoints
+	thread.otherBreakpoints = nil
+	thread.Unlock()
+
+	for _, id := range old {
+		thread.session.BreakpointRemove(id)
+	}
+
+	results := []dap.Breakpoint{}
+	for i, breakpoint := range functions {
+		result := dap.Breakpoint{ID: functionIDs[i]}
+
+		spec, err := functionBreakpointSpec(breakpoint)
+		if err == nil {
+			var engineID string
+
+			engineID, err = thread.session.BreakpointSet(spec)
+			if err == nil {
+				thread.Lock()
+				thread.otherBreakpoints = append(thread.otherBreakpoints, engineID)
+				thread.Unlock()
+			}
+		}
+
+		result.Verified = err == nil
+		if err != nil {
+			result.Message = err.Error()
+		}
+		results = append(results, result)
+	}
+
+	if exceptions {
+		engineID, err := thread.session.BreakpointSet(protocol.ExceptionBreakpoint{Exception: "*"})
+		if err != nil {
+			adapter.output("console", fmt.Sprintf("Can not break on exceptions: %s\n", err))
+		} else {
+			thread.Lock()
+			thread.otherBreakpoints = append(thread.otherBreakpoints, engineID)
+			thread.Unlock()
+		}
+	}
+
+	return results
+}
+
+func (adapter *adapter) setFunctionBreakpoints(request *dap.Request) (interface{}, error) {
+	args := dap.SetFunctionBreakpointsArguments{}
+	if err := arguments(request, &args); err != nil {
+		return nil, err
+	}
+
+	adapter.Lock()
+	ids := adapter.breakpoints.setFunctions(args.Breakpoints)
+	adapter.Unlock()
+
+	threads := adapter.allThreads()
+
+	results := []dap.Breakpoint{}
+	for _, id := range ids {
+		results = append(results, dap.Breakpoint{ID: id, Message: waitingMessage(threads)})
+	}
+
+	for _, thread := range threads {
+		if threadResults, changed := thread.changeBreakpoints("", func() []dap.Breakpoint { return adapter.setOtherBreakpoints(thread) }); changed {
+			results = mergeBreakpointResults(results, threadResults)
+		}
+	}
+
+	return dap.SetBreakpointsResponseBody{Breakpoints: results}, nil
+}
+
+func (adapter *adapter) setExceptionBreakpoints(request *dap.Request) error {
+	args := dap.SetExceptionBreakpointsArguments{}
+	if err := arguments(request, &args); err != nil {
+		return err
+	}
+
+	adapter.Lock()
+	adapter.breakpoints.exceptions = len(args.Filters) > 0
+	adapter.Unlock()
+
+	for _, thread := range adapter.allThreads() {
+		thread.changeBreakpoints("", func() []dap.Breakpoint { return adapter.setOtherBreakpoints(thread) })
+	}
+
+	return nil
+}
+
+/* Inspecting */
+
+func (adapter *adapter) stackTrace(request *dap.Request) (interface{}, error) {
+	args := dap.StackTraceArguments{}
+	if err := arguments(request, &args); err != nil {
+		return nil, err
+	}
+
+	thread, err := adapter.stoppedThread(args.ThreadID)
+	if err != nil {
+		return nil, err
+	}
+
+	stack, err := thread.session.StackGet(-1)
+	if err != nil {
+		return nil, err
+	}
+
+	body := dap.StackTraceResponseBody{StackFrames: []dap.StackFrame{}, TotalFrames: len(stack)}
+
+	for i, frame := range stack {
+		if i < args.StartFrame || (args.Levels > 0 && i >= args.StartFrame+args.Levels) {
+			continue
+		}
+
+		name := frame.Where
+		if name == "" {
+			name = "{main}"
+		}
+
+		body.StackFrames = append(body.StackFrames, dap.StackFrame{
+			ID:     adapter.handles.create(&frameRef{thread: thread, depth: frame.Level}),
+			Name:   name,
+			Source: adapter.frameSource(thread, frame),
+			Line:   frame.LineNo,
+			Column: 1,
+		})
+	}
+
+	return body, nil
+}
+
+func (adapter *adapter) frameSource(thread *thread, frame dbgpxml.Stack) *dap.Source {
+	if path, ok := adapter.localPath(frame.Filename); ok {
+		return &dap.Source{Name: filepath.Base(path), Path: path}
+	}
+
+	// Code that was created with eval() only exists in the engine
+	return &dap.Source{Name: frame.Filename, SourceReference: adapter.handles.create(&sourceRef{thread: thread, uri: frame.Filename})}
+}
+
+func (adapter *adapter) scopes(request *dap.Request) (interface{}, error) {
+	args := dap.ScopesArguments{}
+	if err := arguments(request, &args); err != nil {
+		return nil, err
+	}
+
+	item, ok := adapter.handles.get(args.FrameID)
+	frame, isFrame := item.(*frameRef)
+	if !ok || !isFrame {
+		return nil, fmt.Errorf("Unknown stack frame %d", args.FrameID)
+	}
+
+	contexts, err := frame.thread.session.ContextNames(frame.depth)
+	if err != nil {
+		return nil, err
+	}
+
+	body := dap.ScopesResponseBody{Scopes: []dap.Scope{}}
+	for _, context := range contexts {
+		body.Scopes = append(body.Scopes, dap.Scope{
+			Name:               context.Name,
+			VariablesReference: adapter.handles.create(&variablesRef{thread: frame.thread, depth: frame.depth, context: context.ID}),
+			Expensive:          context.ID > 0,
+		})
+	}
+
+	return body, nil
+}
+
+func (adapter *adapter) variables(request *dap.Request) (interface{}, error) {
+	args := dap.VariablesArguments{}
+	if err := arguments(request, &args); err != nil {
+		return nil, err
+	}
+
+	item, ok := adapter.handles.get(args.VariablesReference)
+	if !ok {
+		return nil, fmt.Errorf("Unknown variables reference %d", args.VariablesReference)
+	}
+
+	body := dap.VariablesResponseBody{Variables: []dap.Variable{}}
+
+	switch ref := item.(type) {
+	case *variablesRef:
+		if ref.thread.isRunning() {
+			return nil, errRunning
+		}
+
+		var properties []dbgpxml.Property
+		var err error
+
+		if ref.fullname == "" {
+			properties, err = ref.thread.session.ContextGet(ref.context, ref.depth)
+		} else {
+			properties, err = adapter.propertyChildren(ref, args.Start, args.Count)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		ref.children = map[string]string{}
+		for _, property := range properties {
+			variable := adapter.variable(property, ref.thread, ref.depth, ref.context)
+			ref.children[variable.Name] = variable.EvaluateName
+			body.Variables = append(body.Variables, variable)
+		}
+
+	case *propertyRef:
+		for _, property := range window(ref.property.Children, args.Start, args.Count) {
+			body.Variables = append(body.Variables, adapter.variable(property, ref.thread, 0, 0))
+		}
+
+	default:
+		return nil, fmt.Errorf("Unknown variables reference %d", args.VariablesReference)
+	}
+
+	return body, nil
+}
+
+func (adapter *adapter) setVariable(request *dap.Request) (interface{}, error) {
+	args := dap.SetVariableArguments{}
+	if err := arguments(request, &args); err != nil {
+		return nil, err
+	}
+
+	item, _ := adapter.handles.get(args.VariablesReference)
+	ref, ok := item.(*variablesRef)
+	if !ok {
+		return nil, fmt.Errorf("Variable '%s' can not be changed", args.Name)
+	}
+
+	fullname, ok := ref.children[args.Name]
+	if !ok || fullname == "" {
+		return nil, fmt.Errorf("Unknown variable '%s'", args.Name)
+	}
+
+	if err := ref.thread.session.PropertySetInContext(fullname, ref.context, ref.depth, args.Value); err != nil {
+		return nil, err
+	}
+
+	property, err := ref.thread.session.PropertyGetInContext(fullname, ref.context, ref.depth, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	variable := adapter.variable(property, ref.thread, ref.depth, ref.context)
+
+	return dap.SetVariableResponseBody{Value: variable.Value, Type: variable.Type, VariablesReference: variable.VariablesReference}, nil
+}
+
+func (adapter *adapter) evaluate(request *dap.Request) (interface{}, error) {
+	args := dap.EvaluateArguments{}
+	if err := arguments(request, &args); err != nil {
+		return nil, err
+	}
+
+	var thread *thread
+	depth := 0
+
+	if item, ok := adapter.handles.get(args.FrameID); ok {
+		if frame, ok := item.(*frameRef); ok {
+			thread, depth = frame.thread, frame.depth
+		}
+	}
+	if thread == nil {
+		// Without a frame, evaluate in the first stopped session
+		for _, candidate := range adapter.allThreads() {
+			if !candidate.isRunning() {
+				thread = candidate
+				break
+			}
+		}
+	}
+	if thread == nil {
+		return nil, errNoSession
+	}
+	if thread.isRunning() {
+		return nil, errRunning
+	}
+
+	// Fetching a variable is cheaper, and has no side effects, so try that first for hovers and watches
+	var property dbgpxml.Property
+	var err error
+
+	if args.Context == "hover" || args.Context == "watch" {
+		property, err = thread.session.PropertyGetInContext(args.Expression, 0, depth, 0)
+		if err == nil {
+			variable := adapter.variable(property, thread, depth, 0)
+			return dap.EvaluateResponseBody{Result: variable.Value, Type: variable.Type, VariablesReference: variable.VariablesReference, NamedVariables: variable.NamedVariables, IndexedVariables: variable.IndexedVariables}, nil
+		}
+	}
+
+	// 'eval' always runs in the top-most frame
+	property, err = thread.session.Eval(args.Expression)
+	if err != nil {
+		return nil, err
+	}
+
+	variable := adapter.variable(property, thread, depth, 0)
+
+	return dap.EvaluateResponseBody{Result: variable.Value, Type: variable.Type, VariablesReference: variable.VariablesReference, NamedVariables: variable.NamedVariables, IndexedVariables: variable.IndexedVariables}, nil
+}
+
+func (adapter *adapter) source(request *dap.Request) (interface{}, error) {
+	args := dap.SourceArguments{}
+	if err := arguments(request, &args); err != nil {
+		return nil, err
+	}
+
+	item, _ := adapter.handles.get(args.SourceReference)
+	ref, ok := item.(*sourceRef)
+	if !ok {
+		return nil, fmt.Errorf("Unknown source reference %d", args.SourceReference)
+	}
+
+	content, err := ref.thread.session.Source(ref.uri, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	return dap.SourceResponseBody{Content: content}, nil
+}
+
+/* Running */
+
+func (adapter *adapter) continueThread(request *dap.Request) (interface{}, error) {
+	args := dap.ThreadArguments{}
+	if err := arguments(request, &args); err != nil {
+		return nil, err
+	}
+
+	thread, err := adapter.stoppedThread(args.ThreadID)
+	if err != nil {
+		return nil, err
+	}
+
+	switch request.Command {
+	case "continue":
+		adapter.resume(thread, thread.session.Run, "breakpoint")
+		return dap.ContinueResponseBody{AllThreadsContinued: false}, nil
+	case "next":
+		adapter.resume(thread, thread.session.StepOver, "step")
+	case "stepIn":
+		adapter.resume(thread, thread.session.StepInto, "step")
+	case "stepOut":
+		adapter.resume(thread, thread.session.StepOut, "step")
+	}
+
+	return nil, nil
+}
+
+func (adapter *adapter) pause(request *dap.Request) error {
+	args := dap.ThreadArguments{}
+	if err := arguments(request, &args); err != nil {
+		return err
+	}
+
+	thread, err := adapter.threadFor(args.ThreadID)
+	if err != nil {
+		return err
+	}
+
+	thread.Lock()
+	thread.pausing = true
+	thread.Unlock()
+
+	// The response to 'break' is followed by the one to the running command, which sends the 'stopped' event
+	if _, err := thread.session.Break(); err != nil {
+		thread.Lock()
+		thread.pausing = false
+		thread.Unlock()
+
+		return err
+	}
+
+	return nil
+}
+
+// Stops all scripts that are being debugged
+func (adapter *adapter) terminate() {
+	for _, thread := range adapter.allThreads() {
+		adapter.endSession(thread, thread.session.Stop)
+	}
+
+	adapter.conn.SendEvent("terminated", nil)
+}
+
+// Lets all scripts that are being debugged run to their end, or stops them
+func (adapter *adapter) disconnect(terminate bool) {
+	for _, thread := range adapter.allThreads() {
+		if terminate {
+			adapter.endSession(thread, thread.session.Stop)
+		} else {
+			adapter.endSession(thread, thread.session.Detach)
+		}
+	}
+}
+
+func (adapter *adapter) endSession(thread *thread, command func() (dbgpxml.Response, error)) {
+	// A running script has to be interrupted first, and if the engine can not do that, the only
+	// option is to drop the connection
+	if thread.isRunning() {
+		if _, err := thread.session.Break(); err != nil {
+			adapter.logger.LogWarning("dap", "Can not interrupt session %d: %s", thread.id, err)
+			thread.session.Close()
+			adapter.endThread(thread)
+			return
+		}
+	}
+
+	if _, err := command(); err != nil {
+		adapter.logger.LogWarning("dap", "Error ending session %d: %s", thread.id, err)
+	}
+
+	adapter.endThread(thread)
+}
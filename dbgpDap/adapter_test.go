@@ -0,0 +1,362 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/derickr/dbgp-tools/lib/dap"
+	"github.com/derickr/dbgp-tools/lib/engine"
+	"github.com/derickr/dbgp-tools/lib/logger"
+)
+
+const testFile = "/app/list.php"
+
+// A message from the adapter, with the fields of responses and events
+type message struct {
+	Type       string          `json:"type"`
+	RequestSeq int             `json:"request_seq"`
+	Success    bool            `json:"success"`
+	Command    string          `json:"command"`
+	Message    string          `json:"message"`
+	Event      string          `json:"event"`
+	Body       json.RawMessage `json:"body"`
+}
+
+// The editor's side of the DAP connection
+type editor struct {
+	t        *testing.T
+	conn     net.Conn
+	seq      int
+	messages chan message
+	events   []message // events that arrived while waiting for a response
+}
+
+func newEditor(t *testing.T, conn net.Conn) *editor {
+	editor := &editor{t: t, conn: conn, messages: make(chan message, 1000)}
+
+	go func() {
+		defer close(editor.messages)
+
+		reader := bufio.NewReader(conn)
+		for {
+			headers, err := textproto.NewReader(reader).ReadMIMEHeader()
+			if err != nil {
+				return
+			}
+
+			length, _ := strconv.Atoi(headers.Get("Content-Length"))
+			data := make([]byte, length)
+			if _, err := io.ReadFull(reader, data); err != nil {
+				return
+			}
+
+			received := message{}
+			if err := json.Unmarshal(data, &received); err != nil {
+				t.Errorf("invalid message %q: %s", data, err)
+				return
+			}
+			editor.messages <- received
+		}
+	}()
+
+	return editor
+}
+
+func (editor *editor) next() message {
+	editor.t.Helper()
+
+	select {
+	case received, ok := <-editor.messages:
+		if !ok {
+			editor.t.Fatalf("the adapter closed the connection")
+		}
+		return received
+	case <-time.After(5 * time.Second):
+		editor.t.Fatalf("the adapter sent nothing")
+	}
+
+	return message{}
+}
+
+// Sends a request, and decodes the body of a successful response into 'body'
+func (editor *editor) request(command string, arguments interface{}, body interface{}) message {
+	editor.t.Helper()
+
+	editor.seq++
+	data, _ := json.Marshal(map[string]interface{}{"seq": editor.seq, "type": "request", "command": command, "arguments": arguments})
+	if _, err := fmt.Fprintf(editor.conn, "Content-Length: %d\r\n\r\n%s", len(data), data); err != nil {
+		editor.t.Fatalf("could not send '%s': %s", command, err)
+	}
+
+	for {
+		received := editor.next()
+		if received.Type == "event" {
+			editor.events = append(editor.events, received)
+			continue
+		}
+
+		if received.RequestSeq != editor.seq {
+			editor.t.Fatalf("received the response to request %d, while waiting for %d", received.RequestSeq, editor.seq)
+		}
+		if !received.Success {
+			editor.t.Fatalf("'%s' failed: %s", command, received.Message)
+		}
+		if body != nil {
+			if err := json.Unmarshal(received.Body, body); err != nil {
+				editor.t.Fatalf("'%s': invalid body %s: %s", command, received.Body, err)
+			}
+		}
+
+		return received
+	}
+}
+
+// Waits for an event, and decodes its body into 'body'. Events that come before it are skipped.
+func (editor *editor) event(name string, body interface{}) {
+	editor.t.Helper()
+
+	for {
+		var received message
+		if len(editor.events) > 0 {
+			received, editor.events = editor.events[0], editor.events[1:]
+		} else {
+			received = editor.next()
+		}
+
+		if received.Type != "event" || received.Event != name {
+			continue
+		}
+
+		if body != nil {
+			if err := json.Unmarshal(received.Body, body); err != nil {
+				editor.t.Fatalf("'%s' event: invalid body %s: %s", name, received.Body, err)
+			}
+		}
+		return
+	}
+}
+
+// Holds the engine's packets back, to keep the script running for as long as the test needs
+type gatedConn struct {
+	net.Conn
+	gate sync.Mutex
+}
+
+func (conn *gatedConn) Write(data []byte) (int, error) {
+	conn.gate.Lock()
+	conn.gate.Unlock()
+
+	return conn.Conn.Write(data)
+}
+
+/*
+ * Loops over a list of 40 items twice, so that line 3 is reached twice:
+ *
+ *   step 0: line 2     step 2: line 4     step 4: line 4
+ *   step 1: line 3     step 3: line 3     step 5: line 6
+ */
+func listProgram() *engine.Program {
+	list := engine.Variable{Name: "$list", Type: "array"}
+	for i := 0; i < 40; i++ {
+		list.Children = append(list.Children, engine.Variable{Name: strconv.Itoa(i), Type: "int", Value: strconv.Itoa(i * i)})
+	}
+	variables := []engine.Variable{list}
+
+	uri := "file://" + testFile
+	program := &engine.Program{
+		Language: "PHP",
+		Files:    map[string]string{uri: "<?php\n$list = range(0, 39);\nforeach ([1, 2] as $i) {\n\techo $i;\n}\necho \"done\";\n"},
+	}
+	for _, line := range []int{2, 3, 4, 3, 4, 6} {
+		program.Steps = append(program.Steps, engine.Step{File: uri, Line: line, Depth: 1, Variables: variables})
+	}
+
+	return program
+}
+
+func freePort(t *testing.T) int {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("can not find a free port: %s", err)
+	}
+	defer listener.Close()
+
+	return listener.Addr().(*net.TCPAddr).Port
+}
+
+func startAdapter(t *testing.T) (*editor, <-chan error) {
+	editorSide, adapterSide := net.Pipe()
+	t.Cleanup(func() { editorSide.Close() })
+
+	adapter := newAdapter(dap.NewConn(adapterSide, adapterSide), settings{}, logger.NewTextLogger(io.Discard), io.Discard)
+
+	served := make(chan error, 1)
+	go func() {
+		served <- adapter.serve()
+		adapterSide.Close()
+	}()
+
+	return newEditor(t, editorSide), served
+}
+
+func TestAdapterSession(t *testing.T) {
+	editor, served := startAdapter(t)
+	port := freePort(t)
+
+	editor.request("initialize", map[string]string{"adapterID": "php"}, nil)
+	editor.event("initialized", nil)
+	editor.request("launch", map[string]int{"port": port}, nil)
+
+	// Before an engine connects, breakpoints can not be verified yet
+	breakpoints := dap.SetBreakpointsResponseBody{}
+	editor.request("setBreakpoints", dap.SetBreakpointsArguments{Source: dap.Source{Path: testFile}, Breakpoints: []dap.SourceBreakpoint{{Line: 3}}}, &breakpoints)
+	if len(breakpoints.Breakpoints) != 1 || breakpoints.Breakpoints[0].Verified || breakpoints.Breakpoints[0].Message != "Waiting for a debugging session" {
+		t.Errorf("unexpected breakpoints before the session started: %+v", breakpoints.Breakpoints)
+	}
+	editor.request("configurationDone", nil, nil)
+
+	conn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+	if err != nil {
+		t.Fatalf("can not connect to the adapter: %s", err)
+	}
+	engineConn := &gatedConn{Conn: conn}
+	go engine.NewEngine(listProgram(), engine.Options{IDEKey: "test"}).Serve(engineConn)
+	defer conn.Close()
+
+	changed := dap.BreakpointEventBody{}
+	editor.event("breakpoint", &changed)
+	if !changed.Breakpoint.Verified || changed.Breakpoint.ID != breakpoints.Breakpoints[0].ID {
+		t.Errorf("the breakpoint was not verified once the session started: %+v", changed.Breakpoint)
+	}
+
+	stopped := dap.StoppedEventBody{}
+	editor.event("stopped", &stopped)
+	if stopped.Reason != "breakpoint" {
+		t.Errorf("stopped because of '%s'", stopped.Reason)
+	}
+
+	stack := dap.StackTraceResponseBody{}
+	editor.request("stackTrace", dap.StackTraceArguments{ThreadID: stopped.ThreadID}, &stack)
+	if len(stack.StackFrames) == 0 {
+		t.Fatalf("no stack frames")
+	}
+	top := stack.StackFrames[0]
+	if top.Line != 3 || top.Source == nil || top.Source.Path != testFile {
+		t.Errorf("unexpected top frame: %+v (%+v)", top, top.Source)
+	}
+
+	scopes := dap.ScopesResponseBody{}
+	editor.request("scopes", dap.ScopesArguments{FrameID: top.ID}, &scopes)
+	if len(scopes.Scopes) == 0 {
+		t.Fatalf("no scopes")
+	}
+
+	locals := dap.VariablesResponseBody{}
+	editor.request("variables", dap.VariablesArguments{VariablesReference: scopes.Scopes[0].VariablesReference}, &locals)
+	if len(locals.Variables) != 1 || locals.Variables[0].IndexedVariables != 40 {
+		t.Fatalf("unexpected local variables: %+v", locals.Variables)
+	}
+
+	// The engine sends 32 children per page, so these come from the first two pages
+	items := dap.VariablesResponseBody{}
+	editor.request("variables", dap.VariablesArguments{VariablesReference: locals.Variables[0].VariablesReference, Start: 30, Count: 5}, &items)
+	if len(items.Variables) != 5 {
+		t.Fatalf("%d items, expected 5: %+v", len(items.Variables), items.Variables)
+	}
+	for i, item := range items.Variables {
+		index := 30 + i
+		if item.Name != strconv.Itoa(index) || item.Value != strconv.Itoa(index*index) || item.EvaluateName != fmt.Sprintf("$list[%d]", index) {
+			t.Errorf("item %d: unexpected %+v", index, item)
+		}
+	}
+
+	// While the script runs, breakpoint changes wait until it stops again
+	engineConn.gate.Lock()
+	editor.request("continue", dap.ThreadArguments{ThreadID: stopped.ThreadID}, nil)
+
+	queued := dap.SetBreakpointsResponseBody{}
+	editor.request("setBreakpoints", dap.SetBreakpointsArguments{Source: dap.Source{Path: testFile}, Breakpoints: []dap.SourceBreakpoint{{Line: 3}, {Line: 6}}}, &queued)
+	for _, breakpoint := range queued.Breakpoints {
+		if breakpoint.Verified || breakpoint.Message != "Waiting for the script to pause" {
+			t.Errorf("a breakpoint was changed while the script ran: %+v", breakpoint)
+		}
+	}
+	engineConn.gate.Unlock()
+
+	editor.event("stopped", &stopped)
+	editor.request("stackTrace", dap.StackTraceArguments{ThreadID: stopped.ThreadID}, &stack)
+	if line := stack.StackFrames[0].Line; line != 3 {
+		t.Errorf("stopped on line %d, expected the second time on line 3", line)
+	}
+
+	editor.request("continue", dap.ThreadArguments{ThreadID: stopped.ThreadID}, nil)
+
+	// The engine may also report a breakpoint as resolved, so the same one can be changed twice
+	verified := map[int]bool{}
+	for _, breakpoint := range queued.Breakpoints {
+		verified[breakpoint.ID] = false
+	}
+	for remaining := len(verified); remaining > 0; {
+		editor.event("breakpoint", &changed)
+
+		done, known := verified[changed.Breakpoint.ID]
+		if !known || !changed.Breakpoint.Verified {
+			t.Fatalf("unexpected change %+v", changed.Breakpoint)
+		}
+		if !done {
+			verified[changed.Breakpoint.ID] = true
+			remaining--
+		}
+	}
+
+	editor.event("stopped", &stopped)
+	editor.request("stackTrace", dap.StackTraceArguments{ThreadID: stopped.ThreadID}, &stack)
+	if line := stack.StackFrames[0].Line; line != 6 {
+		t.Errorf("stopped on line %d, expected the queued breakpoint on line 6", line)
+	}
+
+	editor.request("disconnect", nil, nil)
+
+	select {
+	case err := <-served:
+		if err != nil {
+			t.Errorf("the adapter failed: %s", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("the adapter did not stop")
+	}
+}
+
+func TestMergeBreakpointResults(t *testing.T) {
+	waiting := dap.Breakpoint{ID: 1, Message: "Waiting for the script to pause"}
+	verified := dap.Breakpoint{ID: 1, Verified: true, Line: 4}
+	failed := dap.Breakpoint{ID: 1, Message: "Invalid condition"}
+
+	tests := []struct {
+		name     string
+		results  []dap.Breakpoint
+		more     []dap.Breakpoint
+		expected []dap.Breakpoint
+	}{
+		{"first session", []dap.Breakpoint{waiting}, []dap.Breakpoint{verified}, []dap.Breakpoint{verified}},
+		{"verified stays", []dap.Breakpoint{verified}, []dap.Breakpoint{failed}, []dap.Breakpoint{verified}},
+		{"failure replaces waiting", []dap.Breakpoint{waiting}, []dap.Breakpoint{failed}, []dap.Breakpoint{failed}},
+		{"fewer results", []dap.Breakpoint{waiting, waiting}, []dap.Breakpoint{verified}, []dap.Breakpoint{verified, waiting}},
+	}
+
+	for _, test := range tests {
+		actual := mergeBreakpointResults(append([]dap.Breakpoint{}, test.results...), test.more)
+
+		if fmt.Sprint(actual) != fmt.Sprint(test.expected) {
+			t.Errorf("%s: got %+v, expected %+v", test.name, actual, test.expected)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/derickr/dbgp-tools/lib/dap"
+	"github.com/derickr/dbgp-tools/lib/protocol"
+)
+
+// A breakpoint that the editor has set in a file, with the ID it knows it by
+type lineBreakpoint struct {
+	dap.SourceBreakpoint
+	id int
+}
+
+// The breakpoints that the editor has set, which are set again in each new debugging session
+type breakpointStore struct {
+	next        int
+	sources     map[string]dap.Source // by file URI
+	lines       map[string][]lineBreakpoint
+	functions   []dap.FunctionBreakpoint
+	functionIDs []int
+	exceptions  bool
+}
+
+func newBreakpointStore() *breakpointStore {
+	return &breakpointStore{sources: map[string]dap.Source{}, lines: map[string][]lineBreakpoint{}}
+}
+
+func (store *breakpointStore) setLines(uri string, source dap.Source, breakpoints []dap.SourceBreakpoint) []lineBreakpoint {
+	stored := []lineBreakpoint{}
+	for _, breakpoint := range breakpoints {
+		store.next++
+		stored = append(stored, lineBreakpoint{SourceBreakpoint: breakpoint, id: store.next})
+	}
+
+	store.sources[uri] = source
+	store.lines[uri] = stored
+
+	return stored
+}
+
+func (store *breakpointStore) setFunctions(breakpoints []dap.FunctionBreakpoint) []int {
+	store.functions = breakpoints
+	store.functionIDs = nil
+	for range breakpoints {
+		store.next++
+		store.functionIDs = append(store.functionIDs, store.next)
+	}
+
+	return store.functionIDs
+}
+
+var hitConditionRegexp = regexp.MustCompile(`^\s*(>=|==|%)?\s*(\d+)\s*$`)
+
+// Converts a DAP hit condition, such as "5", ">= 5", "== 5", or "% 5", to the DBGp hit value and condition
+func breakpointOptions(hitCondition string) (protocol.BreakpointOptions, error) {
+	options := protocol.BreakpointOptions{}
+
+	if hitCondition == "" {
+		return options, nil
+	}
+
+	match := hitConditionRegexp.FindStringSubmatch(hitCondition)
+	if match == nil {
+		return options, fmt.Errorf("Unsupported hit condition '%s', use a number, optionally preceded by '>=', '==', or '%%'", hitCondition)
+	}
+
+	options.HitValue, _ = strconv.Atoi(match[2])
+	options.HitCondition = match[1]
+	if options.HitCondition == "" {
+		options.HitCondition = ">="
+	}
+
+	return options, nil
+}
+
+func lineBreakpointSpec(uri string, breakpoint lineBreakpoint) (protocol.BreakpointSpec, error) {
+	options, err := breakpointOptions(breakpoint.HitCondition)
+	if err != nil {
+		return nil, err
+	}
+
+	if breakpoint.Condition != "" {
+		return protocol.ConditionalBreakpoint{BreakpointOptions: options, Filename: uri, LineNo: breakpoint.Line, Expression: breakpoint.Condition}, nil
+	}
+
+	return protocol.LineBreakpoint{BreakpointOptions: options, Filename: uri, LineNo: breakpoint.Line}, nil
+}
+
+// Function breakpoints are either "function", "Class::method", or "Class->method"
+func functionBreakpointSpec(breakpoint dap.FunctionBreakpoint) (protocol.BreakpointSpec, error) {
+	options, err := breakpointOptions(breakpoint.HitCondition)
+	if err != nil {
+		return nil, err
+	}
+	if breakpoint.Condition != "" {
+		return nil, fmt.Errorf("Function breakpoints can not have a condition")
+	}
+
+	name := strings.TrimSuffix(strings.TrimSpace(breakpoint.Name), "()")
+	for _, separator := range []string{"::", "->"} {
+		if class, method, found := strings.Cut(name, separator); found {
+			return protocol.CallBreakpoint{BreakpointOptions: options, Classname: class, Function: method}, nil
+		}
+	}
+
+	return protocol.CallBreakpoint{BreakpointOptions: options, Function: name}, nil
+}
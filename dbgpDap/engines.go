@@ -0,0 +1,384 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/derickr/dbgp-tools/lib/connections"
+	"github.com/derickr/dbgp-tools/lib/dap"
+	"github.com/derickr/dbgp-tools/lib/dbgpxml"
+	"github.com/derickr/dbgp-tools/lib/protocol"
+)
+
+/*
+ * Each connection from a debugging engine (each PHP request) is shown in the
+ * editor as a thread. Its ID is the number of the connection.
+ */
+type thread struct {
+	sync.Mutex
+	id      int
+	name    string
+	session *protocol.Session
+	running bool
+	pausing bool
+
+	// The engine's breakpoint IDs, by file URI, and for function and exception breakpoints
+	lineBreakpoints  map[string][]string
+	otherBreakpoints []string
+
+	// The editor's breakpoint IDs, by the engine's breakpoint ID
+	editorIDs map[string]int
+
+	// Breakpoint changes that the editor made while the script ran, which are made in the engine
+	// before it continues, by file URI, or "" for the function and exception breakpoints
+	queued         map[string]func() []dap.Breakpoint
+	breakpointLock sync.Mutex // held while breakpoints are changed, so that the script can not continue
+
+	// With Xdebug Cloud, the session outlives the thread, so these end with the thread instead
+	streams       <-chan dbgpxml.Stream
+	notifications <-chan dbgpxml.Notify
+
+	ended chan struct{}
+}
+
+func (thread *thread) isRunning() bool {
+	thread.Lock()
+	defer thread.Unlock()
+
+	return thread.running
+}
+
+/*
+ * Makes a change to the breakpoints in the engine right away if the script is
+ * stopped, and otherwise queues it until the script continues, as engines do
+ * not accept commands while it runs. A queued change replaces an earlier one
+ * with the same key. Returns the change's result, and whether it was made.
+ */
+func (thread *thread) changeBreakpoints(key string, change func() []dap.Breakpoint) ([]dap.Breakpoint, bool) {
+	thread.breakpointLock.Lock()
+	defer thread.breakpointLock.Unlock()
+
+	thread.Lock()
+	running := thread.running
+	if running {
+		thread.queued[key] = change
+	}
+	thread.Unlock()
+
+	if running {
+		return nil, false
+	}
+
+	return change(), true
+}
+
+// Starts listening for engines, registers with a proxy, or connects to Xdebug Cloud, as the
+// settings say
+func (adapter *adapter) startEngines() error {
+	if adapter.settings.cloudUser != "" {
+		conn, err := connections.ConnectToCloud(CloudDomain, CloudPort, adapter.settings.cloudUser, adapter.logger)
+		if err != nil {
+			return fmt.Errorf("Can not connect to Xdebug Cloud at '%s': %w", CloudDomain, err)
+		}
+
+		session, err := protocol.NewCloudSession(conn, adapter.settings.cloudUser, adapter.logger)
+		if err != nil {
+			conn.Close()
+			return err
+		}
+
+		adapter.cloud = session
+		adapter.output("console", fmt.Sprintf("Connected to Xdebug Cloud as '%s'\n", adapter.settings.cloudUser))
+
+		go adapter.cloudLoop(adapter.cloud)
+
+		return nil
+	}
+
+	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", adapter.settings.port))
+	if err != nil {
+		return err
+	}
+	adapter.listener = listener
+
+	if adapter.settings.ideKey != "" {
+		if err := adapter.proxyCommand(protocol.NewCommandLine("proxyinit", "-m", "1", "-k", adapter.settings.ideKey, "-p", strconv.Itoa(adapter.settings.port))); err != nil {
+			listener.Close()
+			return fmt.Errorf("Error registering with proxy: %w", err)
+		}
+		adapter.registered = true
+	}
+
+	adapter.output("console", fmt.Sprintf("Waiting for debug server to connect on port %d.\n", adapter.settings.port))
+
+	go adapter.acceptLoop(listener)
+
+	return nil
+}
+
+func (adapter *adapter) proxyCommand(command *protocol.CommandLine) error {
+	conn, err := connections.ConnectTo(adapter.settings.proxy, nil)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	return protocol.NewDbgpClient(conn, adapter.logger).RunCommand(command.String())
+}
+
+func (adapter *adapter) stopEngines() {
+	if adapter.listener != nil {
+		adapter.listener.Close()
+	}
+
+	if adapter.registered {
+		err := adapter.proxyCommand(protocol.NewCommandLine("proxystop", "-k", adapter.settings.ideKey))
+		if err != nil {
+			adapter.logger.LogWarning("dap", "Error unregistering from proxy: %s", err)
+		}
+	}
+
+	if adapter.cloud != nil {
+		adapter.cloud.Close()
+		protocol.UnregisterCloudClient(CloudDomain, CloudPort, adapter.settings.cloudUser, adapter.logWriter, adapter.logger)
+	}
+}
+
+func (adapter *adapter) acceptLoop(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+
+		adapter.logger.LogInfo("dap", "Connect from %s", conn.RemoteAddr())
+
+		go func() {
+			session := protocol.NewSession(conn, adapter.logger)
+			defer session.Close()
+
+			init, err := session.WaitForInit(10 * time.Second)
+			if err != nil {
+				adapter.logger.LogWarning("dap", "Error while waiting for init packet: %s", err)
+				return
+			}
+
+			adapter.debug(session, init)
+		}()
+	}
+}
+
+// With Xdebug Cloud, all debugging sessions arrive one after the other on the same connection
+func (adapter *adapter) cloudLoop(session *protocol.Session) {
+	for {
+		init, err := session.WaitForInit(time.Hour)
+		if err != nil {
+			select {
+			case <-session.Done():
+				adapter.output("console", "Disconnected from Xdebug Cloud\n")
+				return
+			default:
+				continue
+			}
+		}
+
+		adapter.debug(session, init)
+	}
+}
+
+func (adapter *adapter) threadName(id int, init dbgpxml.Init) string {
+	name := fmt.Sprintf("Request %d", id)
+	if filename, ok := adapter.localPath(init.FileURI); ok {
+		name = fmt.Sprintf("%s (%s)", name, filepath.Base(filename))
+	}
+
+	return name
+}
+
+// Runs a debugging session from its init packet until the engine is done
+func (adapter *adapter) debug(session *protocol.Session, init dbgpxml.Init) {
+	adapter.Lock()
+	adapter.nextThread++
+	thread := &thread{
+		id:              adapter.nextThread,
+		name:            adapter.threadName(adapter.nextThread, init),
+		session:         session,
+		lineBreakpoints: map[string][]string{},
+		editorIDs:       map[string]int{},
+		queued:          map[string]func() []dap.Breakpoint{},
+		streams:         session.SubscribeStreams(),
+		notifications:   session.SubscribeNotifications(),
+		ended:           make(chan struct{}),
+	}
+	adapter.threads[thread.id] = thread
+	adapter.Unlock()
+
+	adapter.logger.LogInfo("dap", "Debugging session %d started for '%s'", thread.id, init.FileURI)
+	adapter.conn.SendEvent("thread", dap.ThreadEventBody{Reason: "started", ThreadID: thread.id})
+
+	go adapter.forwardStreams(thread)
+	go adapter.forwardNotifications(thread)
+
+	for _, feature := range []string{"resolved_breakpoints", "notify_ok", "extended_properties"} {
+		if err := session.FeatureSet(feature, "1"); err != nil {
+			adapter.logger.LogWarning("dap", "Can not enable feature '%s': %s", feature, err)
+		}
+	}
+	if err := session.Stdout(1); err != nil {
+		adapter.logger.LogWarning("dap", "Can not copy stdout: %s", err)
+	}
+
+	select {
+	case <-adapter.configured:
+	case <-session.Done():
+	}
+
+	adapter.Lock()
+	uris := []string{}
+	for uri := range adapter.breakpoints.lines {
+		uris = append(uris, uri)
+	}
+	adapter.Unlock()
+
+	for _, uri := range uris {
+		results, _ := thread.changeBreakpoints(uri, func() []dap.Breakpoint { return adapter.setLineBreakpoints(thread, uri) })
+		adapter.sendBreakpointEvents(results)
+	}
+	results, _ := thread.changeBreakpoints("", func() []dap.Breakpoint { return adapter.setOtherBreakpoints(thread) })
+	adapter.sendBreakpointEvents(results)
+
+	if adapter.settings.stopOnEntry {
+		adapter.resume(thread, session.StepInto, "entry")
+	} else {
+		adapter.resume(thread, session.Run, "breakpoint")
+	}
+
+	select {
+	case <-thread.ended:
+	case <-session.Done():
+		adapter.endThread(thread)
+	}
+}
+
+func (adapter *adapter) endThread(thread *thread) {
+	adapter.Lock()
+	_, found := adapter.threads[thread.id]
+	delete(adapter.threads, thread.id)
+	adapter.Unlock()
+
+	if !found {
+		return
+	}
+
+	adapter.handles.reset(thread)
+	thread.session.UnsubscribeStreams(thread.streams)
+	thread.session.UnsubscribeNotifications(thread.notifications)
+	close(thread.ended)
+
+	adapter.logger.LogInfo("dap", "Debugging session %d ended", thread.id)
+	adapter.conn.SendEvent("thread", dap.ThreadEventBody{Reason: "exited", ThreadID: thread.id})
+}
+
+/*
+ * Runs a continuation command. The editor expects an immediate response to
+ * 'continue', 'next', and friends, and a 'stopped' event once the engine
+ * breaks again, which is why the command runs in the background.
+ */
+func (adapter *adapter) resume(thread *thread, command func() (dbgpxml.Response, error), reason string) {
+	thread.breakpointLock.Lock()
+
+	thread.Lock()
+	queued := thread.queued
+	thread.queued = map[string]func() []dap.Breakpoint{}
+	thread.Unlock()
+
+	keys := []string{}
+	for key := range queued {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		adapter.sendBreakpointEvents(queued[key]())
+	}
+
+	thread.Lock()
+	thread.running = true
+	thread.pausing = false
+	thread.Unlock()
+
+	thread.breakpointLock.Unlock()
+
+	adapter.handles.reset(thread)
+
+	go func() {
+		response, err := command()
+
+		thread.Lock()
+		thread.running = false
+		if thread.pausing {
+			reason = "pause"
+		}
+		thread.Unlock()
+
+		if err != nil {
+			adapter.logger.LogWarning("dap", "Error while running session %d: %s", thread.id, err)
+			adapter.endThread(thread)
+			return
+		}
+
+		switch response.Status {
+		case "break":
+			adapter.conn.SendEvent("stopped", dap.StoppedEventBody{Reason: reason, ThreadID: thread.id})
+
+		case "stopping", "stopped":
+			// Let the script finish, and end the session, rather than keeping PHP waiting
+			if response.Status == "stopping" {
+				thread.session.Stop()
+			}
+			adapter.endThread(thread)
+		}
+	}()
+}
+
+func (adapter *adapter) output(category string, text string) {
+	adapter.conn.SendEvent("output", dap.OutputEventBody{Category: category, Output: text})
+}
+
+func (adapter *adapter) forwardStreams(thread *thread) {
+	for stream := range thread.streams {
+		text := stream.Value
+		if stream.Encoding == "base64" {
+			decoded, _ := base64.StdEncoding.DecodeString(stream.Value)
+			text = string(decoded)
+		}
+
+		adapter.output(stream.Type, text)
+	}
+}
+
+func (adapter *adapter) forwardNotifications(thread *thread) {
+	for notify := range thread.notifications {
+		if notify.Name != "breakpoint_resolved" {
+			adapter.output("console", fmt.Sprintf("Notification: %s\n", notify.Name))
+			continue
+		}
+
+		thread.Lock()
+		editorID, found := thread.editorIDs[strconv.Itoa(notify.Breakpoint.ID)]
+		thread.Unlock()
+
+		if found {
+			adapter.conn.SendEvent("breakpoint", dap.BreakpointEventBody{
+				Reason:     "changed",
+				Breakpoint: dap.Breakpoint{ID: editorID, Verified: true, Line: notify.Breakpoint.LineNo},
+			})
+		}
+	}
+}
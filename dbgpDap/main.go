@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+
+	"github.com/derickr/dbgp-tools/lib/dap"
+	"github.com/derickr/dbgp-tools/lib/logger"
+	"github.com/derickr/dbgp-tools/lib/pathmap"
+	"github.com/pborman/getopt/v2" // BSD-3
+)
+
+var clientVersion = "0.1.0"
+var clientYear    = "2025"
+
+var (
+	CloudDomain = "cloud.xdebug.com"
+	CloudPort   = "9021"
+	cloudUser   = ""
+	help        = false
+	listen      = ""
+	logFile     = ""
+	pathMaps    = []string{}
+	port        = 9003
+	proxy       = "localhost:9001"
+	register    = ""
+	stopOnEntry = false
+	verbose     = false
+	version     = false
+)
+
+// Standard output carries the DAP messages when talking over stdio, so everything else goes to stderr
+func printVersion() {
+	fmt.Fprintf(os.Stderr, "Xdebug DBGp to Debug Adapter Protocol bridge (%s)\n", clientVersion)
+	fmt.Fprintf(os.Stderr, "Copyright 2025-%s by Derick Rethans\n", clientYear)
+}
+
+func displayHelp() {
+	fmt.Fprintf(os.Stderr, `
+Lets editors that speak the Debug Adapter Protocol (DAP), such as VS Code and
+Neovim, debug PHP with Xdebug. The editor starts dbgpDap, and talks DAP to it
+over stdin and stdout, or connects to it with --listen. dbgpDap then waits for
+Xdebug to connect on the DBGp port, registers with a DBGp proxy, or connects
+to Xdebug Cloud.
+
+The 'launch' and 'attach' requests accept the arguments "port", "proxy",
+"ideKey" (to register with the proxy), "cloudUser", "stopOnEntry", and
+"pathMappings", which override the options below. "pathMappings" maps
+directories on the debugging engine's side to local ones, such as
+{"/var/www": "/home/me/project"}, for when PHP runs in a container, or on
+another machine.
+`)
+}
+
+func handleArguments() {
+	getopt.Flag(&help, 'h', "Show this help")
+	getopt.Flag(&version, 'v', "Show version number and exit")
+	getopt.FlagLong(&listen, "listen", 'l', "Accept an editor on this address, instead of talking DAP over stdin and stdout", "host:port")
+	getopt.Flag(&port, 'p', "Specify the port to listen on for debugging engines")
+	getopt.FlagLong(&proxy, "proxy", 'y', "The DBGp proxy to register with", "host:port")
+	getopt.FlagLong(&register, "register", 'r', "Register with the DBGp proxy with this IDE key", "idekey")
+	getopt.FlagLong(&cloudUser, "cloud", 'c', "Connect to Xdebug Cloud", "cloud-user-id")
+	getopt.FlagLong(&stopOnEntry, "stop-on-entry", 0, "Break on the first line of each script")
+	getopt.FlagLong(&pathMaps, "path-map", 0, "Map a directory on the debugging engine's side to a local one, such as /var/www=/home/me/project (can be repeated)", "remote=local")
+	getopt.FlagLong(&logFile, "log-file", 0, "Write log messages to this file, instead of to stderr", "file")
+	getopt.FlagLong(&verbose, "verbose", 0, "Log each DAP request")
+
+	getopt.Parse()
+
+	if version {
+		printVersion()
+		os.Exit(0)
+	}
+
+	if help || getopt.NArgs() > 0 {
+		printVersion()
+		displayHelp()
+		fmt.Fprintf(os.Stderr, "\n")
+		getopt.PrintUsage(os.Stderr)
+		os.Exit(1)
+	}
+}
+
+func serve(reader io.Reader, writer io.Writer, mappings pathmap.Mappings, logOutput logger.Logger, logWriter io.Writer) {
+	defaults := settings{port: port, proxy: proxy, ideKey: register, cloudUser: cloudUser, stopOnEntry: stopOnEntry, pathMaps: mappings}
+
+	adapter := newAdapter(dap.NewConn(reader, writer), defaults, logOutput, logWriter)
+	adapter.verbose = verbose
+
+	if err := adapter.serve(); err != nil {
+		logOutput.LogError("dap", "Error while talking to the editor: %s", err)
+	}
+}
+
+func main() {
+	handleArguments()
+
+	mappings, err := parsePathMaps(pathMaps)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
+	}
+
+	var logWriter io.Writer = os.Stderr
+
+	if logFile != "" {
+		file, err := os.OpenFile(logFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Can not open log file: %s\n", err)
+			os.Exit(2)
+		}
+		defer file.Close()
+
+		logWriter = file
+	}
+
+	logOutput := logger.NewTextLogger(logWriter)
+
+	if listen == "" {
+		serve(os.Stdin, os.Stdout, mappings, logOutput, logWriter)
+		return
+	}
+
+	l, err := net.Listen("tcp", listen)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(2)
+	}
+	defer l.Close()
+
+	logOutput.LogInfo("dap", "Waiting for an editor to connect on %s", listen)
+
+	// One editor at a time, as each one needs the DBGp port to itself
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			logOutput.LogError("dap", "%s", err)
+			return
+		}
+
+		logOutput.LogInfo("dap", "Editor connected from %s", c.RemoteAddr())
+		serve(c, c, mappings, logOutput, logWriter)
+		c.Close()
+		logOutput.LogInfo("dap", "Editor disconnected")
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"sort"
+
+	"github.com/derickr/dbgp-tools/lib/pathmap"
+)
+
+// Parses the --path-map flags, each in the form "remote=local"
+func parsePathMaps(specs []string) (pathmap.Mappings, error) {
+	mappings := pathmap.Mappings{}
+
+	for _, spec := range specs {
+		mapping, err := pathmap.Parse(spec)
+		if err != nil {
+			return nil, err
+		}
+		mappings = append(mappings, mapping)
+	}
+
+	return mappings, nil
+}
+
+// Parses the 'pathMappings' launch argument, which maps remote directories to local ones
+func parsePathMappings(arguments map[string]string) (pathmap.Mappings, error) {
+	specs := []string{}
+	for remote, local := range arguments {
+		specs = append(specs, remote+"="+local)
+	}
+	sort.Strings(specs)
+
+	return parsePathMaps(specs)
+}
+
+// Converts a URI that the engine uses to a local file name. URIs of other types, such as
+// 'dbgp://' for code that was created with eval(), are returned with 'false'.
+func (adapter *adapter) localPath(uri string) (string, bool) {
+	if name, ok := adapter.settings.pathMaps.ToLocal(uri); ok {
+		return name, true
+	}
+
+	return pathmap.URIPath(uri)
+}
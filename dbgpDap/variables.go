@@ -0,0 +1,220 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"sync"
+
+	"github.com/derickr/dbgp-tools/lib/dap"
+	"github.com/derickr/dbgp-tools/lib/dbgpxml"
+)
+
+// A stack frame of a thread
+type frameRef struct {
+	thread *thread
+	depth  int
+}
+
+// A context (scope), or a property with children, that can be fetched from the engine
+type variablesRef struct {
+	thread   *thread
+	depth    int
+	context  int
+	fullname string // empty for the context itself
+
+	// The full names of the children, by name, once they have been listed
+	children map[string]string
+}
+
+// A property whose children are already known, such as the result of 'eval', which can not
+// be fetched again by name
+type propertyRef struct {
+	thread   *thread
+	property dbgpxml.Property
+}
+
+// A file that is not on disk, such as code created with eval(), which is fetched with 'source'
+type sourceRef struct {
+	thread *thread
+	uri    string
+}
+
+/*
+ * DAP refers to frames, scopes, and variables with numbers, which remain valid
+ * until execution continues. The handles map these numbers to what they refer
+ * to in the DBGp session.
+ */
+type handles struct {
+	sync.Mutex
+	next  int
+	items map[int]interface{}
+}
+
+func newHandles() *handles {
+	return &handles{items: map[int]interface{}{}}
+}
+
+func (handles *handles) create(item interface{}) int {
+	handles.Lock()
+	defer handles.Unlock()
+
+	handles.next++
+	handles.items[handles.next] = item
+
+	return handles.next
+}
+
+func (handles *handles) get(id int) (interface{}, bool) {
+	handles.Lock()
+	defer handles.Unlock()
+
+	item, ok := handles.items[id]
+
+	return item, ok
+}
+
+// Forgets the handles of a thread, after it continued or ended
+func (handles *handles) reset(owner *thread) {
+	handles.Lock()
+	defer handles.Unlock()
+
+	for id, item := range handles.items {
+		switch item := item.(type) {
+		case *frameRef:
+			if item.thread == owner {
+				delete(handles.items, id)
+			}
+		case *variablesRef:
+			if item.thread == owner {
+				delete(handles.items, id)
+			}
+		case *propertyRef:
+			if item.thread == owner {
+				delete(handles.items, id)
+			}
+		}
+	}
+}
+
+func decodeIfNeeded(plain string, encoded string) string {
+	if plain != "" || encoded == "" {
+		return plain
+	}
+
+	value, _ := base64.StdEncoding.DecodeString(encoded)
+
+	return string(value)
+}
+
+func propertyName(property dbgpxml.Property) string {
+	return decodeIfNeeded(property.Name, property.ExtName)
+}
+
+func propertyFullName(property dbgpxml.Property) string {
+	return decodeIfNeeded(property.Fullname, property.ExtFullName)
+}
+
+func propertyClass(property dbgpxml.Property) string {
+	return decodeIfNeeded(property.Classname, property.ExtClassname)
+}
+
+// Formats the value of a property like PHP's var_export() would, roughly
+func propertyValue(property dbgpxml.Property) string {
+	switch property.Type {
+	case "array":
+		return fmt.Sprintf("array(%d)", property.NumChildren)
+	case "object":
+		return propertyClass(property)
+	case "null":
+		return "null"
+	case "uninitialized":
+		return "uninitialized"
+	case "bool":
+		if property.DecodedValue() == "1" {
+			return "true"
+		}
+		return "false"
+	case "string":
+		return fmt.Sprintf("%q", property.DecodedValue())
+	}
+
+	return property.DecodedValue()
+}
+
+// Converts a property, creating a handle for its children when it has any
+func (adapter *adapter) variable(property dbgpxml.Property, thread *thread, depth int, context int) dap.Variable {
+	variable := dap.Variable{
+		Name:         propertyName(property),
+		Value:        propertyValue(property),
+		Type:         property.Type,
+		EvaluateName: propertyFullName(property),
+	}
+
+	if property.HasChildren && property.NumChildren > 0 {
+		if variable.EvaluateName != "" {
+			variable.VariablesReference = adapter.handles.create(&variablesRef{thread: thread, depth: depth, context: context, fullname: variable.EvaluateName})
+		} else {
+			variable.VariablesReference = adapter.handles.create(&propertyRef{thread: thread, property: property})
+		}
+
+		if property.Type == "array" {
+			variable.IndexedVariables = property.NumChildren
+		} else {
+			variable.NamedVariables = property.NumChildren
+		}
+	}
+
+	return variable
+}
+
+// Fetches the children of a property in the pages that cover 'start' to 'start+count', or
+// all of them when count is 0
+func (adapter *adapter) propertyChildren(ref *variablesRef, start int, count int) ([]dbgpxml.Property, error) {
+	property, err := ref.thread.session.PropertyGetInContext(ref.fullname, ref.context, ref.depth, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	pageSize := property.PageSize
+	if pageSize <= 0 || len(property.Children) >= property.NumChildren {
+		return window(property.Children, start, count), nil
+	}
+
+	end := property.NumChildren
+	if count > 0 && start+count < end {
+		end = start + count
+	}
+
+	children := []dbgpxml.Property{}
+	for page := start / pageSize; page*pageSize < end; page++ {
+		pageProperty := property
+		if page > 0 {
+			pageProperty, err = ref.thread.session.PropertyGetInContext(ref.fullname, ref.context, ref.depth, page)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		for i, child := range pageProperty.Children {
+			index := page*pageSize + i
+			if index >= start && index < end {
+				children = append(children, child)
+			}
+		}
+	}
+
+	return children, nil
+}
+
+func window(properties []dbgpxml.Property, start int, count int) []dbgpxml.Property {
+	if start > len(properties) {
+		return nil
+	}
+
+	properties = properties[start:]
+	if count > 0 && count < len(properties) {
+		properties = properties[:count]
+	}
+
+	return properties
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/bitbored/go-ansicon" // BSD-3
+	"github.com/derickr/dbgp-tools/lib/engine"
+	"github.com/derickr/dbgp-tools/lib/recorder"
+	. "github.com/logrusorgru/aurora" // WTFPL
+	"github.com/pborman/getopt/v2"    // BSD-3
+)
+
+var clientVersion = engine.Version
+var clientYear    = "2025"
+
+var (
+	help        = false
+	ideAddress  = "localhost:9003"
+	ideKey      = ""
+	cloudUser   = ""
+	programFile = ""
+	sessions    = 1
+	showXML     = false
+	version     = false
+	output      = ansicon.Convert(os.Stdout)
+)
+
+func printVersion() {
+	fmt.Fprintf(output, "Xdebug DBGp simulated engine (%s)\n", Bold(clientVersion))
+	fmt.Fprintf(output, "Copyright 2025-%s by Derick Rethans\n", clientYear)
+}
+
+func displayHelp() {
+	fmt.Fprintf(output, `
+A stand-in for PHP with Xdebug, to try out IDEs, dbgpClient, and dbgpProxy.
+Like Xdebug, it connects to the IDE (or proxy), and then "executes" a
+program, answering the common DBGp commands: status, run, step_into,
+step_over, step_out, stack_get, context_get, property_get, breakpoint_set
+and friends, source, eval, feature_get and feature_set, and stdout.
+
+A program is a JSON file with the source of each file, and the steps that
+execution goes through, with for each step its file and line, the call
+depth and function, the variables in scope, and the output and notices it
+produces. Without a program, a built-in demo script is "executed".
+`)
+}
+
+func handleArguments() {
+	getopt.Flag(&help, 'h', "Show this help")
+	getopt.Flag(&version, 'v', "Show version number and exit")
+	getopt.Flag(&showXML, 'x', "Show protocol XML")
+	getopt.FlagLong(&ideAddress, "ide", 'c', "The address of the IDE or proxy to connect to", "host:port")
+	getopt.FlagLong(&ideKey, "idekey", 'k', "The IDE key to send in the init packet", "key")
+	getopt.FlagLong(&cloudUser, "cloud-user", 'u', "The Xdebug Cloud user ID to send in the init packet", "id")
+	getopt.FlagLong(&sessions, "sessions", 'n', "How many debugging sessions to run, one after the other (0 = until interrupted)", "count")
+
+	getopt.SetParameters("[program.json]")
+	getopt.Parse()
+
+	if version {
+		printVersion()
+		os.Exit(0)
+	}
+
+	if help || getopt.NArgs() > 1 {
+		printVersion()
+		displayHelp()
+		fmt.Fprintf(output, "\n")
+		getopt.PrintUsage(os.Stdout)
+		os.Exit(1)
+	}
+
+	if getopt.NArgs() == 1 {
+		programFile = getopt.Arg(0)
+	}
+}
+
+func showPacket(direction recorder.Direction, data string) {
+	if direction == recorder.ToEngine {
+		fmt.Fprintf(output, "%s %s\n", BrightGreen(">"), data)
+		return
+	}
+
+	if showXML {
+		fmt.Fprintf(output, "%s\n", Faint(data))
+	}
+}
+
+func main() {
+	handleArguments()
+
+	program := engine.DemoProgram()
+	if programFile != "" {
+		var err error
+
+		program, err = engine.LoadProgram(programFile)
+		if err != nil {
+			fmt.Fprintf(output, "%s: %s\n", BrightRed("Error"), err)
+			os.Exit(2)
+		}
+	}
+
+	simulator := engine.NewEngine(program, engine.Options{IDEKey: ideKey, CloudUserID: cloudUser})
+	simulator.SetTrace(showPacket)
+
+	for i := 1; sessions == 0 || i <= sessions; i++ {
+		fmt.Fprintf(output, "Connecting to %s\n", ideAddress)
+
+		if err := simulator.Connect(ideAddress); err != nil {
+			fmt.Fprintf(output, "%s: %s\n", BrightRed("Error while debugging"), err)
+			os.Exit(3)
+		}
+
+		fmt.Fprintf(output, "Disconnect\n")
+	}
+}
@@ -3,10 +3,23 @@
 package main
 
 import (
+	"errors"
+	"github.com/derickr/dbgp-tools/lib/config"
 	"github.com/pborman/getopt/v2" // BSD-3
 )
 
+// Cloud settings are only used when the proxy starts
+var cloudRestartSettings = []configKey{{"cloud", "domain"}, {"cloud", "port"}, {"cloud", "user"}}
+
 func handleCloudFlags() {
 	getopt.FlagLong(&cloudUser, "cloud", 'c', "Connect to Xdebug Cloud", "cloud-user-id")
 	getopt.FlagLong(&disCloudUser, "discloud", 'd', "Disconnect from Xdebug Cloud", "cloud-user-id")
 }
+
+func applyCloudConfig(cfg *config.Config) error {
+	return errors.Join(
+		cfg.String("cloud", "domain", &CloudDomain),
+		cfg.String("cloud", "port", &CloudPort),
+		cfg.String("cloud", "user", &cloudUser),
+	)
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"errors"
+	"github.com/derickr/dbgp-tools/lib/config"
+	"github.com/derickr/dbgp-tools/lib/logger"
+	"os"
+)
+
+/*
+ * Settings are read from /etc/xdebug/dbgpProxy.ini and then from
+ * ~/.config/xdebug/dbgpProxy.ini, or only from the file given with --config.
+ * Command line flags override them. For example:
+ *
+ *   [proxy]
+ *   client = 0.0.0.0:9001
+ *   server = 0.0.0.0:9003
+ *   force-add = yes
+ *
+ *   [ssl]
+ *   certificate = /etc/letsencrypt/live/proxy.example.com/fullchain.pem
+ *   key = /etc/letsencrypt/live/proxy.example.com/privkey.pem
+ *
+ *   [log]
+ *   format = json
+ *   level = warning
+ */
+func loadConfig() (*config.Config, error) {
+	cfg, err := readConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	err = errors.Join(
+		cfg.String("proxy", "client", &clientAddress),
+		cfg.String("proxy", "server", &serverAddress),
+		cfg.String("proxy", "client-ssl", &clientSSLAddress),
+		cfg.String("proxy", "server-ssl", &serverSSLAddress),
+		cfg.Bool("proxy", "force-add", &enableForceAdd),
+		cfg.String("proxy", "select", &ideSelection),
+		cfg.String("proxy", "state-file", &stateFile),
+		cfg.Duration("proxy", "registration-ttl", &registrationTTL),
+		cfg.Duration("proxy", "health-interval", &healthInterval),
+		cfg.Bool("proxy", "health-probe", &healthProbe),
+		cfg.Duration("proxy", "health-timeout", &healthTimeout),
+		cfg.String("proxy", "admin", &adminAddress),
+		cfg.String("proxy", "record", &recordDirectory),
+		cfg.Duration("proxy", "reload-interval", &reloadInterval),
+		cfg.Duration("proxy", "shutdown-grace", &shutdownGrace),
+		cfg.String("ssl", "certificate", &sslCertFile),
+		cfg.String("ssl", "key", &sslKeyFile),
+		cfg.String("ssl", "ca", &sslCAFile),
+		cfg.Bool("ssl", "verify-clients", &sslVerifyClients),
+		cfg.String("ssl", "min-version", &sslMinVersion),
+		cfg.String("ide-ssl", "certificate", &ideSSLCertFile),
+		cfg.String("ide-ssl", "key", &ideSSLKeyFile),
+		cfg.String("ide-ssl", "ca", &ideSSLCAFile),
+		cfg.String("ide-ssl", "server-name", &ideSSLServerName),
+		cfg.Bool("ide-ssl", "insecure", &ideSSLInsecure),
+		cfg.String("log", "format", &logFormat),
+		cfg.String("log", "level", &logLevel),
+		cfg.String("log", "file", &logFile),
+		cfg.Int("log", "file-max-size", &logFileMaxSize),
+		cfg.Duration("log", "file-max-age", &logFileMaxAge),
+		cfg.Int("log", "file-keep", &logFileKeep),
+		cfg.Bool("log", "syslog", &logToSyslog),
+		cfg.Bool("log", "quiet", &quiet),
+		applyCloudConfig(cfg),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+func readConfig() (*config.Config, error) {
+	path, _ := config.FindFlag(os.Args[1:], "config")
+
+	return config.Load("dbgpProxy", path)
+}
+
+// Logs which files the settings came from, and which settings were not recognised. This is
+// separate from loading them, as the settings also configure the logger.
+func logConfig(cfg *config.Config, logger logger.Logger) {
+	for _, file := range cfg.Files() {
+		logger.LogInfo("config", "Read settings from '%s'", file)
+	}
+
+	for _, unused := range cfg.Unused() {
+		logger.LogWarning("config", "%s", unused)
+	}
+}
@@ -1,36 +1,71 @@
 package main
 
 import (
+	"crypto/tls"
 	"fmt"
 	"github.com/bitbored/go-ansicon" // BSD-3
+	"github.com/derickr/dbgp-tools/lib/admin"
 	"github.com/derickr/dbgp-tools/lib/connections"
 	"github.com/derickr/dbgp-tools/lib/logger"
+	"github.com/derickr/dbgp-tools/lib/metrics"
 	"github.com/derickr/dbgp-tools/lib/protocol"
 	"github.com/derickr/dbgp-tools/lib/proxy"
 	"github.com/derickr/dbgp-tools/lib/server"
+	"github.com/derickr/dbgp-tools/lib/tlsconfig"
 	"github.com/pborman/getopt/v2" // BSD-3
 	"net"
 	"os"
 	"os/signal"
 	"sync"
+	"syscall"
+	"time"
 )
 
 var clientVersion = "0.6.2"
 var clientYear    = "2025"
 
 var (
+	adminAddress     = ""
 	cloudUser        = ""
 	disCloudUser     = ""
 	enableSSLServers = false
 	enableForceAdd   = false
 	CloudDomain      = "cloud.xdebug.com"
 	CloudPort        = "9021"
+	healthInterval   = time.Minute
+	healthProbe      = false
+	healthTimeout    = 2 * time.Second
 	help             = false
+	ideSelection     = "first"
+	ideSSLCertFile   = "client-certs/client.pem"
+	ideSSLKeyFile    = "client-certs/client.key"
+	ideSSLCAFile     = ""
+	ideSSLInsecure   = false
+	ideSSLServerName = ""
+	logFile          = ""
+	logFileKeep      = 5
+	logFileMaxAge    = time.Duration(0)
+	logFileMaxSize   = 0
+	logFormat        = "console"
+	logLevel         = "info"
+	logToSyslog      = false
 	clientAddress    = "localhost:9001"
 	clientSSLAddress = "localhost:9011"
+	configFile       = ""
 	serverAddress    = "localhost:9003"
 	serverSSLAddress = "localhost:9013"
+	shutdownGrace    = 30 * time.Second
+	sslCAFile        = ""
+	sslCertFile      = "certs/fullchain.pem"
+	sslKeyFile       = "certs/privkey.pem"
+	sslMinVersion    = "1.2"
+	sslVerifyClients = false
+	stateFile        = ""
 	output           = ansicon.Convert(os.Stdout)
+	quiet            = false
+	recordDirectory  = ""
+	registrationTTL  = time.Duration(0)
+	reloadInterval   = 30 * time.Second
 	version          = false
 )
 
@@ -40,26 +75,87 @@ func printStartUp() {
 }
 
 func checkEnableSSLServers(logger logger.Logger) {
-	if _, err := os.Stat("certs/fullchain.pem"); err != nil {
-		logger.LogWarning("SSL", "The 'certs/fullchain.pem' file could not be found, not enabling SSL listeners")
+	if _, err := os.Stat(sslCertFile); err != nil {
+		logger.LogWarning("SSL", "The '%s' file could not be found, not enabling SSL listeners", sslCertFile)
 		return
 	}
-	if _, err := os.Stat("certs/privkey.pem"); err != nil {
-		logger.LogWarning("SSL", "The 'certs/privkey.pem' file could not be found, not enabling SSL listeners")
+	if _, err := os.Stat(sslKeyFile); err != nil {
+		logger.LogWarning("SSL", "The '%s' file could not be found, not enabling SSL listeners", sslKeyFile)
 		return
 	}
 	enableSSLServers = true
 }
 
+func createServerTLSConfig(certificates *tlsconfig.CertificateReloader) (*tls.Config, error) {
+	settings := tlsconfig.Settings{
+		CertFile:      sslCertFile,
+		KeyFile:       sslKeyFile,
+		CAFile:        sslCAFile,
+		MinVersion:    sslMinVersion,
+		VerifyClients: sslVerifyClients,
+	}
+
+	return settings.ReloadableServerConfig(certificates)
+}
+
+func createIDETLSConfig(reloadable reloadableSettings, logger logger.Logger) (*tls.Config, error) {
+	settings := tlsconfig.Settings{
+		CertFile:   reloadable.ideSSLCertFile,
+		KeyFile:    reloadable.ideSSLKeyFile,
+		CAFile:     reloadable.ideSSLCAFile,
+		MinVersion: sslMinVersion,
+		Insecure:   reloadable.ideSSLInsecure,
+		ServerName: reloadable.ideSSLServerName,
+	}
+
+	// The client certificate is optional, as most IDEs don't ask for one
+	if _, err := os.Stat(settings.CertFile); err != nil {
+		logger.LogInfo("SSL", "The '%s' file could not be found, not presenting a client certificate to IDEs", settings.CertFile)
+		settings.CertFile = ""
+	}
+	if settings.Insecure {
+		logger.LogWarning("SSL", "Not verifying the certificates of IDEs that registered for SSL connections")
+	}
+
+	return settings.ClientConfig()
+}
+
 func handleArguments() {
 	getopt.Flag(&help, 'h', "Show this help")
+	getopt.FlagLong(&configFile, "config", 0, "Read settings from this file, instead of from the system-wide and per-user configuration files", "file")
 	getopt.FlagLong(&clientAddress, "client", 'i', "Specify the host:port to listen on for IDE (client) connections", "host:port")
 	getopt.FlagLong(&serverAddress, "server", 's', "Specify the host:port to listen on for debugger engine (server) connections", "host:port")
-	if enableSSLServers {
-		getopt.FlagLong(&clientSSLAddress, "client-ssl", 0, "Specify the host:port to listen on for IDE (client) SSL connections", "host:port")
-		getopt.FlagLong(&serverSSLAddress, "server-ssl", 0, "Specify the host:port to listen on for debugger engine (server) SSL connections", "host:port")
-	}
+	getopt.FlagLong(&clientSSLAddress, "client-ssl", 0, "Specify the host:port to listen on for IDE (client) SSL connections", "host:port")
+	getopt.FlagLong(&serverSSLAddress, "server-ssl", 0, "Specify the host:port to listen on for debugger engine (server) SSL connections", "host:port")
+	getopt.FlagLong(&sslCertFile, "ssl-cert", 0, "The certificate (chain) for the SSL listeners", "file")
+	getopt.FlagLong(&sslKeyFile, "ssl-key", 0, "The private key for the SSL listeners", "file")
+	getopt.FlagLong(&sslCAFile, "ssl-ca", 0, "The CA bundle to verify client certificates with", "file")
+	getopt.FlagLong(&sslVerifyClients, "ssl-verify-clients", 0, "Only accept SSL connections with a client certificate signed by a CA from --ssl-ca")
+	getopt.FlagLong(&sslMinVersion, "ssl-min-version", 0, "The minimum TLS version to accept and use: 1.0, 1.1, 1.2, or 1.3", "version")
+	getopt.FlagLong(&ideSSLCertFile, "ide-ssl-cert", 0, "The client certificate to present when connecting to IDEs over SSL", "file")
+	getopt.FlagLong(&ideSSLKeyFile, "ide-ssl-key", 0, "The private key for --ide-ssl-cert", "file")
+	getopt.FlagLong(&ideSSLCAFile, "ide-ssl-ca", 0, "The CA bundle to verify IDE certificates with, instead of the system's", "file")
+	getopt.FlagLong(&ideSSLServerName, "ide-ssl-server-name", 0, "The host name to verify the certificates of IDEs against, instead of their IP address", "name")
+	getopt.FlagLong(&ideSSLInsecure, "ide-ssl-insecure", 0, "Do not verify the certificates of IDEs (insecure)")
 	getopt.Flag(&enableForceAdd, 'f', "If given, allow an IDE to register with an already registered IDE key")
+	getopt.FlagLong(&stateFile, "state-file", 0, "Store IDE registrations in this file, so that they survive a restart", "file")
+	getopt.FlagLong(&registrationTTL, "registration-ttl", 0, "Expire IDE registrations that have not been renewed within this time (0 = never). IDEs renew them by sending 'proxyinit' again from the same address, which then replaces their registration, even without -m", "duration")
+	getopt.FlagLong(&healthInterval, "health-interval", 0, "How often to remove expired registrations, and with --health-probe, check whether registered IDEs can be reached", "duration")
+	getopt.FlagLong(&healthProbe, "health-probe", 0, "Remove registered IDEs that do not accept a TCP connection. IDEs see each check as a debugging session that is aborted straight away")
+	getopt.FlagLong(&healthTimeout, "health-timeout", 0, "How long to wait for a registered IDE to accept a connection with --health-probe", "duration")
+	getopt.FlagLong(&ideSelection, "select", 0, "Which IDE to connect to when several registered with 'proxyinit -m 1' for the same IDE key: first, round-robin, or recent", "policy")
+	getopt.FlagLong(&shutdownGrace, "shutdown-grace", 0, "When shutting down, how long to wait for debugging sessions that are not idle to finish", "duration")
+	getopt.FlagLong(&reloadInterval, "reload-interval", 0, "Check the configuration files and SSL certificate for changes at this interval (0 = only reload on SIGHUP)", "duration")
+	getopt.FlagLong(&recordDirectory, "record", 0, "Record all packets of each debugging session to a file in this directory", "dir")
+	getopt.FlagLong(&adminAddress, "admin", 0, "Serve registered IDEs, active sessions, and metrics over HTTP on this host:port", "host:port")
+	getopt.FlagLong(&logFormat, "log-format", 0, "Write log messages as coloured text (console), or as one JSON object per line (json)", "format")
+	getopt.FlagLong(&logLevel, "log-level", 0, "Only log messages of at least this level: info, warning, or error", "level")
+	getopt.FlagLong(&logFile, "log-file", 0, "Also write log messages to this file", "file")
+	getopt.FlagLong(&logFileMaxSize, "log-file-max-size", 0, "Rotate the log file when it grows beyond this many megabytes (0 = never)", "MB")
+	getopt.FlagLong(&logFileMaxAge, "log-file-max-age", 0, "Rotate the log file after it has been written to for this long (0 = never)", "duration")
+	getopt.FlagLong(&logFileKeep, "log-file-keep", 0, "How many rotated log files to keep", "count")
+	getopt.FlagLong(&logToSyslog, "syslog", 0, "Also send log messages to the local syslog daemon (and with that, journald)")
+	getopt.FlagLong(&quiet, "quiet", 'q', "Do not write log messages to the console, for when logging to a file or syslog")
 	getopt.Flag(&version, 'v', "Show version number and exit")
 
 	handleCloudFlags()
@@ -71,23 +167,170 @@ func handleArguments() {
 		os.Exit(1)
 	}
 	if version {
+		printStartUp()
 		os.Exit(0)
 	}
 }
 
+// The returned function closes the log file, if there is one
+func createLogger() (logger.Logger, func(), error) {
+	var log logger.Logger
+	var loggers []logger.Logger
+	closeLog := func() {}
+
+	if logFormat != "console" && logFormat != "json" {
+		return nil, nil, fmt.Errorf("The log format '%s' is not supported, use console or json", logFormat)
+	}
+
+	level, err := logger.ParseLevel(logLevel)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if quiet && logFile == "" && !logToSyslog {
+		return nil, nil, fmt.Errorf("With --quiet, a log file or syslog is needed, as nothing would be logged otherwise")
+	}
+
+	if !quiet {
+		if logFormat == "json" {
+			loggers = append(loggers, logger.NewJSONLogger(os.Stdout))
+		} else {
+			loggers = append(loggers, logger.NewConsoleLogger(output))
+		}
+	}
+
+	if logToSyslog {
+		syslogLogger, err := logger.NewSyslogLogger("dbgpProxy")
+		if err != nil {
+			return nil, nil, err
+		}
+		loggers = append(loggers, syslogLogger)
+	}
+
+	if logFile != "" {
+		file, err := logger.NewRotatingFile(logFile, int64(logFileMaxSize)*1024*1024, logFileMaxAge, logFileKeep)
+		if err != nil {
+			return nil, nil, err
+		}
+		closeLog = func() { file.Close() }
+
+		if logFormat == "json" {
+			loggers = append(loggers, logger.NewJSONLogger(file))
+		} else {
+			loggers = append(loggers, logger.NewTextLogger(file))
+		}
+	}
+
+	if len(loggers) == 1 {
+		log = loggers[0]
+	} else {
+		log = logger.NewMultiLogger(loggers...)
+	}
+
+	if level > logger.LevelInfo {
+		log = logger.NewLevelFilter(log, level)
+	}
+
+	return log, closeLog, nil
+}
+
 func main() {
 	var cloudClient *server.Server
 	var serverServer *server.Server
 	var clientSSLServer *server.Server
 	var serverSSLServer *server.Server
 
-	log := logger.NewConsoleLogger(output)
+	cfg, err := loadConfig()
+	if err != nil {
+		printStartUp()
+		logger.NewConsoleLogger(output).LogError("dbgpProxy", "Proxy could not be started: %s", err)
+		return
+	}
+	handleArguments()
+
+	log, closeLog, err := createLogger()
+	if err != nil {
+		printStartUp()
+		logger.NewConsoleLogger(output).LogError("dbgpProxy", "Proxy could not be started: %s", err)
+		return
+	}
+	defer closeLog()
 
-	printStartUp()
+	// The banner would break up the stream of JSON objects
+	if logFormat == "console" && !quiet {
+		printStartUp()
+	}
+	logConfig(cfg, log)
 	checkEnableSSLServers(log)
-	handleArguments()
+
+	var serverTLSConfig *tls.Config
+	var certificates *tlsconfig.CertificateReloader
+
+	if enableSSLServers {
+		certificates, err = tlsconfig.NewCertificateReloader(sslCertFile, sslKeyFile, log)
+		if err != nil {
+			log.LogError("dbgpProxy", "Proxy could not be started: %s", err)
+			return
+		}
+
+		serverTLSConfig, err = createServerTLSConfig(certificates)
+		if err != nil {
+			log.LogError("dbgpProxy", "Proxy could not be started: %s", err)
+			return
+		}
+	}
+
+	ideTLSConfig, err := createIDETLSConfig(startupSettings(), log)
+	if err != nil {
+		log.LogError("dbgpProxy", "Proxy could not be started: %s", err)
+		return
+	}
+
+	selectionPolicy, err := connections.ParseSelectionPolicy(ideSelection)
+	if err != nil {
+		log.LogError("dbgpProxy", "%s", err)
+		return
+	}
 
 	ideConnectionList := connections.NewConnectionList(enableForceAdd)
+	ideConnectionList.SetSelectionPolicy(selectionPolicy)
+
+	ideConnectionList.SetRegistrationTTL(registrationTTL)
+
+	if stateFile != "" {
+		if err := ideConnectionList.EnableStateFile(stateFile, log); err != nil {
+			log.LogError("dbgpProxy", "Proxy could not be started: %s", err)
+			return
+		}
+	}
+
+	if (registrationTTL > 0 || healthProbe) && healthInterval > 0 {
+		// Without liveness probes, only sweep out expired registrations
+		probeTimeout := time.Duration(0)
+		if healthProbe {
+			probeTimeout = healthTimeout
+		}
+
+		healthChecker := connections.NewHealthChecker(ideConnectionList, healthInterval, probeTimeout, log)
+		healthChecker.Start()
+		defer healthChecker.Stop()
+	}
+
+	sessions := proxy.NewSessionList()
+	proxyMetrics := metrics.NewMetrics()
+
+	if adminAddress != "" {
+		adminServer := admin.NewServer(adminAddress, ideConnectionList, sessions, proxyMetrics, log)
+		if err := adminServer.Start(); err != nil {
+			log.LogError("dbgpProxy", "Proxy could not be started: %s", err)
+			return
+		}
+		defer adminServer.Stop()
+	}
+
+	serverHandler := proxy.NewServerHandler(ideConnectionList, sessions, proxyMetrics, log)
+	serverHandler.SetIDETLSConfig(ideTLSConfig)
+	serverHandler.SetRecordDirectory(recordDirectory)
 
 	syncGroup := &sync.WaitGroup{}
 	signalShutdown := make(chan int, 1)
@@ -101,7 +344,7 @@ func main() {
 			resolveTCP(connections.CloudHostFromUserId(CloudDomain, CloudPort, cloudUser)),
 			syncGroup,
 			log)
-		err := cloudClient.CloudConnect(proxy.NewServerHandler(ideConnectionList, log), cloudUser, signalShutdown)
+		err := cloudClient.CloudConnect(serverHandler, cloudUser, signalShutdown)
 
 		if err != nil {
 			log.LogError("dbgpProxy", "Proxy could not be started: %s", err)
@@ -109,11 +352,11 @@ func main() {
 		}
 	} else {
 		serverServer = server.NewServer("server", resolveTCP(serverAddress), syncGroup, log)
-		go serverServer.Listen(proxy.NewServerHandler(ideConnectionList, log))
+		go serverServer.Listen(serverHandler)
 
 		if enableSSLServers {
 			serverSSLServer = server.NewServer("server-ssl", resolveTCP(serverSSLAddress), syncGroup, log)
-			go serverSSLServer.ListenSSL(proxy.NewServerHandler(ideConnectionList, log))
+			go serverSSLServer.ListenSSL(serverHandler, serverTLSConfig)
 		}
 	}
 
@@ -121,19 +364,49 @@ func main() {
 	go clientServer.Listen(proxy.NewClientHandler(ideConnectionList, log))
 	if enableSSLServers {
 		clientSSLServer = server.NewServer("client-ssl", resolveTCP(clientSSLAddress), syncGroup, log)
-		go clientSSLServer.ListenSSL(proxy.NewClientHandler(ideConnectionList, log))
+		go clientSSLServer.ListenSSL(proxy.NewClientHandler(ideConnectionList, log), serverTLSConfig)
 	}
 
 	log.LogInfo("dbgpProxy", "Proxy started")
 
+	reloader := &reloader{
+		logger:         log,
+		config:         cfg,
+		settings:       startupSettings(),
+		flags:          commandLineFlags(),
+		certificates:   certificates,
+		serverHandler:  serverHandler,
+		connectionList: ideConnectionList,
+	}
+
+	var reloadTicks <-chan time.Time
+	if reloadInterval > 0 {
+		ticker := time.NewTicker(reloadInterval)
+		defer ticker.Stop()
+		reloadTicks = ticker.C
+	}
+
 	signals := make(chan os.Signal, 1)
-	signal.Notify(signals, os.Interrupt)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	reloadSignals := make(chan os.Signal, 1)
+	signal.Notify(reloadSignals, syscall.SIGHUP)
 
-	select {
-	case s := <-signals:
-		log.LogWarning("dbgpProxy", "Signal received: %s", s)
-	case <-signalShutdown:
-		log.LogWarning("dbgpProxy", "Shutdown requested")
+WaitLoop:
+	for {
+		select {
+		case s := <-signals:
+			log.LogWarning("dbgpProxy", "Signal received: %s", s)
+			break WaitLoop
+		case <-signalShutdown:
+			log.LogWarning("dbgpProxy", "Shutdown requested")
+			break WaitLoop
+		case <-reloadSignals:
+			log.LogInfo("dbgpProxy", "Reloading configuration and SSL certificate")
+			reloader.reloadConfig()
+		case <-reloadTicks:
+			reloader.reloadIfChanged()
+		}
 	}
 
 	clientServer.Stop()
@@ -150,6 +423,12 @@ func main() {
 		cloudClient.Stop()
 	}
 
+	if count := sessions.Count(); count > 0 {
+		log.LogInfo("dbgpProxy", "Waiting up to %s for %d debugging session(s) to end", shutdownGrace, count)
+	}
+	summary := sessions.Drain(shutdownGrace)
+	log.LogInfo("dbgpProxy", "Sessions at shutdown: %d idle and detached, %d finished, %d timed out", summary.Detached, summary.Finished, summary.TimedOut)
+
 	syncGroup.Wait()
 
 	log.LogInfo("dbgpProxy", "Proxy stopped")
@@ -2,5 +2,15 @@
 
 package main
 
+import (
+	"github.com/derickr/dbgp-tools/lib/config"
+)
+
+var cloudRestartSettings = []configKey{}
+
 func handleCloudFlags() {
 }
+
+func applyCloudConfig(cfg *config.Config) error {
+	return nil
+}
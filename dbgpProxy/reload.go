@@ -0,0 +1,218 @@
+package main
+
+import (
+	"errors"
+	"github.com/derickr/dbgp-tools/lib/config"
+	"github.com/derickr/dbgp-tools/lib/connections"
+	"github.com/derickr/dbgp-tools/lib/logger"
+	"github.com/derickr/dbgp-tools/lib/proxy"
+	"github.com/derickr/dbgp-tools/lib/tlsconfig"
+	"github.com/pborman/getopt/v2" // BSD-3
+	"time"
+)
+
+/*
+ * Applies changes to the configuration files and SSL certificates while the
+ * proxy runs, without closing any connections. Only settings that affect new
+ * connections and registrations are changed; listen addresses and such need a
+ * restart. A setting that is removed from a file keeps its current value.
+ *
+ * The values are kept in reloadableSettings, rather than in the variables
+ * that the flags and the configuration files set when the proxy starts, and
+ * settings that were given on the command line keep their values.
+ */
+type reloader struct {
+	logger         logger.Logger
+	config         *config.Config
+	settings       reloadableSettings
+	flags          map[string]bool // the names of the flags on the command line
+	certificates   *tlsconfig.CertificateReloader
+	serverHandler  *proxy.ServerHandler
+	connectionList *connections.ConnectionList
+}
+
+// The settings that can be changed while the proxy runs
+type reloadableSettings struct {
+	ideSelection     string
+	enableForceAdd   bool
+	registrationTTL  time.Duration
+	recordDirectory  string
+	sslCertFile      string
+	sslKeyFile       string
+	ideSSLCertFile   string
+	ideSSLKeyFile    string
+	ideSSLCAFile     string
+	ideSSLServerName string
+	ideSSLInsecure   bool
+}
+
+// The settings as they are when the proxy starts, from the configuration files and flags
+func startupSettings() reloadableSettings {
+	return reloadableSettings{
+		ideSelection:     ideSelection,
+		enableForceAdd:   enableForceAdd,
+		registrationTTL:  registrationTTL,
+		recordDirectory:  recordDirectory,
+		sslCertFile:      sslCertFile,
+		sslKeyFile:       sslKeyFile,
+		ideSSLCertFile:   ideSSLCertFile,
+		ideSSLKeyFile:    ideSSLKeyFile,
+		ideSSLCAFile:     ideSSLCAFile,
+		ideSSLServerName: ideSSLServerName,
+		ideSSLInsecure:   ideSSLInsecure,
+	}
+}
+
+// Returns the long and short names of the flags that were given on the command line
+func commandLineFlags() map[string]bool {
+	flags := map[string]bool{}
+
+	getopt.Visit(func(option getopt.Option) {
+		flags[option.LongName()] = true
+		flags[option.ShortName()] = true
+	})
+
+	return flags
+}
+
+// Overlays the settings from 'cfg' that were not given as a flag
+func (settings *reloadableSettings) apply(cfg *config.Config, flags map[string]bool) error {
+	unlessFlag := func(flag string, section string, key string, apply func(section string, key string) error) error {
+		if flags[flag] {
+			cfg.Text(section, key) // it is known, but overridden
+			return nil
+		}
+		return apply(section, key)
+	}
+
+	return errors.Join(
+		unlessFlag("f", "proxy", "force-add", func(section string, key string) error {
+			return cfg.Bool(section, key, &settings.enableForceAdd)
+		}),
+		unlessFlag("select", "proxy", "select", func(section string, key string) error {
+			return cfg.String(section, key, &settings.ideSelection)
+		}),
+		unlessFlag("registration-ttl", "proxy", "registration-ttl", func(section string, key string) error {
+			return cfg.Duration(section, key, &settings.registrationTTL)
+		}),
+		unlessFlag("record", "proxy", "record", func(section string, key string) error {
+			return cfg.String(section, key, &settings.recordDirectory)
+		}),
+		unlessFlag("ssl-cert", "ssl", "certificate", func(section string, key string) error {
+			return cfg.String(section, key, &settings.sslCertFile)
+		}),
+		unlessFlag("ssl-key", "ssl", "key", func(section string, key string) error {
+			return cfg.String(section, key, &settings.sslKeyFile)
+		}),
+		unlessFlag("ide-ssl-cert", "ide-ssl", "certificate", func(section string, key string) error {
+			return cfg.String(section, key, &settings.ideSSLCertFile)
+		}),
+		unlessFlag("ide-ssl-key", "ide-ssl", "key", func(section string, key string) error {
+			return cfg.String(section, key, &settings.ideSSLKeyFile)
+		}),
+		unlessFlag("ide-ssl-ca", "ide-ssl", "ca", func(section string, key string) error {
+			return cfg.String(section, key, &settings.ideSSLCAFile)
+		}),
+		unlessFlag("ide-ssl-server-name", "ide-ssl", "server-name", func(section string, key string) error {
+			return cfg.String(section, key, &settings.ideSSLServerName)
+		}),
+		unlessFlag("ide-ssl-insecure", "ide-ssl", "insecure", func(section string, key string) error {
+			return cfg.Bool(section, key, &settings.ideSSLInsecure)
+		}),
+	)
+}
+
+type configKey struct {
+	section string
+	key     string
+}
+
+// Settings that are only used when the proxy starts
+var restartSettings = append([]configKey{
+	{"proxy", "client"}, {"proxy", "server"}, {"proxy", "client-ssl"}, {"proxy", "server-ssl"},
+	{"proxy", "state-file"}, {"proxy", "admin"}, {"proxy", "reload-interval"}, {"proxy", "shutdown-grace"},
+	{"proxy", "health-interval"}, {"proxy", "health-probe"}, {"proxy", "health-timeout"},
+	{"ssl", "ca"}, {"ssl", "verify-clients"}, {"ssl", "min-version"},
+	{"log", "format"}, {"log", "level"}, {"log", "file"}, {"log", "file-max-size"},
+	{"log", "file-max-age"}, {"log", "file-keep"}, {"log", "syslog"}, {"log", "quiet"},
+}, cloudRestartSettings...)
+
+// Whether 'cfg' changes a setting that needs a restart, compared to 'previous'
+func restartNeeded(previous *config.Config, cfg *config.Config) bool {
+	changed := false
+
+	for _, setting := range restartSettings {
+		before, _ := previous.Text(setting.section, setting.key)
+		after, _ := cfg.Text(setting.section, setting.key)
+
+		if before != after {
+			changed = true
+		}
+	}
+
+	return changed
+}
+
+func (reloader *reloader) reloadConfig() {
+	cfg, err := readConfig()
+	if err != nil {
+		reloader.logger.LogError("config", "Not reloading configuration: %s", err)
+		return
+	}
+
+	settings := reloader.settings
+	if err := settings.apply(cfg, reloader.flags); err != nil {
+		reloader.logger.LogError("config", "Not reloading configuration: %s", err)
+		return
+	}
+
+	// This also marks the settings that need a restart as used, before logging unknown ones
+	if restartNeeded(reloader.config, cfg) {
+		reloader.logger.LogWarning("config", "Changes to listen addresses, the admin address, the state file, health checks, SSL client verification, and logging require a restart")
+	}
+
+	logConfig(cfg, reloader.logger)
+	reloader.config = cfg
+	reloader.settings = settings
+
+	selectionPolicy, err := connections.ParseSelectionPolicy(settings.ideSelection)
+	if err != nil {
+		reloader.logger.LogError("config", "Not changing IDE selection: %s", err)
+	} else {
+		reloader.connectionList.SetSelectionPolicy(selectionPolicy)
+	}
+	reloader.connectionList.SetForceAdd(settings.enableForceAdd)
+	reloader.connectionList.SetRegistrationTTL(settings.registrationTTL)
+	reloader.serverHandler.SetRecordDirectory(settings.recordDirectory)
+
+	ideTLSConfig, err := createIDETLSConfig(settings, reloader.logger)
+	if err != nil {
+		reloader.logger.LogError("config", "Not changing SSL settings for connecting to IDEs: %s", err)
+	} else {
+		reloader.serverHandler.SetIDETLSConfig(ideTLSConfig)
+	}
+
+	if reloader.certificates != nil {
+		if err := reloader.certificates.SetFiles(settings.sslCertFile, settings.sslKeyFile); err != nil {
+			reloader.logger.LogError("config", "Not changing SSL certificate: %s", err)
+		}
+	}
+
+	reloader.logger.LogInfo("config", "Configuration reloaded")
+}
+
+// Reloads the configuration when one of its files changed, and otherwise the SSL certificate
+// when its files changed
+func (reloader *reloader) reloadIfChanged() {
+	if reloader.config.Changed() {
+		reloader.logger.LogInfo("config", "Configuration files changed")
+		reloader.reloadConfig()
+		return
+	}
+
+	if reloader.certificates != nil {
+		if err := reloader.certificates.ReloadIfChanged(); err != nil {
+			reloader.logger.LogError("SSL", "Not reloading certificate: %s", err)
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/bitbored/go-ansicon" // BSD-3
+	"github.com/derickr/dbgp-tools/lib/protocol"
+	"github.com/derickr/dbgp-tools/lib/replay"
+	. "github.com/logrusorgru/aurora" // WTFPL
+	"github.com/pborman/getopt/v2"    // BSD-3
+)
+
+var clientVersion = "0.1.0"
+var clientYear    = "2025"
+
+var (
+	help       = false
+	ideAddress = "localhost:9003"
+	loop       = false
+	showXML    = false
+	timeout    = 10 * time.Second
+	version    = false
+	recording  = ""
+	output     = ansicon.Convert(os.Stdout)
+)
+
+func printVersion() {
+	fmt.Fprintf(output, "Xdebug DBGp replay engine (%s)\n", Bold(clientVersion))
+	fmt.Fprintf(output, "Copyright 2025-%s by Derick Rethans\n", clientYear)
+}
+
+func displayHelp() {
+	fmt.Fprintf(output, `
+Plays back a session that was recorded with 'dbgpProxy --record' or
+'dbgpClient --record'. Like Xdebug, it connects to the IDE (or proxy), sends
+the recorded init packet, and then answers each command with the responses
+that were recorded for a command with the same name and arguments. The
+transaction IDs in the responses are changed to the ones the IDE sent.
+
+Commands that do not occur in the recording are answered with an error.
+`)
+}
+
+func handleArguments() {
+	getopt.Flag(&help, 'h', "Show this help")
+	getopt.Flag(&version, 'v', "Show version number and exit")
+	getopt.Flag(&showXML, 'x', "Show protocol XML")
+	getopt.FlagLong(&ideAddress, "ide", 'c', "The address of the IDE or proxy to connect to", "host:port")
+	getopt.FlagLong(&loop, "loop", 0, "Replay the recording again after each session, until interrupted")
+	getopt.FlagLong(&timeout, "timeout", 0, "How long to wait for the IDE to send a command", "duration")
+
+	getopt.SetParameters("recording.jsonl")
+	getopt.Parse()
+
+	if version {
+		printVersion()
+		os.Exit(0)
+	}
+
+	if help || getopt.NArgs() != 1 {
+		printVersion()
+		displayHelp()
+		fmt.Fprintf(output, "\n")
+		getopt.PrintUsage(os.Stdout)
+		os.Exit(1)
+	}
+
+	recording = getopt.Arg(0)
+}
+
+func sendPacket(conn net.Conn, packet string) error {
+	if showXML {
+		fmt.Fprintf(output, "%s\n", Faint(packet))
+	}
+
+	_, err := fmt.Fprintf(conn, "%d\000%s\000", len(packet), packet)
+
+	return err
+}
+
+// Plays back the recording over one connection, and returns how many commands could not be matched
+func replaySession(script *replay.Script) (int, error) {
+	conn, err := net.Dial("tcp", ideAddress)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	fmt.Fprintf(output, "Connected to %s\n", ideAddress)
+
+	for _, packet := range script.Init() {
+		if err := sendPacket(conn, packet); err != nil {
+			return 0, err
+		}
+	}
+
+	unmatched := 0
+	reader := bufio.NewReader(conn)
+
+	for {
+		conn.SetReadDeadline(time.Now().Add(timeout))
+
+		command, err := reader.ReadString('\000')
+		if err != nil {
+			if len(command) == 0 && !isTimeout(err) {
+				// The IDE closed the connection
+				return unmatched, nil
+			}
+			return unmatched, err
+		}
+		command = strings.TrimSuffix(command, "\000")
+
+		responses, matched, err := script.Answer(command)
+		if err != nil {
+			return unmatched, fmt.Errorf("Can not answer '%s': %w", command, err)
+		}
+
+		if matched {
+			fmt.Fprintf(output, "%s %s\n", BrightGreen(">"), command)
+		} else {
+			fmt.Fprintf(output, "%s %s %s\n", BrightRed(">"), command, BrightYellow("(not recorded)"))
+			unmatched++
+		}
+
+		for _, response := range responses {
+			if err := sendPacket(conn, response); err != nil {
+				return unmatched, err
+			}
+		}
+
+		cl, _ := protocol.ParseCommandLine(command)
+		if cl.Name == "stop" || cl.Name == "detach" {
+			return unmatched, nil
+		}
+	}
+}
+
+func isTimeout(err error) bool {
+	netErr, ok := err.(net.Error)
+
+	return ok && netErr.Timeout()
+}
+
+func main() {
+	handleArguments()
+
+	script, err := replay.Load(recording)
+	if err != nil {
+		fmt.Fprintf(output, "%s: %s\n", BrightRed("Error"), err)
+		os.Exit(2)
+	}
+
+	for {
+		unmatched, err := replaySession(script)
+		if err != nil {
+			fmt.Fprintf(output, "%s: %s\n", BrightRed("Error while replaying"), err)
+			os.Exit(3)
+		}
+
+		fmt.Fprintf(output, "Disconnect: %d command(s) not recorded, %d recorded command(s) not replayed\n", unmatched, script.Remaining())
+
+		if !loop {
+			if unmatched > 0 {
+				os.Exit(4)
+			}
+			break
+		}
+
+		script.Rewind()
+	}
+}
@@ -0,0 +1,159 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/derickr/dbgp-tools/lib/connections"
+	"github.com/derickr/dbgp-tools/lib/logger"
+	"github.com/derickr/dbgp-tools/lib/metrics"
+	"github.com/derickr/dbgp-tools/lib/proxy"
+)
+
+/*
+ * An HTTP server that exposes the state of dbgpProxy:
+ *
+ *   /connections  registered IDEs (JSON)
+ *   /sessions     debugging sessions that are being forwarded (JSON)
+ *   /metrics      counters in the Prometheus text format
+ *   /health       "ok", for container orchestrators
+ */
+type Server struct {
+	logger         logger.Logger
+	connectionList *connections.ConnectionList
+	sessions       *proxy.SessionList
+	metrics        *metrics.Metrics
+	httpServer     *http.Server
+}
+
+type connectionInfo struct {
+	IDEKey     string     `json:"idekey"`
+	Address    string     `json:"address"`
+	SSL        bool       `json:"ssl"`
+	Multiple   bool       `json:"multiple"`
+	Claimed    bool       `json:"claimed"`
+	Registered time.Time  `json:"registered"`
+	Expires    *time.Time `json:"expires,omitempty"`
+}
+
+type sessionInfo struct {
+	ID            int       `json:"id"`
+	IDEKey        string    `json:"idekey"`
+	EngineAddress string    `json:"engine_address"`
+	IDEAddress    string    `json:"ide_address"`
+	Started       time.Time `json:"started"`
+	Packets       uint64    `json:"packets"`
+	Bytes         uint64    `json:"bytes"`
+}
+
+func NewServer(address string, connectionList *connections.ConnectionList, sessions *proxy.SessionList, metrics *metrics.Metrics, logger logger.Logger) *Server {
+	server := &Server{
+		logger:         logger,
+		connectionList: connectionList,
+		sessions:       sessions,
+		metrics:        metrics,
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/connections", server.handleConnections)
+	mux.HandleFunc("/sessions", server.handleSessions)
+	mux.HandleFunc("/metrics", server.handleMetrics)
+	mux.HandleFunc("/health", server.handleHealth)
+
+	server.httpServer = &http.Server{Addr: address, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+
+	return server
+}
+
+// Starts listening in the background. Errors binding the address are returned right away.
+func (server *Server) Start() error {
+	listener, err := net.Listen("tcp", server.httpServer.Addr)
+	if err != nil {
+		return fmt.Errorf("Can not start admin server on %s: %w", server.httpServer.Addr, err)
+	}
+
+	server.logger.LogInfo("admin", "Started admin server on %s", listener.Addr())
+
+	go func() {
+		err := server.httpServer.Serve(listener)
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			server.logger.LogError("admin", "Admin server stopped: %s", err)
+		}
+	}()
+
+	return nil
+}
+
+func (server *Server) Stop() {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	server.httpServer.Shutdown(ctx)
+	server.logger.LogInfo("admin", "Shutdown admin server")
+}
+
+func writeJSON(w http.ResponseWriter, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "\t")
+	encoder.Encode(data)
+}
+
+func (server *Server) handleConnections(w http.ResponseWriter, r *http.Request) {
+	list := []connectionInfo{}
+
+	for _, connection := range server.connectionList.All() {
+		info := connectionInfo{
+			IDEKey:     connection.GetKey(),
+			Address:    connection.FullAddress(),
+			SSL:        connection.IsSSL(),
+			Multiple:   connection.IsMultipleSupported(),
+			Claimed:    connection.IsClaimed(),
+			Registered: connection.GetRegistrationTime(),
+		}
+		if expires := connection.GetExpiryTime(); !expires.IsZero() {
+			info.Expires = &expires
+		}
+		list = append(list, info)
+	}
+
+	writeJSON(w, list)
+}
+
+func (server *Server) handleSessions(w http.ResponseWriter, r *http.Request) {
+	list := []sessionInfo{}
+
+	for _, session := range server.sessions.All() {
+		list = append(list, sessionInfo{
+			ID:            session.ID,
+			IDEKey:        session.IDEKey,
+			EngineAddress: session.EngineAddress,
+			IDEAddress:    session.IDEAddress,
+			Started:       session.Started,
+			Packets:       session.GetPacketCount(),
+			Bytes:         session.GetByteCount(),
+		})
+	}
+
+	writeJSON(w, list)
+}
+
+func (server *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+	server.metrics.WritePrometheus(w, []metrics.Gauge{
+		{Name: "dbgpproxy_registered_ides", Help: "IDEs that are registered with the proxy", Value: len(server.connectionList.All())},
+		{Name: "dbgpproxy_active_sessions", Help: "Debugging sessions that are in progress", Value: server.sessions.Count()},
+	})
+}
+
+func (server *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain")
+	fmt.Fprintln(w, "ok")
+}
@@ -0,0 +1,71 @@
+package command
+
+import (
+	"fmt"
+	"github.com/derickr/dbgp-tools/lib/connections"
+	"github.com/derickr/dbgp-tools/lib/dbgpxml"
+	"github.com/derickr/dbgp-tools/lib/logger"
+)
+
+type ProxyInfoCommand struct {
+	connectionList *connections.ConnectionList
+	ideKey         string
+	logger         logger.Logger
+}
+
+func NewProxyInfoCommand(connectionList *connections.ConnectionList, logger logger.Logger) *ProxyInfoCommand {
+	return &ProxyInfoCommand{connectionList: connectionList, ideKey: "", logger: logger}
+}
+
+func (piCommand *ProxyInfoCommand) GetName() string {
+	return "proxyinfo"
+}
+
+func (piCommand *ProxyInfoCommand) Handle() (string, error) {
+	var info *dbgpxml.ProxyInfo
+
+	found := piCommand.connectionList.FindAllByKey(piCommand.ideKey)
+
+	if len(found) > 0 {
+		info = dbgpxml.NewProxyInfo(true, piCommand.ideKey, newProxyListEntries(found), nil)
+	} else {
+		piCommand.logger.LogUserWarning("proxyinfo", piCommand.ideKey, "No connection registered for IDE Key '%s'", piCommand.ideKey)
+		info = dbgpxml.NewProxyInfo(false, piCommand.ideKey, nil, &dbgpxml.ProxyInitError{ID: "PROXY-ERR-03", Message: fmt.Sprintf("A client for '%s' has not been registered", piCommand.ideKey)})
+	}
+
+	return info.AsXML()
+}
+
+/* proxyinfo -k PHPSTORM */
+func CreateProxyInfo(connectionList *connections.ConnectionList, arguments []string, logger logger.Logger) (DbgpCommand, error) {
+	piCommand := NewProxyInfoCommand(connectionList, logger)
+
+	expectValue := false
+	expectValueFor := ""
+
+	for _, value := range arguments {
+		if expectValue {
+			expectValue = false
+			switch expectValueFor {
+			case "-i":
+				/* ignore */
+			case "-k":
+				piCommand.ideKey = value
+			default:
+				return nil, fmt.Errorf("Unknown argument '%s' (with value '%s')", expectValueFor, value)
+			}
+		} else {
+			expectValueFor = value
+			expectValue = true
+		}
+	}
+
+	if expectValue {
+		return nil, fmt.Errorf("No argument given for '%s'", expectValueFor)
+	}
+	if piCommand.ideKey == "" {
+		return nil, fmt.Errorf("No IDE key was provided")
+	}
+
+	return piCommand, nil
+}
@@ -30,6 +30,7 @@ func (piCommand *ProxyInitCommand) Handle() (string, error) {
 	var init *dbgpxml.ProxyInit
 
 	conn := connections.NewConnection(piCommand.ideKey, piCommand.ipAddress, strconv.Itoa(piCommand.port), piCommand.ssl, nil)
+	conn.SetMultipleSupported(piCommand.multipleSupported)
 	err := piCommand.connectionList.Add(conn)
 
 	if err == nil {
@@ -0,0 +1,92 @@
+package command
+
+import (
+	"fmt"
+	"github.com/derickr/dbgp-tools/lib/connections"
+	"github.com/derickr/dbgp-tools/lib/dbgpxml"
+	"github.com/derickr/dbgp-tools/lib/logger"
+	"sort"
+	"time"
+)
+
+type ProxyListCommand struct {
+	connectionList *connections.ConnectionList
+	logger         logger.Logger
+}
+
+func NewProxyListCommand(connectionList *connections.ConnectionList, logger logger.Logger) *ProxyListCommand {
+	return &ProxyListCommand{connectionList: connectionList, logger: logger}
+}
+
+func (plCommand *ProxyListCommand) GetName() string {
+	return "proxylist"
+}
+
+func newProxyListEntry(connection *connections.Connection) dbgpxml.ProxyListEntry {
+	entry := dbgpxml.ProxyListEntry{
+		IDEKey:     connection.GetKey(),
+		Address:    connection.GetIPAddress(),
+		Port:       connection.GetPort(),
+		SSL:        connection.IsSSL(),
+		Multiple:   connection.IsMultipleSupported(),
+		Claimed:    connection.IsClaimed(),
+		Registered: connection.GetRegistrationTime().UTC().Format(time.RFC3339),
+	}
+
+	if expires := connection.GetExpiryTime(); !expires.IsZero() {
+		entry.Expires = expires.UTC().Format(time.RFC3339)
+	}
+
+	return entry
+}
+
+func newProxyListEntries(list []*connections.Connection) []dbgpxml.ProxyListEntry {
+	entries := []dbgpxml.ProxyListEntry{}
+
+	for _, connection := range list {
+		entries = append(entries, newProxyListEntry(connection))
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].IDEKey < entries[j].IDEKey
+	})
+
+	return entries
+}
+
+func (plCommand *ProxyListCommand) Handle() (string, error) {
+	entries := newProxyListEntries(plCommand.connectionList.All())
+
+	plCommand.logger.LogInfo("proxylist", "Listed %d registered IDE(s)", len(entries))
+
+	return dbgpxml.NewProxyList(true, entries, nil).AsXML()
+}
+
+/* proxylist */
+func CreateProxyList(connectionList *connections.ConnectionList, arguments []string, logger logger.Logger) (DbgpCommand, error) {
+	plCommand := NewProxyListCommand(connectionList, logger)
+
+	expectValue := false
+	expectValueFor := ""
+
+	for _, value := range arguments {
+		if expectValue {
+			expectValue = false
+			switch expectValueFor {
+			case "-i":
+				/* ignore */
+			default:
+				return nil, fmt.Errorf("Unknown argument '%s' (with value '%s')", expectValueFor, value)
+			}
+		} else {
+			expectValueFor = value
+			expectValue = true
+		}
+	}
+
+	if expectValue {
+		return nil, fmt.Errorf("No argument given for '%s'", expectValueFor)
+	}
+
+	return plCommand, nil
+}
@@ -10,11 +10,12 @@ import (
 type ProxyStopCommand struct {
 	connectionList *connections.ConnectionList
 	ideKey         string
+	ipAddress      string
 	logger         logger.Logger
 }
 
-func NewProxyStopCommand(connectionList *connections.ConnectionList, logger logger.Logger) *ProxyStopCommand {
-	return &ProxyStopCommand{connectionList: connectionList, ideKey: "", logger: logger}
+func NewProxyStopCommand(ipAddress string, connectionList *connections.ConnectionList, logger logger.Logger) *ProxyStopCommand {
+	return &ProxyStopCommand{connectionList: connectionList, ideKey: "", ipAddress: ipAddress, logger: logger}
 }
 
 func (piCommand *ProxyStopCommand) GetName() string {
@@ -23,8 +24,15 @@ func (piCommand *ProxyStopCommand) GetName() string {
 
 func (piCommand *ProxyStopCommand) Handle() (string, error) {
 	var stop *dbgpxml.ProxyStop
+	var err error
 
-	err := piCommand.connectionList.RemoveByKey(piCommand.ideKey)
+	// IDEs that share an IDE key only unregister themselves. The last one, or the only one,
+	// can also be unregistered from another address, as the proxy always allowed.
+	if len(piCommand.connectionList.FindAllByKey(piCommand.ideKey)) > 1 {
+		err = piCommand.connectionList.RemoveByAddress(piCommand.ideKey, piCommand.ipAddress)
+	} else {
+		err = piCommand.connectionList.RemoveByKey(piCommand.ideKey)
+	}
 
 	if err == nil {
 		piCommand.logger.LogUserInfo("proxystop", piCommand.ideKey, "Removed connection for IDE Key '%s'", piCommand.ideKey)
@@ -38,8 +46,8 @@ func (piCommand *ProxyStopCommand) Handle() (string, error) {
 }
 
 /* proxystop -k PHPSTORM */
-func CreateProxyStop(connectionList *connections.ConnectionList, arguments []string, logger logger.Logger) (DbgpCommand, error) {
-	piCommand := NewProxyStopCommand(connectionList, logger)
+func CreateProxyStop(ipAddress string, connectionList *connections.ConnectionList, arguments []string, logger logger.Logger) (DbgpCommand, error) {
+	piCommand := NewProxyStopCommand(ipAddress, connectionList, logger)
 
 	expectValue := false
 	expectValueFor := ""
@@ -0,0 +1,58 @@
+package command
+
+import (
+	"io"
+	"testing"
+
+	"github.com/derickr/dbgp-tools/lib/connections"
+	"github.com/derickr/dbgp-tools/lib/logger"
+)
+
+func register(t *testing.T, list *connections.ConnectionList, ipAddress string) {
+	t.Helper()
+
+	command, err := CreateProxyInit(ipAddress, list, []string{"-p", "9003", "-k", "shared", "-m", "1"}, logger.NewTextLogger(io.Discard))
+	if err != nil {
+		t.Fatalf("proxyinit from %s: %s", ipAddress, err)
+	}
+	command.Handle()
+}
+
+func proxyStop(t *testing.T, list *connections.ConnectionList, ipAddress string) string {
+	t.Helper()
+
+	command, err := CreateProxyStop(ipAddress, list, []string{"-k", "shared"}, logger.NewTextLogger(io.Discard))
+	if err != nil {
+		t.Fatalf("proxystop from %s: %s", ipAddress, err)
+	}
+	response, _ := command.Handle()
+
+	return response
+}
+
+func TestProxyStopWithSharedKey(t *testing.T) {
+	list := connections.NewConnectionList(false)
+	register(t, list, "10.0.0.1")
+	register(t, list, "10.0.0.2")
+
+	proxyStop(t, list, "10.0.0.1")
+
+	selected := list.SelectByKey("shared")
+	if len(selected) != 1 || selected[0].GetIPAddress() != "10.0.0.2" {
+		t.Fatalf("expected only the IDE at 10.0.0.2 to be left, got %d registrations", len(selected))
+	}
+
+	// A third host can not unregister the IDEs of others...
+	register(t, list, "10.0.0.3")
+	proxyStop(t, list, "10.0.0.4")
+	if count := len(list.FindAllByKey("shared")); count != 2 {
+		t.Errorf("%d registrations left after a proxystop from an unknown host, expected 2", count)
+	}
+
+	// ... but the last registration is removed as before
+	proxyStop(t, list, "10.0.0.2")
+	proxyStop(t, list, "10.0.0.4")
+	if count := len(list.FindAllByKey("shared")); count != 0 {
+		t.Errorf("%d registrations left, expected none", count)
+	}
+}
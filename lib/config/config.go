@@ -0,0 +1,294 @@
+package config
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+ * Settings read from INI style files:
+ *
+ *   # comment
+ *   [section]
+ *   key = value
+ *
+ * Files loaded later override settings from files loaded earlier, and the
+ * programs apply the settings before parsing their command line, so that
+ * flags override everything.
+ */
+type Config struct {
+	values   map[string]*value
+	files    []string
+	modTimes map[string]time.Time
+	missing  []string // default locations that had no file, but could get one later
+}
+
+type value struct {
+	section string
+	key     string
+	text    string
+	file    string
+	line    int
+	used    bool
+}
+
+func NewConfig() *Config {
+	return &Config{values: make(map[string]*value), modTimes: make(map[string]time.Time)}
+}
+
+// The system-wide and per-user locations of the configuration file for 'program', in the order
+// in which they should be loaded
+func DefaultPaths(program string) []string {
+	var paths []string
+
+	if runtime.GOOS == "windows" {
+		if dir := os.Getenv("ProgramData"); dir != "" {
+			paths = append(paths, filepath.Join(dir, "xdebug", program+".ini"))
+		}
+	} else {
+		paths = append(paths, filepath.Join("/etc/xdebug", program+".ini"))
+	}
+
+	if dir, err := os.UserConfigDir(); err == nil {
+		paths = append(paths, filepath.Join(dir, "xdebug", program+".ini"))
+	}
+
+	return paths
+}
+
+// Finds the value of '--name' in the command line arguments, so that the configuration file
+// can be read before getopt parses the flags it provides defaults for
+func FindFlag(args []string, name string) (string, bool) {
+	for i, arg := range args {
+		if arg == "--" {
+			break
+		}
+		if arg == "--"+name && i+1 < len(args) {
+			return args[i+1], true
+		}
+		if strings.HasPrefix(arg, "--"+name+"=") {
+			return strings.TrimPrefix(arg, "--"+name+"="), true
+		}
+	}
+
+	return "", false
+}
+
+// Loads the configuration for 'program'. If 'path' is set, only that file is read and it
+// must exist, otherwise the files from DefaultPaths that exist are read.
+func Load(program string, path string) (*Config, error) {
+	config := NewConfig()
+
+	if path != "" {
+		return config, config.LoadFile(path)
+	}
+
+	for _, path := range DefaultPaths(program) {
+		err := config.LoadFile(path)
+		if errors.Is(err, fs.ErrNotExist) {
+			config.missing = append(config.missing, path)
+			continue
+		}
+		if err != nil {
+			return config, err
+		}
+	}
+
+	return config, nil
+}
+
+// Reads the settings from 'path', overriding settings with the same name that were loaded before
+func (config *Config) LoadFile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("Can not read configuration file: %w", err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("Can not read configuration file: %w", err)
+	}
+
+	section := ""
+	lineNo := 0
+	scanner := bufio.NewScanner(file)
+
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+
+		if line == "" || line[0] == '#' || line[0] == ';' {
+			continue
+		}
+
+		if line[0] == '[' {
+			if line[len(line)-1] != ']' {
+				return fmt.Errorf("%s:%d: The section header '%s' is missing a closing ']'", path, lineNo, line)
+			}
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			continue
+		}
+
+		key, text, found := strings.Cut(line, "=")
+		if !found {
+			return fmt.Errorf("%s:%d: Expected 'key = value', but found '%s'", path, lineNo, line)
+		}
+
+		key = strings.TrimSpace(key)
+		if key == "" {
+			return fmt.Errorf("%s:%d: The setting has no name", path, lineNo)
+		}
+
+		config.values[section+"."+key] = &value{section: section, key: key, text: unquote(strings.TrimSpace(text)), file: path, line: lineNo}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("Can not read configuration file '%s': %w", path, err)
+	}
+
+	config.files = append(config.files, path)
+	config.modTimes[path] = info.ModTime()
+
+	return nil
+}
+
+func unquote(text string) string {
+	if len(text) >= 2 && (text[0] == '"' || text[0] == '\'') && text[len(text)-1] == text[0] {
+		return text[1 : len(text)-1]
+	}
+
+	return text
+}
+
+// The files that settings were read from
+func (config *Config) Files() []string {
+	return config.files
+}
+
+// Whether any of the files that settings were read from has been changed or removed since, or
+// a file has been created in one of the default locations that did not have one
+func (config *Config) Changed() bool {
+	for _, path := range config.files {
+		info, err := os.Stat(path)
+		if err != nil || !info.ModTime().Equal(config.modTimes[path]) {
+			return true
+		}
+	}
+
+	for _, path := range config.missing {
+		if _, err := os.Stat(path); err == nil {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Returns the text of a setting as it is in the file, and whether it is present
+func (config *Config) Text(section string, key string) (string, bool) {
+	if value := config.lookup(section, key); value != nil {
+		return value.text, true
+	}
+
+	return "", false
+}
+
+func (config *Config) lookup(section string, key string) *value {
+	value, ok := config.values[section+"."+key]
+	if !ok {
+		return nil
+	}
+
+	value.used = true
+
+	return value
+}
+
+func (value *value) errorf(format string, data ...interface{}) error {
+	return fmt.Errorf("%s:%d: Setting '%s' in section [%s]: %s", value.file, value.line, value.key, value.section, fmt.Sprintf(format, data...))
+}
+
+// Sets 'target' to the value of the setting, if it is present. This also goes for the Bool,
+// Int, and Duration methods, which return an error if the value has the wrong format.
+func (config *Config) String(section string, key string, target *string) error {
+	if value := config.lookup(section, key); value != nil {
+		*target = value.text
+	}
+
+	return nil
+}
+
+func (config *Config) Bool(section string, key string, target *bool) error {
+	value := config.lookup(section, key)
+	if value == nil {
+		return nil
+	}
+
+	switch strings.ToLower(value.text) {
+	case "1", "true", "yes", "on":
+		*target = true
+	case "0", "false", "no", "off":
+		*target = false
+	default:
+		return value.errorf("'%s' is not a boolean value", value.text)
+	}
+
+	return nil
+}
+
+func (config *Config) Int(section string, key string, target *int) error {
+	value := config.lookup(section, key)
+	if value == nil {
+		return nil
+	}
+
+	number, err := strconv.Atoi(value.text)
+	if err != nil {
+		return value.errorf("'%s' is not a number", value.text)
+	}
+
+	*target = number
+
+	return nil
+}
+
+func (config *Config) Duration(section string, key string, target *time.Duration) error {
+	value := config.lookup(section, key)
+	if value == nil {
+		return nil
+	}
+
+	duration, err := time.ParseDuration(value.text)
+	if err != nil {
+		return value.errorf("'%s' is not a duration, such as '30s' or '5m'", value.text)
+	}
+
+	*target = duration
+
+	return nil
+}
+
+// The settings that were loaded, but never asked for, which are most likely typos
+func (config *Config) Unused() []string {
+	var unused []string
+
+	for _, value := range config.values {
+		if !value.used {
+			unused = append(unused, fmt.Sprintf("%s:%d: Unknown setting '%s' in section [%s]", value.file, value.line, value.key, value.section))
+		}
+	}
+
+	sort.Strings(unused)
+
+	return unused
+}
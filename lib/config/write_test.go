@@ -0,0 +1,37 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "state.json")
+
+	for _, content := range []string{"first", "second"} {
+		if err := WriteFile(path, []byte(content), 0640); err != nil {
+			t.Fatalf("writing '%s' failed: %s", content, err)
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil || string(data) != content {
+			t.Errorf("read %q (%v), expected %q", data, err, content)
+		}
+	}
+
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0640 {
+		t.Errorf("unexpected file mode: %v (%v)", info.Mode(), err)
+	}
+
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("temporary files were left behind: %v", entries)
+	}
+}
+
+func TestWriteFileMissingDirectory(t *testing.T) {
+	if err := WriteFile(filepath.Join(t.TempDir(), "missing", "state.json"), []byte("data"), 0600); err == nil {
+		t.Errorf("expected an error")
+	}
+}
@@ -2,9 +2,11 @@ package connections
 
 import (
 	"fmt"
+	"github.com/derickr/dbgp-tools/lib/logger"
 	"github.com/google/uuid"
 	"net"
 	"sync"
+	"time"
 )
 
 type ConnectionControl struct {
@@ -28,6 +30,9 @@ type Connection struct {
 	sid             string
 	connection      *net.Conn
 	claimed         bool
+	multiple        bool
+	registered      time.Time
+	expires         time.Time
 	DebugRequests   chan int
 	ControlRequests chan *ConnectionControl
 }
@@ -43,6 +48,8 @@ func NewConnection(ideKey string, ipAddress string, port string, ssl bool, conne
 		ssl:             ssl,
 		connection:      connection,
 		claimed:         false,
+		multiple:        false,
+		registered:      time.Now(),
 		DebugRequests:   make(chan int),
 		ControlRequests: make(chan *ConnectionControl),
 	}
@@ -52,10 +59,31 @@ func (connection *Connection) IsSSL() bool {
 	return connection.ssl == true
 }
 
+// Whether the IDE allows other IDEs to register with the same IDE key (proxyinit -m 1)
+func (connection *Connection) SetMultipleSupported(multiple bool) {
+	connection.multiple = multiple
+}
+
+func (connection *Connection) IsMultipleSupported() bool {
+	return connection.multiple
+}
+
 func (connection *Connection) FullAddress() string {
 	return net.JoinHostPort(connection.ipAddress, connection.port)
 }
 
+func (connection *Connection) GetIPAddress() string {
+	return connection.ipAddress
+}
+
+func (connection *Connection) GetPort() string {
+	return connection.port
+}
+
+func (connection *Connection) IsClaimed() bool {
+	return connection.claimed
+}
+
 func (connection *Connection) GetKey() string {
 	return connection.ideKey
 }
@@ -68,42 +96,155 @@ func (connection *Connection) GetConnection() net.Conn {
 	return *connection.connection
 }
 
+func (connection *Connection) GetRegistrationTime() time.Time {
+	return connection.registered
+}
+
+// Returns when the registration expires, or the zero time if it never does
+func (connection *Connection) GetExpiryTime() time.Time {
+	return connection.expires
+}
+
+func (connection *Connection) IsExpired(now time.Time) bool {
+	return !connection.expires.IsZero() && now.After(connection.expires)
+}
+
+// Whether the registration is for an IDE that the proxy connects to, rather than a Cloud
+// connection that lives as long as its socket does
+func (connection *Connection) IsRegisteredAddress() bool {
+	return connection.connection == nil
+}
+
+type SelectionPolicy int
+
+const (
+	SelectFirstAvailable SelectionPolicy = iota
+	SelectRoundRobin
+	SelectMostRecent
+)
+
+func ParseSelectionPolicy(name string) (SelectionPolicy, error) {
+	switch name {
+	case "first", "first-available":
+		return SelectFirstAvailable, nil
+	case "round-robin":
+		return SelectRoundRobin, nil
+	case "recent", "most-recent":
+		return SelectMostRecent, nil
+	}
+
+	return SelectFirstAvailable, fmt.Errorf("Unknown IDE selection policy '%s', use 'first', 'round-robin', or 'recent'", name)
+}
+
 type ConnectionList struct {
 	sync.Mutex
-	forceAdd bool
-	connections map[string]*Connection
+	forceAdd    bool
+	policy      SelectionPolicy
+	connections map[string][]*Connection
+	nextIndex   map[string]int
+	stateFile   string
+	stateLogger logger.Logger
+	ttl         time.Duration
 }
 
 func NewConnectionList(forceAdd bool) *ConnectionList {
-	return &ConnectionList{connections: map[string]*Connection{}, forceAdd: forceAdd}
+	return &ConnectionList{connections: map[string][]*Connection{}, nextIndex: map[string]int{}, forceAdd: forceAdd, policy: SelectFirstAvailable}
+}
+
+func (list *ConnectionList) SetForceAdd(forceAdd bool) {
+	list.Lock()
+	defer list.Unlock()
+
+	list.forceAdd = forceAdd
+}
+
+func (list *ConnectionList) SetSelectionPolicy(policy SelectionPolicy) {
+	list.Lock()
+	defer list.Unlock()
+
+	list.policy = policy
+}
+
+// Sets how long IDE registrations last before they need to be renewed with another
+// 'proxyinit'. A zero duration means that registrations do not expire.
+func (list *ConnectionList) SetRegistrationTTL(ttl time.Duration) {
+	list.Lock()
+	defer list.Unlock()
+
+	list.ttl = ttl
 }
 
 func (list *ConnectionList) Add(connection *Connection) error {
 	list.Lock()
 	defer list.Unlock()
 
-	_, ok := list.connections[connection.ideKey]
+	if list.ttl > 0 && connection.IsRegisteredAddress() && connection.expires.IsZero() {
+		connection.expires = time.Now().Add(list.ttl)
+	}
 
-	if ok {
-		if (list.forceAdd) {
-			delete(list.connections, connection.ideKey)
+	existing := list.connections[connection.ideKey]
 
-			return nil
-		} else {
+	// An IDE that registers again from the same address renews its earlier registration. That
+	// is only allowed for IDE keys with several IDEs, and when registrations expire, as IDEs
+	// then have to renew them; otherwise, the key is still taken.
+	if connection.IsRegisteredAddress() && (connection.multiple || list.ttl > 0) {
+		for i, other := range existing {
+			if other.IsRegisteredAddress() && other.FullAddress() == connection.FullAddress() {
+				connection.registered = other.registered
+				existing[i] = connection
+				list.save()
 
-			return fmt.Errorf("A client for '%s' is already connected", connection.ideKey)
+				return nil
+			}
 		}
 	}
 
-	list.connections[connection.ideKey] = connection
+	if len(existing) == 0 {
+		list.connections[connection.ideKey] = []*Connection{connection}
+		list.save()
 
-	return nil
+		return nil
+	}
+
+	if connection.multiple && allSupportMultiple(existing) {
+		list.connections[connection.ideKey] = append(existing, connection)
+		list.save()
+
+		return nil
+	}
+
+	if list.forceAdd {
+		list.connections[connection.ideKey] = []*Connection{connection}
+		delete(list.nextIndex, connection.ideKey)
+		list.save()
+
+		return nil
+	}
+
+	return fmt.Errorf("A client for '%s' is already connected", connection.ideKey)
+}
+
+func allSupportMultiple(connections []*Connection) bool {
+	for _, connection := range connections {
+		if !connection.multiple {
+			return false
+		}
+	}
+
+	return true
+}
+
+func closeIfClaimed(connection *Connection) {
+	if connection.claimed {
+		connection.ControlRequests <- NewCloseConnectionControl()
+	}
 }
 
+// Removes all registrations for the IDE key
 func (list *ConnectionList) RemoveByKey(ideKey string) error {
 	list.Lock()
 
-	connection, ok := list.connections[ideKey]
+	connections, ok := list.connections[ideKey]
 
 	if !ok {
 		list.Unlock()
@@ -111,33 +252,157 @@ func (list *ConnectionList) RemoveByKey(ideKey string) error {
 	}
 
 	delete(list.connections, ideKey)
+	delete(list.nextIndex, ideKey)
+	list.save()
 	list.Unlock()
 
-	if connection.claimed {
-		connection.ControlRequests <- NewCloseConnectionControl()
+	for _, connection := range connections {
+		closeIfClaimed(connection)
 	}
 
 	return nil
 }
 
+// Removes the registrations for the IDE key that were made from 'ipAddress', leaving other
+// IDEs with the same IDE key alone
+func (list *ConnectionList) RemoveByAddress(ideKey string, ipAddress string) error {
+	removed := false
+
+	for _, connection := range list.FindAllByKey(ideKey) {
+		if connection.IsRegisteredAddress() && connection.ipAddress == ipAddress && list.Remove(connection) == nil {
+			removed = true
+		}
+	}
+
+	if !removed {
+		return fmt.Errorf("A client for '%s' has not been registered from %s", ideKey, ipAddress)
+	}
+
+	return nil
+}
+
+// Removes one specific registration, leaving other IDEs with the same IDE key alone
+func (list *ConnectionList) Remove(connection *Connection) error {
+	list.Lock()
+
+	connections := list.connections[connection.ideKey]
+
+	for i, other := range connections {
+		if other == connection {
+			connections = append(connections[:i:i], connections[i+1:]...)
+			if len(connections) == 0 {
+				delete(list.connections, connection.ideKey)
+				delete(list.nextIndex, connection.ideKey)
+			} else {
+				list.connections[connection.ideKey] = connections
+			}
+			list.save()
+			list.Unlock()
+
+			closeIfClaimed(connection)
+
+			return nil
+		}
+	}
+
+	list.Unlock()
+
+	return fmt.Errorf("The client at %s for '%s' is not registered", connection.FullAddress(), connection.ideKey)
+}
+
+// Returns a snapshot of all registrations
+func (list *ConnectionList) All() []*Connection {
+	list.Lock()
+	defer list.Unlock()
+
+	all := []*Connection{}
+	for _, connections := range list.connections {
+		all = append(all, connections...)
+	}
+
+	return all
+}
+
+// Removes, and returns, all registrations that have expired
+func (list *ConnectionList) RemoveExpired(now time.Time) []*Connection {
+	expired := []*Connection{}
+
+	for _, connection := range list.All() {
+		if connection.IsExpired(now) && list.Remove(connection) == nil {
+			expired = append(expired, connection)
+		}
+	}
+
+	return expired
+}
+
+// Returns the first registration for the IDE key
 func (list *ConnectionList) FindByKey(ideKey string) (*Connection, bool) {
 	list.Lock()
 	defer list.Unlock()
 
-	connection, ok := list.connections[ideKey]
+	connections, ok := list.connections[ideKey]
+	if !ok {
+		return nil, false
+	}
+
+	return connections[0], true
+}
+
+// Returns all registrations for the IDE key, in the order of registration
+func (list *ConnectionList) FindAllByKey(ideKey string) []*Connection {
+	list.Lock()
+	defer list.Unlock()
+
+	return append([]*Connection{}, list.connections[ideKey]...)
+}
+
+// Returns all registrations for the IDE key, in the order in which they should be tried
+// according to the selection policy
+func (list *ConnectionList) SelectByKey(ideKey string) []*Connection {
+	list.Lock()
+	defer list.Unlock()
+
+	connections := []*Connection{}
+	now := time.Now()
+
+	for _, connection := range list.connections[ideKey] {
+		if !connection.IsExpired(now) {
+			connections = append(connections, connection)
+		}
+	}
+
+	ordered := make([]*Connection, 0, len(connections))
+
+	switch list.policy {
+	case SelectRoundRobin:
+		start := list.nextIndex[ideKey] % max(len(connections), 1)
+		ordered = append(ordered, connections[start:]...)
+		ordered = append(ordered, connections[:start]...)
+		list.nextIndex[ideKey] = start + 1
+
+	case SelectMostRecent:
+		for i := len(connections) - 1; i >= 0; i-- {
+			ordered = append(ordered, connections[i])
+		}
+
+	default:
+		ordered = append(ordered, connections...)
+	}
 
-	return connection, ok
+	return ordered
 }
 
 func (list *ConnectionList) ClaimConnection(ideKey string) (*Connection, error) {
 	list.Lock()
 	defer list.Unlock()
 
-	connection, ok := list.connections[ideKey]
+	connections, ok := list.connections[ideKey]
 
 	if !ok {
 		return nil, fmt.Errorf("There is no IDE connected to Xdebug Cloud for UserID '%s'", ideKey)
 	}
+	connection := connections[0]
 	if connection.claimed {
 		return nil, fmt.Errorf("A Xdebug connection for UserID '%s' is already active", ideKey)
 	}
@@ -150,11 +415,12 @@ func (list *ConnectionList) UnclaimConnection(ideKey string) error {
 	list.Lock()
 	defer list.Unlock()
 
-	connection, ok := list.connections[ideKey]
+	connections, ok := list.connections[ideKey]
 
 	if !ok {
 		return fmt.Errorf("Can not find the connection with key '%s' to unclaim", ideKey)
 	}
+	connection := connections[0]
 	if !connection.claimed {
 		return fmt.Errorf("The connection with key '%s' was not claimed", ideKey)
 	}
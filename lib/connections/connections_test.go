@@ -0,0 +1,62 @@
+package connections
+
+import (
+	"io"
+	"testing"
+	"time"
+
+	"github.com/derickr/dbgp-tools/lib/logger"
+)
+
+func TestAddSameAddress(t *testing.T) {
+	tests := []struct {
+		name     string
+		multiple bool
+		ttl      time.Duration
+		forceAdd bool
+		accepted bool
+	}{
+		{"single IDE", false, 0, false, false},
+		{"single IDE, forced", false, 0, true, true},
+		{"single IDE, with expiry", false, time.Minute, false, true},
+		{"several IDEs", true, 0, false, true},
+	}
+
+	for _, test := range tests {
+		list := NewConnectionList(test.forceAdd)
+		list.SetRegistrationTTL(test.ttl)
+
+		first := NewConnection("key", "127.0.0.1", "9000", false, nil)
+		first.SetMultipleSupported(test.multiple)
+		if err := list.Add(first); err != nil {
+			t.Errorf("%s: first registration failed: %s", test.name, err)
+			continue
+		}
+
+		again := NewConnection("key", "127.0.0.1", "9000", false, nil)
+		again.SetMultipleSupported(test.multiple)
+		err := list.Add(again)
+
+		if (err == nil) != test.accepted {
+			t.Errorf("%s: registering again returned %v, expected it to be accepted: %v", test.name, err, test.accepted)
+		}
+		if count := len(list.All()); count != 1 {
+			t.Errorf("%s: %d registrations, expected 1", test.name, count)
+		}
+	}
+}
+
+func TestHealthCheckRemovesExpired(t *testing.T) {
+	list := NewConnectionList(false)
+	list.SetRegistrationTTL(time.Millisecond)
+
+	list.Add(NewConnection("key", "127.0.0.1", "9000", false, nil))
+	time.Sleep(5 * time.Millisecond)
+
+	// Without a probe timeout, IDEs are not connected to
+	NewHealthChecker(list, time.Hour, 0, logger.NewTextLogger(io.Discard)).Check()
+
+	if count := len(list.All()); count != 0 {
+		t.Errorf("%d registrations left, expected the expired one to be removed", count)
+	}
+}
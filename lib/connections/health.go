@@ -0,0 +1,114 @@
+package connections
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/derickr/dbgp-tools/lib/logger"
+)
+
+/*
+ * The HealthChecker periodically removes registrations that have expired, and
+ * optionally probes the address of every registered IDE with a TCP connection.
+ * IDEs that can not be reached are removed, so that stale registrations do not
+ * get engine connections routed to them. IDEs see a probe as a new debugging
+ * connection that is closed straight away, and might log it, which is why
+ * probing is off unless a probe timeout is given.
+ */
+type HealthChecker struct {
+	list     *ConnectionList
+	interval time.Duration
+	timeout  time.Duration
+	logger   logger.Logger
+	stop     chan struct{}
+	group    sync.WaitGroup
+}
+
+func NewHealthChecker(list *ConnectionList, interval time.Duration, timeout time.Duration, logger logger.Logger) *HealthChecker {
+	return &HealthChecker{
+		list:     list,
+		interval: interval,
+		timeout:  timeout,
+		logger:   logger,
+		stop:     make(chan struct{}),
+	}
+}
+
+func (checker *HealthChecker) Start() {
+	checker.group.Add(1)
+
+	go func() {
+		defer checker.group.Done()
+
+		ticker := time.NewTicker(checker.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-checker.stop:
+				return
+			case <-ticker.C:
+				checker.Check()
+			}
+		}
+	}()
+}
+
+func (checker *HealthChecker) Stop() {
+	close(checker.stop)
+	checker.group.Wait()
+}
+
+// Runs one round of expiry and liveness checks
+func (checker *HealthChecker) Check() {
+	for _, connection := range checker.list.RemoveExpired(time.Now()) {
+		checker.logger.LogUserInfo("health", connection.GetKey(), "Registration for IDE Key '%s' at %s expired", connection.GetKey(), connection.FullAddress())
+	}
+
+	if checker.timeout <= 0 {
+		return
+	}
+
+	var wait sync.WaitGroup
+
+	for _, connection := range checker.list.probeCandidates() {
+		wait.Add(1)
+		go func(connection *Connection) {
+			defer wait.Done()
+			checker.probe(connection)
+		}(connection)
+	}
+
+	wait.Wait()
+}
+
+// Returns the registrations that the proxy connects to. Claimed connections are
+// in use, which is proof enough that the IDE is alive.
+func (list *ConnectionList) probeCandidates() []*Connection {
+	list.Lock()
+	defer list.Unlock()
+
+	candidates := []*Connection{}
+	for _, connections := range list.connections {
+		for _, connection := range connections {
+			if connection.IsRegisteredAddress() && !connection.claimed {
+				candidates = append(candidates, connection)
+			}
+		}
+	}
+
+	return candidates
+}
+
+func (checker *HealthChecker) probe(connection *Connection) {
+	conn, err := net.DialTimeout("tcp", connection.FullAddress(), checker.timeout)
+	if err == nil {
+		conn.Close()
+		return
+	}
+
+	if checker.list.Remove(connection) == nil {
+		checker.logger.LogUserWarning("health", connection.GetKey(), "Removed registration for IDE Key '%s', as %s can not be reached: %s", connection.GetKey(), connection.FullAddress(), err)
+	}
+}
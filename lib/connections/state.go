@@ -0,0 +1,136 @@
+package connections
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/derickr/dbgp-tools/lib/config"
+	"github.com/derickr/dbgp-tools/lib/logger"
+)
+
+const stateFileVersion = 1
+
+type savedConnection struct {
+	IDEKey     string    `json:"idekey"`
+	Address    string    `json:"address"`
+	Port       string    `json:"port"`
+	SSL        bool      `json:"ssl"`
+	Multiple   bool      `json:"multiple"`
+	Registered time.Time `json:"registered"`
+	Expires    time.Time `json:"expires"`
+}
+
+type savedState struct {
+	Version     int               `json:"version"`
+	Connections []savedConnection `json:"connections"`
+}
+
+func (saved savedConnection) validate() error {
+	if saved.IDEKey == "" {
+		return fmt.Errorf("the IDE key is empty")
+	}
+	if saved.Address == "" {
+		return fmt.Errorf("the address is empty")
+	}
+	port, err := strconv.Atoi(saved.Port)
+	if err != nil || port < 1 || port > 65535 {
+		return fmt.Errorf("the port '%s' is not valid", saved.Port)
+	}
+
+	return nil
+}
+
+// Loads the registrations stored in 'path', and stores every change to the list in it from
+// now on. A missing file is not an error, as it is created with the first registration.
+func (list *ConnectionList) EnableStateFile(path string, logger logger.Logger) error {
+	data, err := os.ReadFile(path)
+
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("Can not read state file '%s': %w", path, err)
+	}
+
+	if err == nil {
+		var state savedState
+
+		if err := json.Unmarshal(data, &state); err != nil {
+			return fmt.Errorf("Can not parse state file '%s': %w", path, err)
+		}
+		if state.Version != stateFileVersion {
+			return fmt.Errorf("State file '%s' has version %d, but only version %d is supported", path, state.Version, stateFileVersion)
+		}
+
+		for _, saved := range state.Connections {
+			if err := saved.validate(); err != nil {
+				logger.LogWarning("state", "Ignoring stored registration for '%s': %s", saved.IDEKey, err)
+				continue
+			}
+			if !saved.Expires.IsZero() && time.Now().After(saved.Expires) {
+				logger.LogUserInfo("state", saved.IDEKey, "Not restoring expired registration for IDE Key '%s'", saved.IDEKey)
+				continue
+			}
+
+			connection := NewConnection(saved.IDEKey, saved.Address, saved.Port, saved.SSL, nil)
+			connection.multiple = saved.Multiple
+			if !saved.Registered.IsZero() {
+				connection.registered = saved.Registered
+			}
+			connection.expires = saved.Expires
+
+			if err := list.Add(connection); err != nil {
+				logger.LogWarning("state", "Ignoring stored registration for '%s': %s", saved.IDEKey, err)
+				continue
+			}
+			logger.LogUserInfo("state", saved.IDEKey, "Restored registration for IDE Key '%s': %s", saved.IDEKey, net.JoinHostPort(saved.Address, saved.Port))
+		}
+	}
+
+	list.Lock()
+	list.stateFile = path
+	list.stateLogger = logger
+	list.Unlock()
+
+	return nil
+}
+
+// Writes all IDE registrations to the state file. Must be called with the list locked.
+func (list *ConnectionList) save() {
+	if list.stateFile == "" {
+		return
+	}
+
+	state := savedState{Version: stateFileVersion, Connections: []savedConnection{}}
+
+	for _, connections := range list.connections {
+		for _, connection := range connections {
+			if !connection.IsRegisteredAddress() {
+				continue
+			}
+
+			state.Connections = append(state.Connections, savedConnection{
+				IDEKey:     connection.ideKey,
+				Address:    connection.ipAddress,
+				Port:       connection.port,
+				SSL:        connection.ssl,
+				Multiple:   connection.multiple,
+				Registered: connection.registered,
+				Expires:    connection.expires,
+			})
+		}
+	}
+
+	data, err := json.MarshalIndent(state, "", "\t")
+	if err != nil {
+		list.stateLogger.LogError("state", "Can not encode registrations: %s", err)
+		return
+	}
+
+	if err := config.WriteFile(list.stateFile, data, 0600); err != nil {
+		list.stateLogger.LogError("state", "Can not write state file '%s': %s", list.stateFile, err)
+	}
+}
@@ -0,0 +1,134 @@
+package connections
+
+import (
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/derickr/dbgp-tools/lib/logger"
+)
+
+func TestStateFileSaveAndLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	log := logger.NewTextLogger(io.Discard)
+
+	list := NewConnectionList(false)
+	if err := list.EnableStateFile(path, log); err != nil {
+		t.Fatalf("a missing state file was not accepted: %s", err)
+	}
+
+	first := NewConnection("shared", "10.0.0.1", "9003", false, nil)
+	first.SetMultipleSupported(true)
+	second := NewConnection("shared", "10.0.0.2", "9003", false, nil)
+	second.SetMultipleSupported(true)
+	secure := NewConnection("secure", "10.0.0.3", "9013", true, nil)
+
+	for _, connection := range []*Connection{first, second, secure} {
+		if err := list.Add(connection); err != nil {
+			t.Fatalf("registering '%s' failed: %s", connection.GetKey(), err)
+		}
+	}
+
+	// Cloud connections only live as long as their socket, and are not stored
+	cloudConn, other := net.Pipe()
+	defer cloudConn.Close()
+	defer other.Close()
+	if err := list.Add(NewConnection("cloud", "", "", false, &cloudConn)); err != nil {
+		t.Fatalf("adding the cloud connection failed: %s", err)
+	}
+
+	restored := NewConnectionList(false)
+	if err := restored.EnableStateFile(path, log); err != nil {
+		t.Fatalf("loading the state file failed: %s", err)
+	}
+
+	shared := restored.FindAllByKey("shared")
+	if len(shared) != 2 || shared[0].GetIPAddress() != "10.0.0.1" || shared[1].GetIPAddress() != "10.0.0.2" || !shared[0].IsMultipleSupported() {
+		t.Errorf("unexpected registrations for 'shared': %+v", shared)
+	}
+	if !shared[0].GetRegistrationTime().Equal(first.GetRegistrationTime()) {
+		t.Errorf("the registration time changed from %s to %s", first.GetRegistrationTime(), shared[0].GetRegistrationTime())
+	}
+
+	if connection, found := restored.FindByKey("secure"); !found || !connection.IsSSL() || connection.GetPort() != "9013" {
+		t.Errorf("unexpected registration for 'secure': %+v", connection)
+	}
+	if _, found := restored.FindByKey("cloud"); found {
+		t.Errorf("a cloud connection was stored")
+	}
+
+	// Removals are stored too
+	if err := restored.RemoveByKey("secure"); err != nil {
+		t.Fatalf("removing 'secure' failed: %s", err)
+	}
+
+	again := NewConnectionList(false)
+	if err := again.EnableStateFile(path, log); err != nil {
+		t.Fatalf("loading the state file failed: %s", err)
+	}
+	if _, found := again.FindByKey("secure"); found {
+		t.Errorf("a removed registration was restored")
+	}
+	if len(again.FindAllByKey("shared")) != 2 {
+		t.Errorf("the other registrations were not kept")
+	}
+}
+
+func TestStateFileRejected(t *testing.T) {
+	tests := []struct {
+		name     string
+		contents string
+	}{
+		{"wrong version", `{"version":2,"connections":[]}`},
+		{"no version", `{"connections":[]}`},
+		{"corrupt", `{"version":1,"connections":[`},
+		{"not JSON", "idekey=PHPSTORM\n"},
+	}
+
+	for _, test := range tests {
+		path := filepath.Join(t.TempDir(), "state.json")
+		if err := os.WriteFile(path, []byte(test.contents), 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		list := NewConnectionList(false)
+		if err := list.EnableStateFile(path, logger.NewTextLogger(io.Discard)); err == nil {
+			t.Errorf("%s: the state file was accepted", test.name)
+		}
+	}
+}
+
+func TestStateFileLoadDoesNotSave(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+
+	// Only a save would leave out the expired and invalid registrations, and indent the file
+	expired := time.Now().Add(-time.Hour).Format(time.RFC3339)
+	contents := `{"version":1,"connections":[` +
+		`{"idekey":"valid","address":"10.0.0.1","port":"9003"},` +
+		`{"idekey":"expired","address":"10.0.0.2","port":"9003","expires":"` + expired + `"},` +
+		`{"idekey":"invalid","address":"10.0.0.3","port":"99999"}]}`
+	if err := os.WriteFile(path, []byte(contents), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	list := NewConnectionList(false)
+	if err := list.EnableStateFile(path, logger.NewTextLogger(io.Discard)); err != nil {
+		t.Fatalf("loading the state file failed: %s", err)
+	}
+
+	if _, found := list.FindByKey("valid"); !found {
+		t.Errorf("the valid registration was not restored")
+	}
+	for _, key := range []string{"expired", "invalid"} {
+		if _, found := list.FindByKey(key); found {
+			t.Errorf("the '%s' registration was restored", key)
+		}
+	}
+
+	if data, err := os.ReadFile(path); err != nil || string(data) != contents {
+		t.Errorf("the state file was written while it was loaded: %q, %v", data, err)
+	}
+}
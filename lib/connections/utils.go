@@ -8,33 +8,14 @@ import (
 	"net"
 )
 
-func ConnectTo(address string, ssl bool) (net.Conn, error) {
-	var conn net.Conn
-	var err error
-	// var cert tls.Certificate
-
-	if ssl {
-		/*
-		cert, err = tls.LoadX509KeyPair("client-certs/client.pem", "client-certs/client.key")
-		if err != nil {
-			return nil, err
-		}
-		config := tls.Config{Certificates: []tls.Certificate{cert}, InsecureSkipVerify: true}
-		*/
-		conn, err = tls.Dial("tcp", address, nil)
-
-		if err != nil {
-			return nil, err
-		}
-	} else {
-		conn, err = net.Dial("tcp", address)
-
-		if err != nil {
-			return nil, err
-		}
+// Connects to 'address', over TLS if 'config' is not nil. Such a config is usually made with
+// tlsconfig.Settings.ClientConfig.
+func ConnectTo(address string, config *tls.Config) (net.Conn, error) {
+	if config != nil {
+		return tls.Dial("tcp", address, config)
 	}
 
-	return conn, nil
+	return net.Dial("tcp", address)
 }
 
 func CloudHostFromUserId(domain string, port string, uid string) string {
@@ -50,5 +31,5 @@ func ConnectToCloud(domain string, port string, uid string, logger logger.Logger
 
 	logger.LogInfo("utils", "Connecting to cloud host '%s'", host)
 
-	return ConnectTo(host, true)
+	return ConnectTo(host, &tls.Config{MinVersion: tls.VersionTLS12})
 }
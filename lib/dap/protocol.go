@@ -0,0 +1,127 @@
+package dap
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+/*
+ * The Debug Adapter Protocol (DAP) that VS Code, Neovim, and other editors use
+ * to talk to debuggers. Each message is a JSON object, preceded by a
+ * "Content-Length" header and an empty line. See
+ * https://microsoft.github.io/debug-adapter-protocol/specification
+ */
+type ProtocolMessage struct {
+	Seq  int    `json:"seq"`
+	Type string `json:"type"` // request, response, or event
+}
+
+type Request struct {
+	ProtocolMessage
+	Command   string          `json:"command"`
+	Arguments json.RawMessage `json:"arguments,omitempty"`
+}
+
+type Response struct {
+	ProtocolMessage
+	RequestSeq int         `json:"request_seq"`
+	Success    bool        `json:"success"`
+	Command    string      `json:"command"`
+	Message    string      `json:"message,omitempty"`
+	Body       interface{} `json:"body,omitempty"`
+}
+
+type Event struct {
+	ProtocolMessage
+	Event string      `json:"event"`
+	Body  interface{} `json:"body,omitempty"`
+}
+
+// Reads requests from, and writes responses and events to, an editor. Messages can be sent
+// from multiple goroutines at once.
+type Conn struct {
+	reader *bufio.Reader
+	writer io.Writer
+
+	writeLock sync.Mutex
+	seq       int
+}
+
+func NewConn(reader io.Reader, writer io.Writer) *Conn {
+	return &Conn{reader: bufio.NewReader(reader), writer: writer}
+}
+
+func (conn *Conn) ReadRequest() (*Request, error) {
+	headers, err := textproto.NewReader(conn.reader).ReadMIMEHeader()
+	if err != nil {
+		if err == io.EOF {
+			return nil, err
+		}
+		return nil, fmt.Errorf("Can not read message header: %w", err)
+	}
+
+	length, err := strconv.Atoi(strings.TrimSpace(headers.Get("Content-Length")))
+	if err != nil || length <= 0 {
+		return nil, fmt.Errorf("Invalid Content-Length header '%s'", headers.Get("Content-Length"))
+	}
+
+	data := make([]byte, length)
+	if _, err := io.ReadFull(conn.reader, data); err != nil {
+		return nil, fmt.Errorf("Can not read message: %w", err)
+	}
+
+	request := &Request{}
+	if err := json.Unmarshal(data, request); err != nil {
+		return nil, fmt.Errorf("Can not parse message: %w", err)
+	}
+	if request.Type != "request" {
+		return nil, fmt.Errorf("Expected a request, but received a message of type '%s'", request.Type)
+	}
+
+	return request, nil
+}
+
+func (conn *Conn) send(message interface{}, setSeq func(seq int)) error {
+	conn.writeLock.Lock()
+	defer conn.writeLock.Unlock()
+
+	conn.seq++
+	setSeq(conn.seq)
+
+	data, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(conn.writer, "Content-Length: %d\r\n\r\n%s", len(data), data)
+
+	return err
+}
+
+// Sends a successful response to 'request', with an optional body
+func (conn *Conn) SendResponse(request *Request, body interface{}) error {
+	response := &Response{ProtocolMessage: ProtocolMessage{Type: "response"}, RequestSeq: request.Seq, Success: true, Command: request.Command, Body: body}
+
+	return conn.send(response, func(seq int) { response.Seq = seq })
+}
+
+func (conn *Conn) SendError(request *Request, err error) error {
+	response := &Response{
+		ProtocolMessage: ProtocolMessage{Type: "response"}, RequestSeq: request.Seq, Command: request.Command, Message: err.Error(),
+		Body: ErrorResponseBody{Error: &Message{ID: 1, Format: err.Error(), ShowUser: true}},
+	}
+
+	return conn.send(response, func(seq int) { response.Seq = seq })
+}
+
+func (conn *Conn) SendEvent(name string, body interface{}) error {
+	event := &Event{ProtocolMessage: ProtocolMessage{Type: "event"}, Event: name, Body: body}
+
+	return conn.send(event, func(seq int) { event.Seq = seq })
+}
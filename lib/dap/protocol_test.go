@@ -0,0 +1,113 @@
+package dap
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+)
+
+func frame(message string) string {
+	return fmt.Sprintf("Content-Length: %d\r\n\r\n%s", len(message), message)
+}
+
+func TestReadRequest(t *testing.T) {
+	input := frame(`{"seq":1,"type":"request","command":"initialize","arguments":{"adapterID":"php"}}`) +
+		frame(`{"seq":2,"type":"request","command":"threads"}`)
+
+	conn := NewConn(strings.NewReader(input), io.Discard)
+
+	for _, expected := range []string{"initialize", "threads"} {
+		request, err := conn.ReadRequest()
+		if err != nil {
+			t.Fatalf("reading '%s' failed: %s", expected, err)
+		}
+		if request.Command != expected {
+			t.Errorf("read '%s', expected '%s'", request.Command, expected)
+		}
+	}
+
+	if _, err := conn.ReadRequest(); err != io.EOF {
+		t.Errorf("expected io.EOF at the end, got %v", err)
+	}
+}
+
+func TestReadRequestErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{"no Content-Length", "Content-Type: application/json\r\n\r\n{}"},
+		{"Content-Length is not a number", "Content-Length: ten\r\n\r\n{}"},
+		{"Content-Length is zero", "Content-Length: 0\r\n\r\n"},
+		{"Content-Length is negative", "Content-Length: -2\r\n\r\n{}"},
+		{"truncated message", "Content-Length: 50\r\n\r\n{\"seq\":1}"},
+		{"invalid JSON", frame(`{"seq":1,`)},
+		{"not a request", frame(`{"seq":1,"type":"event","event":"stopped"}`)},
+	}
+
+	for _, test := range tests {
+		conn := NewConn(strings.NewReader(test.input), io.Discard)
+
+		if request, err := conn.ReadRequest(); err == nil || err == io.EOF {
+			t.Errorf("%s: expected an error, got %+v, %v", test.name, request, err)
+		}
+	}
+}
+
+func TestSendFraming(t *testing.T) {
+	output := &bytes.Buffer{}
+	conn := NewConn(strings.NewReader(""), output)
+
+	request := &Request{ProtocolMessage: ProtocolMessage{Seq: 7, Type: "request"}, Command: "threads"}
+	conn.SendResponse(request, ThreadsResponseBody{Threads: []Thread{{ID: 1, Name: "Request 1"}}})
+	conn.SendEvent("stopped", StoppedEventBody{Reason: "breakpoint", ThreadID: 1})
+	conn.SendError(request, fmt.Errorf("No such thread"))
+
+	// What was sent can be read back the same way, so the seq numbers and lengths are checked
+	reader := NewConn(output, io.Discard).reader
+
+	for seq := 1; seq <= 3; seq++ {
+		var length int
+		if _, err := fmt.Fscanf(reader, "Content-Length: %d\r\n\r\n", &length); err != nil {
+			t.Fatalf("message %d: no header: %s", seq, err)
+		}
+
+		data := make([]byte, length)
+		if _, err := io.ReadFull(reader, data); err != nil {
+			t.Fatalf("message %d: %s", seq, err)
+		}
+
+		message := struct {
+			Seq        int    `json:"seq"`
+			Type       string `json:"type"`
+			RequestSeq int    `json:"request_seq"`
+			Success    bool   `json:"success"`
+			Message    string `json:"message"`
+		}{}
+		if err := json.Unmarshal(data, &message); err != nil {
+			t.Fatalf("message %d is not valid JSON: %s", seq, err)
+		}
+
+		if message.Seq != seq {
+			t.Errorf("message %d has seq %d", seq, message.Seq)
+		}
+
+		switch seq {
+		case 1:
+			if message.Type != "response" || message.RequestSeq != 7 || !message.Success {
+				t.Errorf("unexpected response: %s", data)
+			}
+		case 2:
+			if message.Type != "event" {
+				t.Errorf("unexpected event: %s", data)
+			}
+		case 3:
+			if message.Type != "response" || message.Success || message.Message != "No such thread" {
+				t.Errorf("unexpected error response: %s", data)
+			}
+		}
+	}
+}
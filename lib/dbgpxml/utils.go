@@ -1,9 +1,37 @@
 package dbgpxml
 
 import (
+	"encoding/xml"
+	"fmt"
 	"strings"
 )
 
 func IsValidXml(xml string) bool {
 	return strings.HasPrefix(xml, "<?xml")
 }
+
+var filenameFormatter = func(fileURI string) string { return fileURI }
+
+// Sets how the file URIs in responses are shown, for example as local paths when they are
+// mapped. By default, they are shown as they are.
+func SetFilenameFormatter(formatter func(fileURI string) string) {
+	filenameFormatter = formatter
+}
+
+// Escapes text for use in an attribute or element
+func Escape(text string) string {
+	var escaped strings.Builder
+
+	xml.EscapeText(&escaped, []byte(text))
+
+	return escaped.String()
+}
+
+// Creates the packet of a response with an <error> element, for answering a command on the
+// engine's behalf
+func ErrorResponse(command string, tid string, code int, message string) string {
+	return fmt.Sprintf(
+		`%s<response xmlns="urn:debugger_protocol_v1" xmlns:xdebug="https://xdebug.org/dbgp/xdebug" command="%s" transaction_id="%s"><error code="%d"><message>%s</message></error></response>`,
+		xml.Header, Escape(command), Escape(tid), code, Escape(message),
+	)
+}
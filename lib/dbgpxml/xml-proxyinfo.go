@@ -0,0 +1,81 @@
+package dbgpxml
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	. "github.com/logrusorgru/aurora" // WTFPL
+)
+
+type ProxyInfo struct {
+	XMLName     xml.Name         `xml:"proxyinfo"`
+	XmlNS       string           `xml:"xmlns,attr"`
+	XmlNSXdebug string           `xml:"xmlns:xdebug,attr"`
+	Success     int              `xml:"success,attr"`
+	IDEKey      string           `xml:"idekey,attr"`
+	Connections []ProxyListEntry `xml:"connection"`
+	Error       *ProxyInitError  `xml:"error,omitempty"`
+}
+
+func NewProxyInfo(success bool, ideKey string, connections []ProxyListEntry, infoError *ProxyInitError) *ProxyInfo {
+	successStr := 1
+	if !success {
+		successStr = 0
+	}
+
+	return &ProxyInfo{
+		XmlNS:       "urn:debugger_protocol_v1",
+		XmlNSXdebug: "https://xdebug.org/dbgp/xdebug",
+		Success:     successStr,
+		IDEKey:      ideKey,
+		Connections: connections,
+		Error:       infoError,
+	}
+}
+
+func (proxyInfo *ProxyInfo) AsXML() (string, error) {
+	var output bytes.Buffer
+
+	encoder := xml.NewEncoder(&output)
+
+	err := encoder.Encode(proxyInfo)
+
+	if err != nil {
+		return "", err
+	}
+
+	return xml.Header + output.String(), nil
+}
+
+func (info ProxyInfo) IsSuccess() bool {
+	return !!(info.Success == 1)
+}
+
+func (info ProxyInfo) GetErrorMessage() string {
+	if info.Error != nil {
+		return info.Error.Message
+	} else {
+		return "no error"
+	}
+}
+
+func (info ProxyInfo) ExpectMoreResponses() bool {
+	return false
+}
+
+func (info ProxyInfo) ShouldCloseConnection() bool {
+	return false
+}
+
+func (info ProxyInfo) String() string {
+	if info.Success == 0 {
+		return fmt.Sprintf("%s | %s: %s\n", Yellow(Bold("proxyinfo")), Bold(Red("failure")), BrightRed(info.Error.Message))
+	}
+
+	output := fmt.Sprintf("%s | %s\n", Yellow(Bold("proxyinfo")), Bold(Green("success")))
+	for _, entry := range info.Connections {
+		output += formatProxyListEntry(entry)
+	}
+
+	return output
+}
@@ -0,0 +1,113 @@
+package dbgpxml
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	. "github.com/logrusorgru/aurora" // WTFPL
+	"net"
+)
+
+type ProxyListEntry struct {
+	XMLName    xml.Name `xml:"connection"`
+	IDEKey     string   `xml:"idekey,attr"`
+	Address    string   `xml:"address,attr"`
+	Port       string   `xml:"port,attr"`
+	SSL        bool     `xml:"ssl,attr"`
+	Multiple   bool     `xml:"multiple,attr"`
+	Claimed    bool     `xml:"claimed,attr"`
+	Registered string   `xml:"registered,attr"`
+	Expires    string   `xml:"expires,attr,omitempty"`
+}
+
+type ProxyList struct {
+	XMLName     xml.Name         `xml:"proxylist"`
+	XmlNS       string           `xml:"xmlns,attr"`
+	XmlNSXdebug string           `xml:"xmlns:xdebug,attr"`
+	Success     int              `xml:"success,attr"`
+	Connections []ProxyListEntry `xml:"connection"`
+	Error       *ProxyInitError  `xml:"error,omitempty"`
+}
+
+func NewProxyList(success bool, connections []ProxyListEntry, listError *ProxyInitError) *ProxyList {
+	successStr := 1
+	if !success {
+		successStr = 0
+	}
+
+	return &ProxyList{
+		XmlNS:       "urn:debugger_protocol_v1",
+		XmlNSXdebug: "https://xdebug.org/dbgp/xdebug",
+		Success:     successStr,
+		Connections: connections,
+		Error:       listError,
+	}
+}
+
+func (proxyList *ProxyList) AsXML() (string, error) {
+	var output bytes.Buffer
+
+	encoder := xml.NewEncoder(&output)
+
+	err := encoder.Encode(proxyList)
+
+	if err != nil {
+		return "", err
+	}
+
+	return xml.Header + output.String(), nil
+}
+
+func (list ProxyList) IsSuccess() bool {
+	return !!(list.Success == 1)
+}
+
+func (list ProxyList) GetErrorMessage() string {
+	if list.Error != nil {
+		return list.Error.Message
+	} else {
+		return "no error"
+	}
+}
+
+func (list ProxyList) ExpectMoreResponses() bool {
+	return false
+}
+
+func (list ProxyList) ShouldCloseConnection() bool {
+	return false
+}
+
+func formatProxyListEntry(entry ProxyListEntry) string {
+	content := fmt.Sprintf("%s: %s", Bold(Yellow(entry.IDEKey)), Bold(Green(net.JoinHostPort(entry.Address, entry.Port))))
+
+	if entry.SSL {
+		content += fmt.Sprintf(" %s", BrightBlue("ssl"))
+	}
+	if entry.Multiple {
+		content += fmt.Sprintf(" %s", BrightBlue("multiple"))
+	}
+	if entry.Claimed {
+		content += fmt.Sprintf(" %s", BrightYellow("claimed"))
+	}
+
+	content += fmt.Sprintf(" | registered %s", entry.Registered)
+	if entry.Expires != "" {
+		content += fmt.Sprintf(" | expires %s", entry.Expires)
+	}
+
+	return content + "\n"
+}
+
+func (list ProxyList) String() string {
+	if list.Success == 0 {
+		return fmt.Sprintf("%s | %s: %s\n", Yellow(Bold("proxylist")), Bold(Red("failure")), BrightRed(list.Error.Message))
+	}
+
+	output := fmt.Sprintf("%s | %d registered IDE(s)\n", Yellow(Bold("proxylist")), Bold(Green(len(list.Connections))))
+	for _, entry := range list.Connections {
+		output += formatProxyListEntry(entry)
+	}
+
+	return output
+}
@@ -108,6 +108,7 @@ type Response struct {
 	FeatureName string    `xml:"feature_name,attr,omitempty"`
 	Reason      string    `xml:"reason,attr,omitempty"`
 	Encoding    string    `xml:"encoding,attr,omitempty"`
+	Depth       int       `xml:"depth,attr,omitempty"`
 	Stack       []Stack   `xml:"stack,omitempty"`
 	Contexts    []Context `xml:"context,omitempty"`
 	Typemap     []Typemap `xml:"map,omitempty"`
@@ -145,7 +146,7 @@ func formatFeatureSet(response Response) string {
 }
 
 func formatLocation(filename string, lineno int) string {
-	return fmt.Sprintf("%s:%d", Bold(Green(filename)), Bold(Green(lineno)))
+	return fmt.Sprintf("%s:%d", Bold(Green(filenameFormatter(filename))), Bold(Green(lineno)))
 }
 
 func formatFunction(class string, method string) string {
@@ -229,27 +230,55 @@ func formatProperty(tid string, leader string, prop Property) string {
 	return header + content + "\n"
 }
 
-func formatSource(response Response) string {
-	var content string
+// Returns the value of a property, decoded if it was base64 encoded
+func (prop Property) DecodedValue() string {
+	if prop.Encoding == "base64" {
+		value, _ := base64.StdEncoding.DecodeString(prop.Value)
+		return string(value)
+	}
+
+	return prop.Value
+}
 
+// Returns the lines of source code of a 'source' response
+func (response Response) SourceLines() []string {
 	value := []byte(response.Value)
 	if response.Encoding == "base64" {
 		value, _ = base64.StdEncoding.DecodeString(string(value))
 	}
 
 	if len(value) == 0 {
-		return fmt.Sprintf("%s | %s\n", Black(response.TID), Bold(Red("The result was empty")))
+		return nil
 	}
 
-	lines := strings.Split(strings.TrimRight(string(value), " \n"), "\n")
+	return strings.Split(strings.TrimRight(string(value), " \n"), "\n")
+}
+
+// Formats lines of source code, starting at line number 'begin'. When 'marker' is set, it
+// returns what to show in front of each line number, such as the current line, or a breakpoint.
+func FormatSourceLines(lines []string, begin int, marker func(lineno int) string) string {
+	var content string
 
 	for i, line := range lines {
-		content += fmt.Sprintf("%4d", Bold(Green((i+response.LastSourceBegin)))) + " " + line + "\n"
+		if marker != nil {
+			content += marker(i + begin)
+		}
+		content += fmt.Sprintf("%4d", Bold(Green((i+begin)))) + " " + line + "\n"
 	}
 
 	return content
 }
 
+func formatSource(response Response) string {
+	lines := response.SourceLines()
+
+	if len(lines) == 0 {
+		return fmt.Sprintf("%s | %s\n", Black(response.TID), Bold(Red("The result was empty")))
+	}
+
+	return FormatSourceLines(lines, response.LastSourceBegin, nil)
+}
+
 func formatBreakpointSet(response Response) string {
 	return fmt.Sprintf("%s | Breakpoint set with ID %s\n", Black(response.TID), Bold(Green(response.ID)))
 }
@@ -342,7 +371,7 @@ func (response Response) String() string {
 
 	case "run", "step_into", "step_over", "step_out":
 		if response.Status != "stopping" {
-			output += fmt.Sprintf("%s | %s:%d\n", Black(response.TID), Bold(Green(response.Message.Filename)), Bold(Green(response.Message.LineNo)))
+			output += fmt.Sprintf("%s | %s\n", Black(response.TID), formatLocation(response.Message.Filename, response.Message.LineNo))
 		}
 	}
 
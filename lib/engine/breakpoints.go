@@ -0,0 +1,161 @@
+package engine
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/derickr/dbgp-tools/lib/dbgpxml"
+)
+
+// How many lines further a line breakpoint can move when it is resolved
+const maxResolveDistance = 5
+
+type breakpoint struct {
+	id           int
+	kind         string // line, conditional, call, return, exception
+	state        string // enabled, disabled
+	temporary    bool
+	file         string
+	line         int
+	function     string
+	class        string
+	exception    string
+	expression   string
+	hitValue     int
+	hitCondition string // >=, ==, or %
+	hitCount     int
+	resolved     bool
+}
+
+// Whether the breakpoint triggers at step 'index', not taking its state or hit condition into account
+func (bp *breakpoint) matches(program *Program, index int) bool {
+	step := program.Steps[index]
+
+	switch bp.kind {
+	case "line", "conditional":
+		return step.File == bp.file && step.Line == bp.line
+
+	case "call":
+		entered := index == 0 || program.Steps[index-1].Depth < step.Depth
+		return entered && bp.matchesFunction(step)
+
+	case "return":
+		leaving := index == len(program.Steps)-1 || program.Steps[index+1].Depth < step.Depth
+		return leaving && bp.matchesFunction(step)
+
+	case "exception":
+		return step.Exception != "" && (bp.exception == "*" || bp.exception == step.Exception)
+	}
+
+	return false
+}
+
+// Line breakpoints resolve when execution first enters their file. Like Xdebug does, a
+// breakpoint on a line without code moves to the first line with code within the next few.
+func (bp *breakpoint) resolve(program *Program) bool {
+	if bp.resolved || (bp.kind != "line" && bp.kind != "conditional") {
+		return false
+	}
+
+	for line := bp.line; line <= bp.line+maxResolveDistance; line++ {
+		if program.hasLine(bp.file, line) {
+			bp.line = line
+			bp.resolved = true
+			return true
+		}
+	}
+
+	return false
+}
+
+func (bp *breakpoint) matchesFunction(step Step) bool {
+	return step.function() == bp.function && (bp.class == "" || step.Class == bp.class)
+}
+
+// Counts the hit, and returns whether execution should break
+func (bp *breakpoint) hit() bool {
+	bp.hitCount++
+
+	switch bp.hitCondition {
+	case "==":
+		return bp.hitCount == bp.hitValue
+	case "%":
+		return bp.hitValue > 0 && bp.hitCount%bp.hitValue == 0
+	default:
+		return bp.hitCount >= bp.hitValue
+	}
+}
+
+// The 'resolved' attribute is only included when the IDE enabled the resolved_breakpoints feature
+func (bp *breakpoint) attributes(withResolved bool) string {
+	attributes := fmt.Sprintf(`id="%d" type="%s" state="%s" hit_count="%d" hit_value="%d"`, bp.id, bp.kind, bp.state, bp.hitCount, bp.hitValue)
+
+	if bp.temporary {
+		attributes += ` temporary="1"`
+	}
+	if bp.hitCondition != "" {
+		attributes += fmt.Sprintf(` hit_condition="%s"`, dbgpxml.Escape(bp.hitCondition))
+	}
+
+	switch bp.kind {
+	case "line", "conditional":
+		attributes += fmt.Sprintf(` filename="%s" lineno="%d"`, dbgpxml.Escape(bp.file), bp.line)
+		if withResolved && bp.resolved {
+			attributes += ` resolved="resolved"`
+		} else if withResolved {
+			attributes += ` resolved="unresolved"`
+		}
+	case "call", "return":
+		attributes += fmt.Sprintf(` function="%s"`, dbgpxml.Escape(bp.function))
+		if bp.class != "" {
+			attributes += fmt.Sprintf(` class="%s"`, dbgpxml.Escape(bp.class))
+		}
+	case "exception":
+		attributes += fmt.Sprintf(` exception="%s"`, dbgpxml.Escape(bp.exception))
+	}
+
+	return attributes
+}
+
+func (bp *breakpoint) xml(withResolved bool) string {
+	if bp.expression == "" {
+		return fmt.Sprintf(`<breakpoint %s></breakpoint>`, bp.attributes(withResolved))
+	}
+
+	return fmt.Sprintf(`<breakpoint %s><expression encoding="base64">%s</expression></breakpoint>`, bp.attributes(withResolved), encode(bp.expression))
+}
+
+// Applies the options of breakpoint_set and breakpoint_update that both commands share
+func (bp *breakpoint) update(options map[string]string) error {
+	if state, ok := options["-s"]; ok {
+		if state != "enabled" && state != "disabled" {
+			return &commandError{code: errorInvalidOptions, message: fmt.Sprintf("Invalid breakpoint state '%s'", state)}
+		}
+		bp.state = state
+	}
+
+	if line, ok := options["-n"]; ok {
+		lineno, err := strconv.Atoi(line)
+		if err != nil || lineno < 1 {
+			return &commandError{code: errorInvalidOptions, message: fmt.Sprintf("Invalid line number '%s'", line)}
+		}
+		bp.line = lineno
+	}
+
+	if value, ok := options["-h"]; ok {
+		hitValue, err := strconv.Atoi(value)
+		if err != nil || hitValue < 0 {
+			return &commandError{code: errorInvalidOptions, message: fmt.Sprintf("Invalid hit value '%s'", value)}
+		}
+		bp.hitValue = hitValue
+	}
+
+	if condition, ok := options["-o"]; ok {
+		if condition != ">=" && condition != "==" && condition != "%" {
+			return &commandError{code: errorInvalidOptions, message: fmt.Sprintf("Invalid hit condition '%s'", condition)}
+		}
+		bp.hitCondition = condition
+	}
+
+	return nil
+}
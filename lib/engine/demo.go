@@ -0,0 +1,24 @@
+package engine
+
+import (
+	_ "embed"
+	"encoding/json"
+)
+
+//go:embed demo.json
+var demoProgram []byte
+
+// A small PHP script with a function call, a loop, output, and a notice, for when no
+// program is given
+func DemoProgram() *Program {
+	program := &Program{}
+
+	if err := json.Unmarshal(demoProgram, program); err != nil {
+		panic(err)
+	}
+	if err := program.validate(); err != nil {
+		panic(err)
+	}
+
+	return program
+}
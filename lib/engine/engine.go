@@ -0,0 +1,805 @@
+package engine
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/derickr/dbgp-tools/lib/dbgpxml"
+	"github.com/derickr/dbgp-tools/lib/protocol"
+	"github.com/derickr/dbgp-tools/lib/recorder"
+)
+
+// What the engine tells the IDE about itself in the init packet
+type Options struct {
+	IDEKey      string
+	CloudUserID string
+	AppID       string
+}
+
+/*
+ * A simulated debugging engine, which "executes" a Program and answers the
+ * DBGp commands that IDEs use most, so that the proxy, the client, and IDEs
+ * can be tried out without PHP and Xdebug. Like Xdebug, it connects to the
+ * IDE (or proxy), and runs one debugging session per connection.
+ */
+type Engine struct {
+	program *Program
+	options Options
+	trace   func(direction recorder.Direction, data string)
+}
+
+func NewEngine(program *Program, options Options) *Engine {
+	if options.AppID == "" {
+		options.AppID = strconv.Itoa(os.Getpid())
+	}
+
+	return &Engine{program: program, options: options}
+}
+
+// Calls 'trace' with every command and packet, for instance to show them
+func (engine *Engine) SetTrace(trace func(direction recorder.Direction, data string)) {
+	engine.trace = trace
+}
+
+// Connects to the IDE or proxy at 'address', and runs a debugging session
+func (engine *Engine) Connect(address string) error {
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	return engine.Serve(conn)
+}
+
+// Runs a debugging session over an established connection, until the IDE sends 'stop' or
+// 'detach', or closes the connection
+func (engine *Engine) Serve(conn io.ReadWriter) error {
+	session := engine.newSession(conn)
+
+	if err := session.send(session.initPacket()); err != nil {
+		return err
+	}
+
+	reader := bufio.NewReader(conn)
+
+	for !session.finished {
+		command, err := reader.ReadString('\000')
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+
+		command = strings.TrimSuffix(command, "\000")
+		if engine.trace != nil {
+			engine.trace(recorder.ToEngine, command)
+		}
+
+		if err := session.handle(command); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+type session struct {
+	engine   *Engine
+	program  *Program
+	writer   io.Writer
+	finished bool
+
+	position int    // the index of the current step, or -1 before the first one
+	status   string // starting, break, stopping, or stopped
+	loaded   map[string]bool
+
+	breakpoints      map[int]*breakpoint
+	nextBreakpointID int
+
+	features   map[string]string
+	stdoutMode int // 0: disable, 1: copy, 2: redirect
+}
+
+func (engine *Engine) newSession(writer io.Writer) *session {
+	return &session{
+		engine:      engine,
+		program:     engine.program,
+		writer:      writer,
+		position:    -1,
+		status:      "starting",
+		loaded:      map[string]bool{},
+		breakpoints: map[int]*breakpoint{},
+		features: map[string]string{
+			"max_children": "32",
+			"max_data":     "1024",
+			"max_depth":    "1",
+		},
+	}
+}
+
+func (session *session) send(packet string) error {
+	if session.engine.trace != nil {
+		session.engine.trace(recorder.FromEngine, packet)
+	}
+
+	_, err := fmt.Fprintf(session.writer, "%d\000%s\000", len(packet), packet)
+
+	return err
+}
+
+func (session *session) initPacket() string {
+	options := session.engine.options
+	program := session.program
+
+	attributes := fmt.Sprintf(
+		`fileuri="%s" language="%s" protocol_version="1.0" appid="%s" idekey="%s"`,
+		dbgpxml.Escape(program.Steps[0].File), dbgpxml.Escape(program.Language), dbgpxml.Escape(options.AppID), dbgpxml.Escape(options.IDEKey),
+	)
+	if program.LanguageVersion != "" {
+		attributes += fmt.Sprintf(` xdebug:language_version="%s"`, dbgpxml.Escape(program.LanguageVersion))
+	}
+	if options.CloudUserID != "" {
+		attributes += fmt.Sprintf(` xdebug:userid="%s"`, dbgpxml.Escape(options.CloudUserID))
+	}
+
+	return packet(fmt.Sprintf(
+		`<init %s %s><engine version="%s"><![CDATA[dbgpEngine]]></engine><author><![CDATA[Derick Rethans]]></author><url><![CDATA[https://xdebug.org]]></url><copyright><![CDATA[Copyright (c) 2025 by Derick Rethans]]></copyright></init>`,
+		namespaces, attributes, Version,
+	))
+}
+
+// The version of the simulated engine
+const Version = "0.1.0"
+
+// Returns the options of a command line, keyed by the option, such as "-n"
+func parseOptions(cl *protocol.CommandLine) map[string]string {
+	options := map[string]string{}
+
+	for i := 0; i < len(cl.Arguments); i++ {
+		option := cl.Arguments[i]
+		if i+1 < len(cl.Arguments) && !protocol.IsOption(cl.Arguments[i+1]) {
+			i++
+			options[option] = cl.Arguments[i]
+		} else {
+			options[option] = ""
+		}
+	}
+
+	return options
+}
+
+func intOption(options map[string]string, flag string, fallback int) (int, error) {
+	value, ok := options[flag]
+	if !ok {
+		return fallback, nil
+	}
+
+	number, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, &commandError{code: errorInvalidOptions, message: fmt.Sprintf("Invalid value '%s' for option %s", value, flag)}
+	}
+
+	return number, nil
+}
+
+func (session *session) handle(line string) error {
+	cl, err := protocol.ParseCommandLine(strings.TrimSpace(line))
+	if err != nil {
+		return session.send(errorPacket("", "", &commandError{code: errorInvalidOptions, message: err.Error()}))
+	}
+
+	options := parseOptions(cl)
+	tid := options["-i"]
+
+	data := ""
+	if cl.HasData {
+		decoded, err := base64.StdEncoding.DecodeString(cl.Data)
+		if err != nil {
+			return session.send(errorPacket(cl.Name, tid, &commandError{code: errorInvalidOptions, message: "The data after '--' is not base64 encoded"}))
+		}
+		data = string(decoded)
+	}
+
+	packets, err := session.execute(cl.Name, tid, options, data)
+	if err != nil {
+		cmdErr, ok := err.(*commandError)
+		if !ok {
+			return err
+		}
+		packets = append(packets, errorPacket(cl.Name, tid, cmdErr))
+	}
+
+	for _, packet := range packets {
+		if err := session.send(packet); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Returns the packets to send for a command; the last one is the response
+func (session *session) execute(command string, tid string, options map[string]string, data string) ([]string, error) {
+	if session.status == "stopped" {
+		return nil, &commandError{code: errorNotAvailable, message: "The script has finished"}
+	}
+
+	switch command {
+	case "status":
+		return session.respond(command, tid, session.statusAttributes(), ""), nil
+
+	case "run", "step_into", "step_over", "step_out":
+		return session.continueExecution(command, tid)
+
+	case "stop":
+		session.status = "stopped"
+		session.finished = true
+		return session.respond(command, tid, session.statusAttributes(), ""), nil
+
+	case "detach":
+		session.status = "stopping"
+		session.finished = true
+		return session.respond(command, tid, session.statusAttributes(), ""), nil
+
+	case "feature_get":
+		return session.featureGet(tid, options)
+
+	case "feature_set":
+		return session.featureSet(tid, options)
+
+	case "stdout":
+		mode, err := intOption(options, "-c", 0)
+		if err != nil || mode < 0 || mode > 2 {
+			return nil, &commandError{code: errorInvalidOptions, message: "The -c option must be 0, 1, or 2"}
+		}
+		session.stdoutMode = mode
+		return session.respond(command, tid, `success="1"`, ""), nil
+
+	case "breakpoint_set":
+		return session.breakpointSet(tid, options, data)
+
+	case "breakpoint_get", "breakpoint_update", "breakpoint_remove":
+		return session.breakpointCommand(command, tid, options)
+
+	case "breakpoint_list":
+		return session.breakpointList(tid), nil
+
+	case "stack_depth":
+		return session.respond(command, tid, fmt.Sprintf(`depth="%d"`, len(session.stack())), ""), nil
+
+	case "stack_get":
+		return session.stackGet(tid, options)
+
+	case "context_names":
+		return session.respond(command, tid, "", `<context name="Locals" id="0"></context><context name="Superglobals" id="1"></context>`), nil
+
+	case "context_get":
+		return session.contextGet(tid, options)
+
+	case "property_get", "property_value":
+		return session.propertyGet(command, tid, options)
+
+	case "typemap_get":
+		return session.respond(command, tid, `xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" xmlns:xsd="http://www.w3.org/2001/XMLSchema"`, typemap), nil
+
+	case "source":
+		return session.source(tid, options)
+
+	case "eval":
+		return session.eval(tid, options, data)
+	}
+
+	return nil, &commandError{code: errorUnimplemented, message: fmt.Sprintf("The command '%s' is not supported by the simulated engine", command)}
+}
+
+const typemap = `<map type="bool" name="bool" xsi:type="xsd:boolean"></map><map type="int" name="int" xsi:type="xsd:decimal"></map><map type="float" name="float" xsi:type="xsd:double"></map><map type="string" name="string" xsi:type="xsd:string"></map><map type="null" name="null"></map><map type="hash" name="array"></map><map type="object" name="object"></map><map type="resource" name="resource"></map>`
+
+func (session *session) respond(command string, tid string, attributes string, body string) []string {
+	return []string{responsePacket(command, tid, attributes, body)}
+}
+
+func (session *session) statusAttributes() string {
+	return fmt.Sprintf(`status="%s" reason="ok"`, session.status)
+}
+
+func (session *session) current() (Step, bool) {
+	if session.position < 0 || session.position >= len(session.program.Steps) {
+		return Step{}, false
+	}
+
+	return session.program.Steps[session.position], true
+}
+
+func (session *session) stack() []int {
+	if _, ok := session.current(); !ok {
+		return nil
+	}
+
+	return session.program.stack(session.position)
+}
+
+// Returns the step of the stack frame at 'depth', where 0 is the current one
+func (session *session) frame(options map[string]string) (Step, error) {
+	depth, err := intOption(options, "-d", 0)
+	if err != nil {
+		return Step{}, err
+	}
+
+	stack := session.stack()
+	if depth < 0 || depth >= len(stack) {
+		return Step{}, &commandError{code: errorInvalidStackDepth, message: fmt.Sprintf("There is no stack frame at depth %d", depth)}
+	}
+
+	return session.program.Steps[stack[depth]], nil
+}
+
+/*
+ * Executes steps until a breakpoint triggers, or until the condition of the
+ * stepping command is met. The output and notices of all steps that are
+ * executed on the way are sent before the response.
+ */
+func (session *session) continueExecution(command string, tid string) ([]string, error) {
+	if session.status == "stopping" {
+		// Like Xdebug, let the script end
+		session.status = "stopped"
+		session.finished = true
+		return session.respond(command, tid, session.statusAttributes(), ""), nil
+	}
+
+	steps := session.program.Steps
+	startDepth := 0
+	if step, ok := session.current(); ok {
+		startDepth = step.Depth
+	}
+
+	var packets []string
+
+	for index := session.position + 1; index < len(steps); index++ {
+		if index > 0 {
+			packets = append(packets, session.leave(steps[index-1])...)
+		}
+
+		session.position = index
+		step := steps[index]
+
+		if !session.loaded[step.File] {
+			session.loaded[step.File] = true
+			packets = append(packets, session.resolveBreakpoints(step.File)...)
+		}
+
+		breaks := session.checkBreakpoints(index)
+
+		switch command {
+		case "step_into":
+			breaks = true
+		case "step_over":
+			breaks = breaks || startDepth == 0 || step.Depth <= startDepth
+		case "step_out":
+			breaks = breaks || startDepth == 0 || step.Depth < startDepth
+		}
+
+		if breaks {
+			session.status = "break"
+			return append(packets, responsePacket(command, tid, session.statusAttributes(), messageElement(step))), nil
+		}
+	}
+
+	packets = append(packets, session.leave(steps[len(steps)-1])...)
+
+	session.position = len(steps)
+	session.status = "stopping"
+
+	return append(packets, responsePacket(command, tid, session.statusAttributes(), "")), nil
+}
+
+// Returns the packets for the output and notices that executing a step results in
+func (session *session) leave(step Step) []string {
+	var packets []string
+
+	if step.Output != "" && session.stdoutMode > 0 {
+		packets = append(packets, streamPacket("stdout", step.Output))
+	}
+
+	if session.features["notify_ok"] == "1" {
+		for _, notice := range step.Notices {
+			packets = append(packets, notifyPacket("error", fmt.Sprintf(
+				`<xdebug:message filename="%s" lineno="%d" type="Notice" code="8"><![CDATA[%s]]></xdebug:message>`,
+				dbgpxml.Escape(step.File), step.Line, notice,
+			)))
+		}
+	}
+
+	return packets
+}
+
+func (session *session) checkBreakpoints(index int) bool {
+	breaks := false
+
+	for _, bp := range session.sortedBreakpoints() {
+		if bp.state != "enabled" || !bp.matches(session.program, index) {
+			continue
+		}
+
+		if bp.kind == "conditional" {
+			result, err := evaluate(session.program.Steps[index].Variables, bp.expression)
+			if err != nil || !isTrue(result) {
+				continue
+			}
+		}
+
+		if bp.hit() {
+			breaks = true
+
+			if bp.temporary {
+				delete(session.breakpoints, bp.id)
+			}
+		}
+	}
+
+	return breaks
+}
+
+func (session *session) sortedBreakpoints() []*breakpoint {
+	breakpoints := make([]*breakpoint, 0, len(session.breakpoints))
+	for _, bp := range session.breakpoints {
+		breakpoints = append(breakpoints, bp)
+	}
+
+	sort.Slice(breakpoints, func(i, j int) bool {
+		return breakpoints[i].id < breakpoints[j].id
+	})
+
+	return breakpoints
+}
+
+// Resolves the line breakpoints in a file that was just loaded
+func (session *session) resolveBreakpoints(file string) []string {
+	var packets []string
+
+	for _, bp := range session.sortedBreakpoints() {
+		if bp.file == file && bp.resolve(session.program) {
+			packets = append(packets, session.resolvedNotification(bp)...)
+		}
+	}
+
+	return packets
+}
+
+func (session *session) resolvedNotification(bp *breakpoint) []string {
+	if session.features["resolved_breakpoints"] != "1" {
+		return nil
+	}
+
+	return []string{notifyPacket("breakpoint_resolved", bp.xml(true))}
+}
+
+var supportedFeatures = map[string]string{
+	"language_supports_threads": "0",
+	"language_name":             "PHP",
+	"language_version":          "",
+	"encoding":                  "iso-8859-1",
+	"protocol_version":          "1",
+	"supports_async":            "0",
+	"breakpoint_types":          "line conditional call return exception",
+	"multiple_sessions":         "0",
+	"supports_postmortem":       "0",
+}
+
+// Features that the IDE can change with feature_set
+var settableFeatures = []string{"max_children", "max_data", "max_depth", "notify_ok", "resolved_breakpoints", "show_hidden", "extended_properties", "breakpoint_details"}
+
+func (session *session) featureGet(tid string, options map[string]string) ([]string, error) {
+	name, ok := options["-n"]
+	if !ok {
+		return nil, &commandError{code: errorInvalidOptions, message: "The -n option is required"}
+	}
+
+	value, supported := supportedFeatures[name]
+	switch name {
+	case "language_name":
+		value = session.program.Language
+	case "language_version":
+		value = session.program.LanguageVersion
+	}
+
+	for _, feature := range settableFeatures {
+		if feature == name {
+			value, supported = session.features[name], true
+			if value == "" {
+				value = "0"
+			}
+		}
+	}
+
+	if !supported {
+		return session.respond("feature_get", tid, fmt.Sprintf(`feature_name="%s" supported="0"`, dbgpxml.Escape(name)), ""), nil
+	}
+
+	return session.respond("feature_get", tid, fmt.Sprintf(`feature_name="%s" supported="1"`, dbgpxml.Escape(name)), fmt.Sprintf(`<![CDATA[%s]]>`, value)), nil
+}
+
+func (session *session) featureSet(tid string, options map[string]string) ([]string, error) {
+	name, value := options["-n"], options["-v"]
+
+	for _, feature := range settableFeatures {
+		if feature != name {
+			continue
+		}
+
+		if strings.HasPrefix(name, "max_") {
+			if _, err := strconv.Atoi(value); err != nil {
+				return nil, &commandError{code: errorInvalidOptions, message: fmt.Sprintf("Invalid value '%s' for %s", value, name)}
+			}
+		}
+		session.features[name] = value
+
+		return session.respond("feature_set", tid, fmt.Sprintf(`feature="%s" success="1"`, dbgpxml.Escape(name)), ""), nil
+	}
+
+	return nil, &commandError{code: errorInvalidOptions, message: fmt.Sprintf("The feature '%s' can not be set", name)}
+}
+
+func (session *session) breakpointSet(tid string, options map[string]string, data string) ([]string, error) {
+	bp := &breakpoint{kind: options["-t"], state: "enabled", temporary: options["-r"] == "1"}
+
+	switch bp.kind {
+	case "line", "conditional":
+		bp.file = options["-f"]
+		if bp.file == "" {
+			step, ok := session.current()
+			if !ok {
+				return nil, &commandError{code: errorBreakpointNotSet, message: "The -f option is required before the script has started"}
+			}
+			bp.file = step.File
+		}
+		if _, ok := options["-n"]; !ok {
+			return nil, &commandError{code: errorInvalidOptions, message: "The -n option is required"}
+		}
+		if bp.kind == "conditional" {
+			if data == "" {
+				return nil, &commandError{code: errorInvalidOptions, message: "A conditional breakpoint requires an expression after '--'"}
+			}
+			bp.expression = data
+		}
+
+	case "call", "return":
+		bp.function, bp.class = options["-m"], options["-a"]
+		if bp.function == "" {
+			return nil, &commandError{code: errorInvalidOptions, message: "The -m option is required"}
+		}
+
+	case "exception":
+		bp.exception = options["-x"]
+		if bp.exception == "" {
+			return nil, &commandError{code: errorInvalidOptions, message: "The -x option is required"}
+		}
+
+	default:
+		return nil, &commandError{code: errorBreakpointType, message: fmt.Sprintf("The breakpoint type '%s' is not supported", bp.kind)}
+	}
+
+	if err := bp.update(options); err != nil {
+		return nil, err
+	}
+
+	session.nextBreakpointID++
+	bp.id = session.nextBreakpointID
+	session.breakpoints[bp.id] = bp
+
+	var packets []string
+	if session.loaded[bp.file] && bp.resolve(session.program) {
+		packets = session.resolvedNotification(bp)
+	}
+
+	attributes := fmt.Sprintf(`state="%s" id="%d"`, bp.state, bp.id)
+	if session.features["resolved_breakpoints"] == "1" && (bp.kind == "line" || bp.kind == "conditional") {
+		if bp.resolved {
+			attributes += ` resolved="resolved"`
+		} else {
+			attributes += ` resolved="unresolved"`
+		}
+	}
+
+	return append(packets, responsePacket("breakpoint_set", tid, attributes, "")), nil
+}
+
+func (session *session) breakpointCommand(command string, tid string, options map[string]string) ([]string, error) {
+	id, err := intOption(options, "-d", 0)
+	if err != nil {
+		return nil, err
+	}
+
+	bp, ok := session.breakpoints[id]
+	if !ok {
+		return nil, &commandError{code: errorNoSuchBreakpoint, message: fmt.Sprintf("There is no breakpoint with ID %d", id)}
+	}
+
+	switch command {
+	case "breakpoint_update":
+		if err := bp.update(options); err != nil {
+			return nil, err
+		}
+	case "breakpoint_remove":
+		delete(session.breakpoints, id)
+	}
+
+	return session.respond(command, tid, "", bp.xml(session.features["resolved_breakpoints"] == "1")), nil
+}
+
+func (session *session) breakpointList(tid string) []string {
+	var body strings.Builder
+
+	for _, bp := range session.sortedBreakpoints() {
+		body.WriteString(bp.xml(session.features["resolved_breakpoints"] == "1"))
+	}
+
+	return session.respond("breakpoint_list", tid, "", body.String())
+}
+
+func (session *session) stackGet(tid string, options map[string]string) ([]string, error) {
+	stack := session.stack()
+
+	levels := []int{}
+	if _, ok := options["-d"]; ok {
+		depth, err := intOption(options, "-d", 0)
+		if err != nil {
+			return nil, err
+		}
+		if depth < 0 || depth >= len(stack) {
+			return nil, &commandError{code: errorInvalidStackDepth, message: fmt.Sprintf("There is no stack frame at depth %d", depth)}
+		}
+		levels = append(levels, depth)
+	} else {
+		for level := range stack {
+			levels = append(levels, level)
+		}
+	}
+
+	var body strings.Builder
+	for _, level := range levels {
+		step := session.program.Steps[stack[level]]
+		fmt.Fprintf(&body, `<stack where="%s" level="%d" type="file" filename="%s" lineno="%d"></stack>`, dbgpxml.Escape(step.where()), level, dbgpxml.Escape(step.File), step.Line)
+	}
+
+	return session.respond("stack_get", tid, "", body.String()), nil
+}
+
+func (session *session) propertyOptions(options map[string]string) (propertyOptions, error) {
+	result := propertyOptions{}
+
+	result.maxChildren, _ = strconv.Atoi(session.features["max_children"])
+	result.maxData, _ = strconv.Atoi(session.features["max_data"])
+	result.maxDepth, _ = strconv.Atoi(session.features["max_depth"])
+
+	var err error
+	if result.maxData, err = intOption(options, "-m", result.maxData); err != nil {
+		return result, err
+	}
+	if result.page, err = intOption(options, "-p", 0); err != nil {
+		return result, err
+	}
+
+	return result, nil
+}
+
+func (session *session) contextGet(tid string, options map[string]string) ([]string, error) {
+	context, err := intOption(options, "-c", 0)
+	if err != nil {
+		return nil, err
+	}
+
+	switch context {
+	case 0:
+	case 1:
+		// There are no superglobals in the program model
+		return session.respond("context_get", tid, `context="1"`, ""), nil
+	default:
+		return nil, &commandError{code: errorInvalidContext, message: fmt.Sprintf("There is no context with ID %d", context)}
+	}
+
+	step, err := session.frame(options)
+	if err != nil {
+		return nil, err
+	}
+
+	propertyOptions, err := session.propertyOptions(options)
+	if err != nil {
+		return nil, err
+	}
+	propertyOptions.page = 0
+
+	var body strings.Builder
+	for _, variable := range step.Variables {
+		body.WriteString(propertyElement(variable, variable.Name, variable.Name, propertyOptions, 0))
+	}
+
+	return session.respond("context_get", tid, `context="0"`, body.String()), nil
+}
+
+func (session *session) propertyGet(command string, tid string, options map[string]string) ([]string, error) {
+	name := options["-n"]
+	if name == "" {
+		return nil, &commandError{code: errorInvalidOptions, message: "The -n option is required"}
+	}
+
+	step, err := session.frame(options)
+	if err != nil {
+		return nil, err
+	}
+
+	variable, ok := findVariable(step.Variables, name)
+	if !ok {
+		return nil, &commandError{code: errorNoSuchProperty, message: fmt.Sprintf("The property '%s' does not exist", name)}
+	}
+
+	propertyOptions, err := session.propertyOptions(options)
+	if err != nil {
+		return nil, err
+	}
+
+	if command == "property_value" {
+		value := variable.Value
+		if propertyOptions.maxData > 0 && len(value) > propertyOptions.maxData {
+			value = value[:propertyOptions.maxData]
+		}
+		return session.respond(command, tid, fmt.Sprintf(`size="%d" encoding="base64"`, len(variable.Value)), fmt.Sprintf(`<![CDATA[%s]]>`, encode(value))), nil
+	}
+
+	return session.respond(command, tid, "", propertyElement(variable, name, name, propertyOptions, 0)), nil
+}
+
+func (session *session) source(tid string, options map[string]string) ([]string, error) {
+	file := options["-f"]
+	if file == "" {
+		step, ok := session.current()
+		if !ok {
+			return nil, &commandError{code: errorCanNotOpenFile, message: "The -f option is required before the script has started"}
+		}
+		file = step.File
+	}
+
+	begin, err := intOption(options, "-b", 1)
+	if err != nil {
+		return nil, err
+	}
+	end, err := intOption(options, "-e", 0)
+	if err != nil {
+		return nil, err
+	}
+
+	source, ok := session.program.source(file, begin, end)
+	if !ok {
+		return nil, &commandError{code: errorCanNotOpenFile, message: fmt.Sprintf("Can not open '%s'", file)}
+	}
+
+	return session.respond("source", tid, `success="1" encoding="base64"`, fmt.Sprintf(`<![CDATA[%s]]>`, encode(source))), nil
+}
+
+func (session *session) eval(tid string, options map[string]string, expression string) ([]string, error) {
+	var variables []Variable
+
+	if step, ok := session.current(); ok {
+		variables = step.Variables
+	}
+
+	result, err := evaluate(variables, expression)
+	if err != nil {
+		return nil, &commandError{code: errorEvaluatingCode, message: err.Error()}
+	}
+
+	propertyOptions, err := session.propertyOptions(options)
+	if err != nil {
+		return nil, err
+	}
+
+	return session.respond("eval", tid, "", propertyElement(result, "", "", propertyOptions, 0)), nil
+}
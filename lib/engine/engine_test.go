@@ -0,0 +1,210 @@
+package engine
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+const testFile = "file:///app/loop.php"
+
+/*
+ * Calls check() three times from a loop:
+ *
+ *   index 0: line 6      index 3: line 8 ($i = 2)     index 6: line 3 ($x = 3)
+ *   index 1: line 8      index 4: line 3 ($x = 2)     index 7: line 10
+ *   index 2: line 3      index 5: line 8 ($i = 3)
+ */
+func testProgram(t *testing.T) *Program {
+	t.Helper()
+
+	list := Variable{Name: "$list", Type: "array"}
+	for _, name := range []string{"0", "1", "2", "3", "4"} {
+		list.Children = append(list.Children, Variable{Name: name, Type: "string", Value: "item " + name})
+	}
+
+	program := &Program{
+		Files: map[string]string{
+			testFile: "<?php\nfunction check($x) {\n\treturn $x;\n}\n\n$list = ['item 0', 'item 1', 'item 2', 'item 3', 'item 4'];\nfor ($i = 1; $i <= 3; $i++) {\n\tcheck($i);\n}\necho \"done\";\n",
+		},
+	}
+
+	for i := 1; i <= 3; i++ {
+		value := string(rune('0' + i))
+		program.Steps = append(program.Steps,
+			Step{File: testFile, Line: 8, Variables: []Variable{{Name: "$i", Type: "int", Value: value}, list}},
+			Step{File: testFile, Line: 3, Depth: 2, Function: "check", Variables: []Variable{{Name: "$x", Type: "int", Value: value}}},
+		)
+	}
+	program.Steps = append([]Step{{File: testFile, Line: 6}}, program.Steps...)
+	program.Steps = append(program.Steps, Step{File: testFile, Line: 10, Output: "done", Variables: []Variable{list}})
+
+	if err := program.validate(); err != nil {
+		t.Fatalf("invalid test program: %s", err)
+	}
+
+	return program
+}
+
+type testSession struct {
+	*session
+	t      *testing.T
+	output *bytes.Buffer
+}
+
+func newTestSession(t *testing.T) *testSession {
+	output := &bytes.Buffer{}
+	engine := NewEngine(testProgram(t), Options{IDEKey: "test"})
+
+	return &testSession{session: engine.newSession(output), t: t, output: output}
+}
+
+// Sends a command, and returns the packets that the engine sent in reply
+func (session *testSession) command(line string) string {
+	session.t.Helper()
+
+	session.output.Reset()
+	if err := session.handle(line); err != nil {
+		session.t.Fatalf("'%s' failed: %s", line, err)
+	}
+
+	return session.output.String()
+}
+
+func TestContinuation(t *testing.T) {
+	lineBreakpoint := "breakpoint_set -i 1 -t line -f " + testFile
+
+	tests := []struct {
+		name        string
+		breakpoints []string
+		commands    []string
+		positions   []int // the step after each command, where 8 is the end of the script
+	}{
+		{"step into", nil, []string{"step_into", "step_into", "step_into", "step_into"}, []int{0, 1, 2, 3}},
+		{"step over a call", nil, []string{"step_into", "step_into", "step_over", "step_over"}, []int{0, 1, 3, 5}},
+		{"step out of a function", nil, []string{"step_into", "step_into", "step_into", "step_out"}, []int{0, 1, 2, 3}},
+		{"run without breakpoints", nil, []string{"run"}, []int{8}},
+		{"line breakpoint", []string{lineBreakpoint + " -n 8"}, []string{"run", "run", "run", "run"}, []int{1, 3, 5, 8}},
+		{"disabled breakpoint", []string{lineBreakpoint + " -n 8 -s disabled"}, []string{"run"}, []int{8}},
+		{"temporary breakpoint", []string{lineBreakpoint + " -n 8 -r 1"}, []string{"run", "run"}, []int{1, 8}},
+		{"hit value", []string{lineBreakpoint + " -n 3 -h 2"}, []string{"run", "run"}, []int{4, 6}},
+		{"hit condition ==", []string{lineBreakpoint + " -n 3 -h 2 -o =="}, []string{"run", "run"}, []int{4, 8}},
+		{"hit condition %", []string{lineBreakpoint + " -n 8 -h 2 -o %"}, []string{"run", "run"}, []int{3, 8}},
+		{"conditional breakpoint", []string{"breakpoint_set -i 1 -t conditional -f " + testFile + " -n 3 -- " + encode("$x == 2")}, []string{"run", "run"}, []int{4, 8}},
+		{"resolved to the next line", []string{lineBreakpoint + " -n 7"}, []string{"run", "run"}, []int{1, 3}},
+		{"call breakpoint", []string{"breakpoint_set -i 1 -t call -m check"}, []string{"run", "run"}, []int{2, 4}},
+		{"return breakpoint", []string{"breakpoint_set -i 1 -t return -m check"}, []string{"run", "run"}, []int{2, 4}},
+	}
+
+	for _, test := range tests {
+		session := newTestSession(t)
+
+		for _, breakpoint := range test.breakpoints {
+			if response := session.command(breakpoint); strings.Contains(response, "<error") {
+				t.Fatalf("%s: '%s' failed: %s", test.name, breakpoint, response)
+			}
+		}
+
+		for i, command := range test.commands {
+			session.command(command + " -i 2")
+
+			if session.position != test.positions[i] {
+				t.Errorf("%s: after %s #%d at step %d, expected %d", test.name, command, i+1, session.position, test.positions[i])
+			}
+		}
+
+		expectedStatus := "break"
+		if session.position == len(session.program.Steps) {
+			expectedStatus = "stopping"
+		}
+		if session.status != expectedStatus {
+			t.Errorf("%s: status '%s', expected '%s'", test.name, session.status, expectedStatus)
+		}
+	}
+}
+
+func TestRunAfterStopping(t *testing.T) {
+	session := newTestSession(t)
+
+	if response := session.command("run -i 1"); !strings.Contains(response, `status="stopping"`) {
+		t.Errorf("unexpected response: %s", response)
+	}
+	if response := session.command("run -i 2"); !strings.Contains(response, `status="stopped"`) || !session.finished {
+		t.Errorf("the script did not end: %s", response)
+	}
+	if response := session.command("status -i 3"); !strings.Contains(response, `<error code="5">`) {
+		t.Errorf("commands are accepted after the script ended: %s", response)
+	}
+}
+
+func TestBreakpointResolving(t *testing.T) {
+	session := newTestSession(t)
+	session.command("feature_set -i 1 -n resolved_breakpoints -v 1")
+
+	tests := []struct {
+		line     string
+		resolved string // the line number that it resolves to, if any
+	}{
+		{"7", "8"},
+		{"8", "8"},
+		{"11", ""},
+	}
+
+	for _, test := range tests {
+		response := session.command("breakpoint_set -i 2 -t line -f " + testFile + " -n " + test.line)
+		if !strings.Contains(response, `resolved="unresolved"`) {
+			t.Errorf("line %s: resolved before the file was loaded: %s", test.line, response)
+		}
+	}
+
+	output := session.command("step_into -i 3")
+
+	for i, test := range tests {
+		notification := `lineno="` + test.resolved + `" resolved="resolved"`
+		resolved := strings.Contains(output, `<breakpoint id="`+string(rune('1'+i))+`"`) && strings.Contains(output, notification)
+
+		if resolved != (test.resolved != "") {
+			t.Errorf("line %s: expected it to resolve to line '%s': %s", test.line, test.resolved, output)
+		}
+	}
+
+	if strings.Index(output, "breakpoint_resolved") > strings.Index(output, "<response") {
+		t.Errorf("the notifications come after the response: %s", output)
+	}
+}
+
+func TestPropertyPaging(t *testing.T) {
+	session := newTestSession(t)
+	session.command("feature_set -i 1 -n max_children -v 2")
+	session.command("breakpoint_set -i 2 -t line -f " + testFile + " -n 10")
+	session.command("run -i 3")
+
+	tests := []struct {
+		page     string
+		included []string
+		excluded []string
+	}{
+		{"0", []string{"$list[0]", "$list[1]"}, []string{"$list[2]"}},
+		{"1", []string{"$list[2]", "$list[3]"}, []string{"$list[1]", "$list[4]"}},
+		{"2", []string{"$list[4]"}, []string{"$list[3]"}},
+		{"3", nil, []string{"$list[4]"}},
+	}
+
+	for _, test := range tests {
+		response := session.command("property_get -i 4 -n $list -p " + test.page)
+
+		if !strings.Contains(response, `numchildren="5" page="`+test.page+`" pagesize="2"`) {
+			t.Errorf("page %s: the paging attributes are missing: %s", test.page, response)
+		}
+		for _, name := range test.included {
+			if !strings.Contains(response, `fullname="`+name+`"`) {
+				t.Errorf("page %s: %s is missing: %s", test.page, name, response)
+			}
+		}
+		for _, name := range test.excluded {
+			if strings.Contains(response, `fullname="`+name+`"`) {
+				t.Errorf("page %s: %s is included: %s", test.page, name, response)
+			}
+		}
+	}
+}
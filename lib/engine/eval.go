@@ -0,0 +1,172 @@
+package engine
+
+import (
+	"cmp"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Finds a variable, or an element or property of one, by its full name, such as "$a['key']->b"
+func findVariable(variables []Variable, fullname string) (Variable, bool) {
+	for _, variable := range variables {
+		if variable.Name == fullname {
+			return variable, true
+		}
+		if !strings.HasPrefix(fullname, variable.Name) {
+			continue
+		}
+
+		if found, ok := findChild(variable, variable.Name, fullname); ok {
+			return found, true
+		}
+	}
+
+	return Variable{}, false
+}
+
+func findChild(parent Variable, parentName string, fullname string) (Variable, bool) {
+	for _, child := range parent.Children {
+		name := childName(Variable{Name: parentName, Type: parent.Type}, child)
+
+		if name == fullname {
+			return child, true
+		}
+		if strings.HasPrefix(fullname, name) {
+			if found, ok := findChild(child, name, fullname); ok {
+				return found, true
+			}
+		}
+	}
+
+	return Variable{}, false
+}
+
+/*
+ * Evaluates the small subset of PHP that the simulated engine understands:
+ * variables (including elements and properties), integer, float, and string
+ * literals, true, false, and null, and comparing two of those with "==",
+ * "!=", "<=", ">=", "<", or ">".
+ */
+func evaluate(variables []Variable, expression string) (Variable, error) {
+	expression = strings.TrimSpace(expression)
+
+	if left, operator, right, found := splitComparison(expression); found {
+		leftValue, err := evaluate(variables, left)
+		if err != nil {
+			return Variable{}, err
+		}
+		rightValue, err := evaluate(variables, right)
+		if err != nil {
+			return Variable{}, err
+		}
+
+		return boolVariable(compare(leftValue, rightValue, operator)), nil
+	}
+
+	switch strings.ToLower(expression) {
+	case "true":
+		return boolVariable(true), nil
+	case "false":
+		return boolVariable(false), nil
+	case "null":
+		return Variable{Type: "null"}, nil
+	}
+
+	if _, err := strconv.Atoi(expression); err == nil {
+		return Variable{Type: "int", Value: expression}, nil
+	}
+	if _, err := strconv.ParseFloat(expression, 64); err == nil {
+		return Variable{Type: "float", Value: expression}, nil
+	}
+
+	if len(expression) >= 2 && (expression[0] == '"' || expression[0] == '\'') && expression[len(expression)-1] == expression[0] {
+		return Variable{Type: "string", Value: expression[1 : len(expression)-1]}, nil
+	}
+
+	if strings.HasPrefix(expression, "$") {
+		variable, ok := findVariable(variables, expression)
+		if !ok {
+			return Variable{}, fmt.Errorf("Undefined variable %s", expression)
+		}
+		return variable, nil
+	}
+
+	return Variable{}, fmt.Errorf("Can not evaluate '%s'", expression)
+}
+
+// Finds the first comparison operator that is not in a string literal, or the "->" of a property
+func splitComparison(expression string) (string, string, string, bool) {
+	var quote byte
+
+	for i := 0; i < len(expression); i++ {
+		c := expression[i]
+
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case i+1 < len(expression) && expression[i+1] == '=' && (c == '=' || c == '!' || c == '<' || c == '>'):
+			return expression[:i], expression[i : i+2], expression[i+2:], true
+		case c == '<' || (c == '>' && (i == 0 || expression[i-1] != '-')):
+			return expression[:i], expression[i : i+1], expression[i+1:], true
+		}
+	}
+
+	return "", "", "", false
+}
+
+func boolVariable(value bool) Variable {
+	if value {
+		return Variable{Type: "bool", Value: "1"}
+	}
+
+	return Variable{Type: "bool", Value: "0"}
+}
+
+func compare(left Variable, right Variable, operator string) bool {
+	order := strings.Compare(left.Value, right.Value)
+
+	leftNumber, leftErr := strconv.ParseFloat(left.Value, 64)
+	rightNumber, rightErr := strconv.ParseFloat(right.Value, 64)
+	if leftErr == nil && rightErr == nil {
+		order = cmp.Compare(leftNumber, rightNumber)
+	}
+
+	switch operator {
+	case "==":
+		return order == 0
+	case "!=":
+		return order != 0
+	case "<=":
+		return order <= 0
+	case ">=":
+		return order >= 0
+	case "<":
+		return order < 0
+	case ">":
+		return order > 0
+	}
+
+	return false
+}
+
+// PHP's rules for converting a value to a boolean
+func isTrue(variable Variable) bool {
+	switch variable.Type {
+	case "null":
+		return false
+	case "array":
+		return len(variable.Children) > 0
+	case "object":
+		return true
+	case "int", "float":
+		number, _ := strconv.ParseFloat(variable.Value, 64)
+		return number != 0
+	}
+
+	return variable.Value != "" && variable.Value != "0"
+}
@@ -0,0 +1,169 @@
+package engine
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+/*
+ * A Program describes what the simulated engine "executes": the source of
+ * its files, and the sequence of steps that the execution goes through. Each
+ * step is one line at which the engine can break, together with the call
+ * depth, the function that the line is in, the variables in scope, and the
+ * output and notices that executing the line produces. The call stack at a
+ * step is derived from the steps before it with a lower depth.
+ *
+ * Programs are stored as JSON:
+ *
+ *   {
+ *     "language": "PHP",
+ *     "files": { "file:///app/index.php": "<?php\n$a = 42;\necho $a;\n" },
+ *     "steps": [
+ *       { "file": "file:///app/index.php", "line": 2 },
+ *       { "file": "file:///app/index.php", "line": 3, "output": "42",
+ *         "variables": [ { "name": "$a", "type": "int", "value": "42" } ] }
+ *     ]
+ *   }
+ */
+type Program struct {
+	Language        string            `json:"language"`
+	LanguageVersion string            `json:"language_version"`
+	Files           map[string]string `json:"files"`
+	Steps           []Step            `json:"steps"`
+}
+
+type Step struct {
+	File      string     `json:"file"`
+	Line      int        `json:"line"`
+	Function  string     `json:"function"` // "{main}" when empty
+	Class     string     `json:"class"`
+	Depth     int        `json:"depth"` // 1 for the main script, 2 for functions called from it, and so on
+	Variables []Variable `json:"variables"`
+	Output    string     `json:"output"`
+	Notices   []string   `json:"notices"`
+
+	// Set when the line throws an exception
+	Exception        string `json:"exception"`
+	ExceptionMessage string `json:"exception_message"`
+}
+
+type Variable struct {
+	Name     string     `json:"name"`
+	Type     string     `json:"type"` // int, float, bool, string, null, array, or object
+	Class    string     `json:"class"`
+	Value    string     `json:"value"`
+	Children []Variable `json:"children"`
+}
+
+func (step Step) function() string {
+	if step.Function == "" {
+		return "{main}"
+	}
+
+	return step.Function
+}
+
+func (step Step) where() string {
+	if step.Class != "" {
+		return step.Class + "->" + step.function()
+	}
+
+	return step.function()
+}
+
+func LoadProgram(path string) (*Program, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Can not read program: %w", err)
+	}
+
+	program := &Program{}
+	if err := json.Unmarshal(data, program); err != nil {
+		return nil, fmt.Errorf("Can not parse program '%s': %w", path, err)
+	}
+
+	if err := program.validate(); err != nil {
+		return nil, fmt.Errorf("Invalid program '%s': %w", path, err)
+	}
+
+	return program, nil
+}
+
+func (program *Program) validate() error {
+	if len(program.Steps) == 0 {
+		return fmt.Errorf("The program has no steps")
+	}
+
+	if program.Language == "" {
+		program.Language = "PHP"
+	}
+
+	for i := range program.Steps {
+		step := &program.Steps[i]
+
+		if step.Depth == 0 {
+			step.Depth = 1
+		}
+		if step.File == "" {
+			return fmt.Errorf("Step %d has no file", i+1)
+		}
+		if _, ok := program.Files[step.File]; !ok {
+			return fmt.Errorf("Step %d is in '%s', which is not one of the program's files", i+1, step.File)
+		}
+		if i > 0 && step.Depth > program.Steps[i-1].Depth+1 {
+			return fmt.Errorf("Step %d is more than one call deeper than the step before it", i+1)
+		}
+	}
+
+	return nil
+}
+
+// Returns the lines 'begin' to 'end' (inclusive, starting at 1) of a file, or all of them if 'end' is 0
+func (program *Program) source(file string, begin int, end int) (string, bool) {
+	source, ok := program.Files[file]
+	if !ok {
+		return "", false
+	}
+
+	lines := strings.SplitAfter(source, "\n")
+
+	if begin < 1 {
+		begin = 1
+	}
+	if end == 0 || end > len(lines) {
+		end = len(lines)
+	}
+	if begin > end {
+		return "", true
+	}
+
+	return strings.Join(lines[begin-1:end], ""), true
+}
+
+// Whether execution ever reaches the line, which is what makes a line breakpoint resolved
+func (program *Program) hasLine(file string, line int) bool {
+	for _, step := range program.Steps {
+		if step.File == file && step.Line == line {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Returns the index of the steps that make up the call stack at step 'current', innermost first
+func (program *Program) stack(current int) []int {
+	frames := []int{current}
+	depth := program.Steps[current].Depth
+
+	for i := current - 1; i >= 0 && depth > 1; i-- {
+		if program.Steps[i].Depth < depth {
+			frames = append(frames, i)
+			depth = program.Steps[i].Depth
+		}
+	}
+
+	return frames
+}
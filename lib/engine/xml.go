@@ -0,0 +1,134 @@
+package engine
+
+import (
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/derickr/dbgp-tools/lib/dbgpxml"
+)
+
+const namespaces = `xmlns="urn:debugger_protocol_v1" xmlns:xdebug="https://xdebug.org/dbgp/xdebug"`
+
+// DBGp error codes, as listed in the specification
+const (
+	errorInvalidOptions    = 3
+	errorUnimplemented     = 4
+	errorNotAvailable      = 5
+	errorCanNotOpenFile    = 100
+	errorBreakpointNotSet  = 200
+	errorBreakpointType    = 201
+	errorNoSuchBreakpoint  = 205
+	errorEvaluatingCode    = 206
+	errorNoSuchProperty    = 300
+	errorInvalidStackDepth = 301
+	errorInvalidContext    = 302
+)
+
+// An error that is returned to the IDE as an <error> element
+type commandError struct {
+	code    int
+	message string
+}
+
+func (err *commandError) Error() string {
+	return fmt.Sprintf("Error %d: %s", err.code, err.message)
+}
+
+func encode(text string) string {
+	return base64.StdEncoding.EncodeToString([]byte(text))
+}
+
+func packet(body string) string {
+	return xml.Header + body
+}
+
+func responsePacket(command string, tid string, attributes string, body string) string {
+	if attributes != "" {
+		attributes = " " + attributes
+	}
+
+	return packet(fmt.Sprintf(`<response %s command="%s" transaction_id="%s"%s>%s</response>`, namespaces, dbgpxml.Escape(command), dbgpxml.Escape(tid), attributes, body))
+}
+
+func errorPacket(command string, tid string, err *commandError) string {
+	return dbgpxml.ErrorResponse(command, tid, err.code, err.message)
+}
+
+func streamPacket(kind string, data string) string {
+	return packet(fmt.Sprintf(`<stream %s type="%s" encoding="base64"><![CDATA[%s]]></stream>`, namespaces, kind, encode(data)))
+}
+
+func notifyPacket(name string, body string) string {
+	return packet(fmt.Sprintf(`<notify %s name="%s">%s</notify>`, namespaces, name, body))
+}
+
+func messageElement(step Step) string {
+	if step.Exception != "" {
+		return fmt.Sprintf(`<xdebug:message filename="%s" lineno="%d" exception="%s"><![CDATA[%s]]></xdebug:message>`, dbgpxml.Escape(step.File), step.Line, dbgpxml.Escape(step.Exception), step.ExceptionMessage)
+	}
+
+	return fmt.Sprintf(`<xdebug:message filename="%s" lineno="%d"></xdebug:message>`, dbgpxml.Escape(step.File), step.Line)
+}
+
+// How much of a property to include in a response
+type propertyOptions struct {
+	maxChildren int
+	maxData     int
+	maxDepth    int
+	page        int
+}
+
+func childName(parent Variable, child Variable) string {
+	if parent.Type == "object" {
+		return parent.Name + "->" + child.Name
+	}
+
+	if _, err := strconv.Atoi(child.Name); err == nil {
+		return parent.Name + "[" + child.Name + "]"
+	}
+
+	return parent.Name + "['" + child.Name + "']"
+}
+
+func propertyElement(variable Variable, fullname string, name string, options propertyOptions, depth int) string {
+	attributes := fmt.Sprintf(`name="%s" fullname="%s" type="%s"`, dbgpxml.Escape(name), dbgpxml.Escape(fullname), variable.Type)
+	if variable.Class != "" {
+		attributes += fmt.Sprintf(` classname="%s"`, dbgpxml.Escape(variable.Class))
+	}
+
+	if variable.Type != "array" && variable.Type != "object" {
+		value := variable.Value
+		if options.maxData > 0 && len(value) > options.maxData {
+			value = value[:options.maxData]
+			attributes += fmt.Sprintf(` size="%d"`, len(variable.Value))
+		}
+		return fmt.Sprintf(`<property %s encoding="base64"><![CDATA[%s]]></property>`, attributes, encode(value))
+	}
+
+	children := len(variable.Children)
+	if children > 0 {
+		attributes += fmt.Sprintf(` children="1" numchildren="%d" page="%d" pagesize="%d"`, children, options.page, options.maxChildren)
+	} else {
+		attributes += ` children="0" numchildren="0"`
+	}
+
+	if depth >= options.maxDepth {
+		return fmt.Sprintf(`<property %s></property>`, attributes)
+	}
+
+	var body strings.Builder
+
+	first := options.page * options.maxChildren
+	for i := first; i < children && i < first+options.maxChildren; i++ {
+		child := variable.Children[i]
+		childOptions := options
+		childOptions.page = 0
+
+		body.WriteString(propertyElement(child, childName(Variable{Name: fullname, Type: variable.Type}, child), child.Name, childOptions, depth+1))
+	}
+
+	return fmt.Sprintf(`<property %s>%s</property>`, attributes, body.String())
+}
@@ -9,40 +9,59 @@ import (
 
 type ConsoleLogger struct {
 	output io.Writer
+	au     Aurora
 }
 
 func NewConsoleLogger(output io.Writer) *ConsoleLogger {
-	return &ConsoleLogger{output: output}
+	return &ConsoleLogger{output: output, au: NewAurora(true)}
+}
+
+// Like the console logger, but without colours, for writing to files
+func NewTextLogger(output io.Writer) *ConsoleLogger {
+	return &ConsoleLogger{output: output, au: NewAurora(false)}
 }
 
 func (logger *ConsoleLogger) logHandler(severity Value, category string, user string, format string, data []interface{}) {
 	if len(user) > 0 {
-		fmt.Fprintf(logger.output, "%s [%s] [%s] [%s] %s\n", time.Now().UTC().Format("2006-01-02 15:04:05.000"), severity, White(category), Bold(White(user)), fmt.Sprintf(format, data...))
+		fmt.Fprintf(logger.output, "%s [%s] [%s] [%s] %s\n", time.Now().UTC().Format("2006-01-02 15:04:05.000"), severity, logger.au.White(category), logger.au.Bold(logger.au.White(user)), fmt.Sprintf(format, data...))
 	} else {
-		fmt.Fprintf(logger.output, "%s [%s] [%s] %s\n", time.Now().UTC().Format("2006-01-02 15:04:05.000"), severity, White(category), fmt.Sprintf(format, data...))
+		fmt.Fprintf(logger.output, "%s [%s] [%s] %s\n", time.Now().UTC().Format("2006-01-02 15:04:05.000"), severity, logger.au.White(category), fmt.Sprintf(format, data...))
 	}
 }
 
 func (logger *ConsoleLogger) LogInfo(category string, format string, data ...interface{}) {
-	logger.logHandler(BrightGreen("info"), category, "", format, data)
+	logger.logHandler(logger.au.BrightGreen("info"), category, "", format, data)
 }
 
 func (logger *ConsoleLogger) LogWarning(category string, format string, data ...interface{}) {
-	logger.logHandler(BrightYellow("warn"), category, "", format, data)
+	logger.logHandler(logger.au.BrightYellow("warn"), category, "", format, data)
 }
 
 func (logger *ConsoleLogger) LogError(category string, format string, data ...interface{}) {
-	logger.logHandler(BrightRed("err "), category, "", format, data)
+	logger.logHandler(logger.au.BrightRed("err "), category, "", format, data)
 }
 
 func (logger *ConsoleLogger) LogUserInfo(category string, user string, format string, data ...interface{}) {
-	logger.logHandler(BrightGreen("info"), category, user, format, data)
+	logger.logHandler(logger.au.BrightGreen("info"), category, user, format, data)
 }
 
 func (logger *ConsoleLogger) LogUserWarning(category string, user string, format string, data ...interface{}) {
-	logger.logHandler(BrightYellow("warn"), category, user, format, data)
+	logger.logHandler(logger.au.BrightYellow("warn"), category, user, format, data)
 }
 
 func (logger *ConsoleLogger) LogUserError(category string, user string, format string, data ...interface{}) {
-	logger.logHandler(BrightRed("err "), category, user, format, data)
+	logger.logHandler(logger.au.BrightRed("err "), category, user, format, data)
+}
+
+func (logger *ConsoleLogger) LogFields(level Level, category string, user string, fields Fields, format string, data ...interface{}) {
+	severity := logger.au.BrightGreen("info")
+
+	switch level {
+	case LevelWarning:
+		severity = logger.au.BrightYellow("warn")
+	case LevelError:
+		severity = logger.au.BrightRed("err ")
+	}
+
+	logger.logHandler(severity, category, user, "%s%s", []interface{}{fmt.Sprintf(format, data...), logger.au.Faint(fields.String())})
 }
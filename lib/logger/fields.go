@@ -0,0 +1,102 @@
+package logger
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Structured information about a log message, such as the session or address it is about
+type Fields map[string]interface{}
+
+// Implemented by loggers that keep fields apart from the message
+type FieldLogger interface {
+	LogFields(level Level, category string, user string, fields Fields, format string, data ...interface{})
+}
+
+// Formats the fields as " key=value" pairs, sorted by key
+func (fields Fields) String() string {
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var builder strings.Builder
+	for _, key := range keys {
+		fmt.Fprintf(&builder, " %s=%v", key, fields[key])
+	}
+
+	return builder.String()
+}
+
+func logWithFields(logger Logger, level Level, category string, user string, fields Fields, format string, data []interface{}) {
+	if fieldLogger, ok := logger.(FieldLogger); ok {
+		fieldLogger.LogFields(level, category, user, fields, format, data...)
+		return
+	}
+
+	// Loggers that don't know about fields get them added to the message
+	message := fmt.Sprintf(format, data...) + fields.String()
+
+	switch {
+	case level == LevelError && user != "":
+		logger.LogUserError(category, user, "%s", message)
+	case level == LevelError:
+		logger.LogError(category, "%s", message)
+	case level == LevelWarning && user != "":
+		logger.LogUserWarning(category, user, "%s", message)
+	case level == LevelWarning:
+		logger.LogWarning(category, "%s", message)
+	case user != "":
+		logger.LogUserInfo(category, user, "%s", message)
+	default:
+		logger.LogInfo(category, "%s", message)
+	}
+}
+
+type fieldsLogger struct {
+	logger Logger
+	fields Fields
+}
+
+// Returns a logger that adds 'fields' to every message
+func WithFields(logger Logger, fields Fields) Logger {
+	return &fieldsLogger{logger: logger, fields: fields}
+}
+
+func (logger *fieldsLogger) LogFields(level Level, category string, user string, fields Fields, format string, data ...interface{}) {
+	merged := Fields{}
+	for key, value := range logger.fields {
+		merged[key] = value
+	}
+	for key, value := range fields {
+		merged[key] = value
+	}
+
+	logWithFields(logger.logger, level, category, user, merged, format, data)
+}
+
+func (logger *fieldsLogger) LogInfo(category string, format string, data ...interface{}) {
+	logger.LogFields(LevelInfo, category, "", nil, format, data...)
+}
+
+func (logger *fieldsLogger) LogWarning(category string, format string, data ...interface{}) {
+	logger.LogFields(LevelWarning, category, "", nil, format, data...)
+}
+
+func (logger *fieldsLogger) LogError(category string, format string, data ...interface{}) {
+	logger.LogFields(LevelError, category, "", nil, format, data...)
+}
+
+func (logger *fieldsLogger) LogUserInfo(category string, user string, format string, data ...interface{}) {
+	logger.LogFields(LevelInfo, category, user, nil, format, data...)
+}
+
+func (logger *fieldsLogger) LogUserWarning(category string, user string, format string, data ...interface{}) {
+	logger.LogFields(LevelWarning, category, user, nil, format, data...)
+}
+
+func (logger *fieldsLogger) LogUserError(category string, user string, format string, data ...interface{}) {
+	logger.LogFields(LevelError, category, user, nil, format, data...)
+}
@@ -0,0 +1,113 @@
+package logger
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// A log file that is rotated when it grows beyond a maximum size, or when it has been written
+// to for longer than a maximum age. Rotated files get the suffixes .1 (newest) to .<keep>.
+type RotatingFile struct {
+	sync.Mutex
+	path    string
+	maxSize int64
+	maxAge  time.Duration
+	keep    int
+	file    *os.File
+	size    int64
+	opened  time.Time
+}
+
+// A zero 'maxSize' or 'maxAge' disables that reason for rotating
+func NewRotatingFile(path string, maxSize int64, maxAge time.Duration, keep int) (*RotatingFile, error) {
+	file := &RotatingFile{path: path, maxSize: maxSize, maxAge: maxAge, keep: keep}
+
+	if err := file.open(); err != nil {
+		return nil, err
+	}
+
+	return file, nil
+}
+
+func (file *RotatingFile) open() error {
+	f, err := os.OpenFile(file.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return fmt.Errorf("Can not open log file: %w", err)
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("Can not open log file: %w", err)
+	}
+
+	file.file = f
+	file.size = info.Size()
+	file.opened = time.Now()
+
+	return nil
+}
+
+func (file *RotatingFile) needsRotation(length int) bool {
+	if file.maxSize > 0 && file.size > 0 && file.size+int64(length) > file.maxSize {
+		return true
+	}
+	if file.maxAge > 0 && time.Since(file.opened) > file.maxAge {
+		return true
+	}
+
+	return false
+}
+
+func (file *RotatingFile) rotate() error {
+	file.file.Close()
+
+	for i := file.keep - 1; i >= 1; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", file.path, i), fmt.Sprintf("%s.%d", file.path, i+1))
+	}
+
+	if file.keep > 0 {
+		os.Rename(file.path, file.path+".1")
+	} else {
+		os.Remove(file.path)
+	}
+
+	return file.open()
+}
+
+func (file *RotatingFile) Write(data []byte) (int, error) {
+	file.Lock()
+	defer file.Unlock()
+
+	if file.file == nil {
+		return 0, os.ErrClosed
+	}
+
+	if file.needsRotation(len(data)) {
+		if err := file.rotate(); err != nil {
+			file.file = nil
+			return 0, err
+		}
+	}
+
+	n, err := file.file.Write(data)
+	file.size += int64(n)
+
+	return n, err
+}
+
+func (file *RotatingFile) Close() error {
+	file.Lock()
+	defer file.Unlock()
+
+	if file.file == nil {
+		return nil
+	}
+
+	err := file.file.Close()
+	file.file = nil
+
+	return err
+}
@@ -0,0 +1,76 @@
+package logger
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRotatingFileAtSizeLimit(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "proxy.log")
+
+	file, err := NewRotatingFile(path, 20, 0, 2)
+	if err != nil {
+		t.Fatalf("opening the log file failed: %s", err)
+	}
+	defer file.Close()
+
+	// Each line is 10 bytes, so every file holds two of them
+	for i := 1; i <= 7; i++ {
+		if _, err := fmt.Fprintf(file, "line %04d\n", i); err != nil {
+			t.Fatalf("writing line %d failed: %s", i, err)
+		}
+	}
+
+	expected := map[string]string{
+		path:        "line 0007\n",
+		path + ".1": "line 0005\nline 0006\n",
+		path + ".2": "line 0003\nline 0004\n",
+	}
+	for name, contents := range expected {
+		data, err := os.ReadFile(name)
+		if err != nil || string(data) != contents {
+			t.Errorf("%s: got %q, %v, expected %q", filepath.Base(name), data, err, contents)
+		}
+	}
+
+	// Only 'keep' rotated files are kept
+	if _, err := os.Stat(path + ".3"); err == nil {
+		t.Errorf("more rotated files than asked for were kept")
+	}
+}
+
+func TestRotatingFileLongLine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "proxy.log")
+
+	file, err := NewRotatingFile(path, 5, 0, 1)
+	if err != nil {
+		t.Fatalf("opening the log file failed: %s", err)
+	}
+	defer file.Close()
+
+	// A line that is longer than the limit is written to an empty file, instead of rotating it
+	fmt.Fprintf(file, "a long line\n")
+	fmt.Fprintf(file, "another\n")
+
+	if data, _ := os.ReadFile(path + ".1"); string(data) != "a long line\n" {
+		t.Errorf("unexpected rotated file %q", data)
+	}
+	if data, _ := os.ReadFile(path); string(data) != "another\n" {
+		t.Errorf("unexpected log file %q", data)
+	}
+}
+
+func TestRotatingFileClosed(t *testing.T) {
+	file, err := NewRotatingFile(filepath.Join(t.TempDir(), "proxy.log"), 0, 0, 0)
+	if err != nil {
+		t.Fatalf("opening the log file failed: %s", err)
+	}
+
+	file.Close()
+
+	if _, err := file.Write([]byte("line\n")); err != os.ErrClosed {
+		t.Errorf("writing to a closed log file returned %v", err)
+	}
+}
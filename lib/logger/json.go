@@ -0,0 +1,73 @@
+package logger
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// Writes every message as a JSON object on its own line, for log collectors
+type JSONLogger struct {
+	sync.Mutex
+	output io.Writer
+}
+
+type jsonEntry struct {
+	Time     string `json:"time"`
+	Level    string `json:"level"`
+	Category string `json:"category"`
+	User     string `json:"user,omitempty"`
+	Message  string `json:"message"`
+	Fields   Fields `json:"fields,omitempty"`
+}
+
+func NewJSONLogger(output io.Writer) *JSONLogger {
+	return &JSONLogger{output: output}
+}
+
+func (logger *JSONLogger) LogFields(level Level, category string, user string, fields Fields, format string, data ...interface{}) {
+	entry := jsonEntry{
+		Time:     time.Now().UTC().Format("2006-01-02T15:04:05.000Z07:00"),
+		Level:    level.String(),
+		Category: category,
+		User:     user,
+		Message:  fmt.Sprintf(format, data...),
+		Fields:   fields,
+	}
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		line, _ = json.Marshal(jsonEntry{Time: entry.Time, Level: entry.Level, Category: category, User: user, Message: entry.Message})
+	}
+
+	logger.Lock()
+	defer logger.Unlock()
+
+	logger.output.Write(append(line, '\n'))
+}
+
+func (logger *JSONLogger) LogInfo(category string, format string, data ...interface{}) {
+	logger.LogFields(LevelInfo, category, "", nil, format, data...)
+}
+
+func (logger *JSONLogger) LogWarning(category string, format string, data ...interface{}) {
+	logger.LogFields(LevelWarning, category, "", nil, format, data...)
+}
+
+func (logger *JSONLogger) LogError(category string, format string, data ...interface{}) {
+	logger.LogFields(LevelError, category, "", nil, format, data...)
+}
+
+func (logger *JSONLogger) LogUserInfo(category string, user string, format string, data ...interface{}) {
+	logger.LogFields(LevelInfo, category, user, nil, format, data...)
+}
+
+func (logger *JSONLogger) LogUserWarning(category string, user string, format string, data ...interface{}) {
+	logger.LogFields(LevelWarning, category, user, nil, format, data...)
+}
+
+func (logger *JSONLogger) LogUserError(category string, user string, format string, data ...interface{}) {
+	logger.LogFields(LevelError, category, user, nil, format, data...)
+}
@@ -0,0 +1,78 @@
+package logger
+
+import (
+	"fmt"
+	"strings"
+)
+
+type Level int
+
+const (
+	LevelInfo Level = iota
+	LevelWarning
+	LevelError
+)
+
+func ParseLevel(level string) (Level, error) {
+	switch strings.ToLower(level) {
+	case "info":
+		return LevelInfo, nil
+	case "warn", "warning":
+		return LevelWarning, nil
+	case "err", "error":
+		return LevelError, nil
+	}
+
+	return LevelInfo, fmt.Errorf("The log level '%s' is not supported, use one of info, warning, or error", level)
+}
+
+func (level Level) String() string {
+	switch level {
+	case LevelWarning:
+		return "warning"
+	case LevelError:
+		return "error"
+	default:
+		return "info"
+	}
+}
+
+// Passes on messages of at least the minimum level to another logger
+type LevelFilter struct {
+	logger  Logger
+	minimum Level
+}
+
+func NewLevelFilter(logger Logger, minimum Level) *LevelFilter {
+	return &LevelFilter{logger: logger, minimum: minimum}
+}
+
+func (filter *LevelFilter) LogFields(level Level, category string, user string, fields Fields, format string, data ...interface{}) {
+	if level >= filter.minimum {
+		logWithFields(filter.logger, level, category, user, fields, format, data)
+	}
+}
+
+func (filter *LevelFilter) LogInfo(category string, format string, data ...interface{}) {
+	filter.LogFields(LevelInfo, category, "", nil, format, data...)
+}
+
+func (filter *LevelFilter) LogWarning(category string, format string, data ...interface{}) {
+	filter.LogFields(LevelWarning, category, "", nil, format, data...)
+}
+
+func (filter *LevelFilter) LogError(category string, format string, data ...interface{}) {
+	filter.LogFields(LevelError, category, "", nil, format, data...)
+}
+
+func (filter *LevelFilter) LogUserInfo(category string, user string, format string, data ...interface{}) {
+	filter.LogFields(LevelInfo, category, user, nil, format, data...)
+}
+
+func (filter *LevelFilter) LogUserWarning(category string, user string, format string, data ...interface{}) {
+	filter.LogFields(LevelWarning, category, user, nil, format, data...)
+}
+
+func (filter *LevelFilter) LogUserError(category string, user string, format string, data ...interface{}) {
+	filter.LogFields(LevelError, category, user, nil, format, data...)
+}
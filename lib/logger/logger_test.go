@@ -0,0 +1,148 @@
+package logger
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func decodeLines(t *testing.T, output *bytes.Buffer) []map[string]interface{} {
+	t.Helper()
+
+	entries := []map[string]interface{}{}
+	for _, line := range strings.Split(strings.TrimSuffix(output.String(), "\n"), "\n") {
+		if line == "" {
+			continue
+		}
+
+		entry := map[string]interface{}{}
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("%q is not a JSON object: %s", line, err)
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries
+}
+
+func TestJSONLogger(t *testing.T) {
+	output := &bytes.Buffer{}
+	logger := NewJSONLogger(output)
+
+	logger.LogUserWarning("proxy", "PHPSTORM", "Can not connect to %s", "10.0.0.1:9003")
+	logger.LogFields(LevelInfo, "server", "", Fields{"address": "10.0.0.2", "port": 9003}, "Listening")
+
+	entries := decodeLines(t, output)
+	if len(entries) != 2 {
+		t.Fatalf("expected one line per message, got %q", output.String())
+	}
+
+	warning := entries[0]
+	if warning["level"] != "warning" || warning["category"] != "proxy" || warning["user"] != "PHPSTORM" || warning["message"] != "Can not connect to 10.0.0.1:9003" {
+		t.Errorf("unexpected entry %v", warning)
+	}
+	if _, found := warning["fields"]; found {
+		t.Errorf("a message without fields has them: %v", warning)
+	}
+	if _, err := time.Parse(time.RFC3339, warning["time"].(string)); err != nil {
+		t.Errorf("the time is not in RFC 3339 format: %s", err)
+	}
+
+	info := entries[1]
+	if _, found := info["user"]; found {
+		t.Errorf("a message without a user has one: %v", info)
+	}
+	fields, ok := info["fields"].(map[string]interface{})
+	if !ok || fields["address"] != "10.0.0.2" || fields["port"] != float64(9003) {
+		t.Errorf("unexpected fields %v", info["fields"])
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	tests := []struct {
+		name     string
+		expected Level
+	}{
+		{"info", LevelInfo},
+		{"WARN", LevelWarning},
+		{"warning", LevelWarning},
+		{"err", LevelError},
+		{"Error", LevelError},
+	}
+
+	for _, test := range tests {
+		level, err := ParseLevel(test.name)
+		if err != nil || level != test.expected {
+			t.Errorf("'%s': got %s, %v, expected %s", test.name, level, err, test.expected)
+		}
+	}
+
+	if _, err := ParseLevel("debug"); err == nil {
+		t.Errorf("an unsupported level was accepted")
+	}
+}
+
+func TestLevelFilter(t *testing.T) {
+	output := &bytes.Buffer{}
+	filter := NewLevelFilter(NewJSONLogger(output), LevelWarning)
+
+	filter.LogInfo("proxy", "dropped")
+	filter.LogUserInfo("proxy", "PHPSTORM", "dropped")
+	filter.LogWarning("proxy", "kept")
+	filter.LogUserError("proxy", "PHPSTORM", "kept")
+
+	entries := decodeLines(t, output)
+	if len(entries) != 2 {
+		t.Fatalf("expected the two messages of at least warning level, got %q", output.String())
+	}
+	if entries[0]["level"] != "warning" || entries[1]["level"] != "error" || entries[1]["user"] != "PHPSTORM" {
+		t.Errorf("unexpected entries %v", entries)
+	}
+}
+
+func TestWithFields(t *testing.T) {
+	output := &bytes.Buffer{}
+	session := WithFields(WithFields(NewJSONLogger(output), Fields{"session": "1", "idekey": "a"}), Fields{"idekey": "b"})
+
+	session.LogInfo("proxy", "Started")
+	session.(FieldLogger).LogFields(LevelInfo, "proxy", "", Fields{"command": "run"}, "Forwarded")
+
+	entries := decodeLines(t, output)
+	if len(entries) != 2 {
+		t.Fatalf("unexpected output %q", output.String())
+	}
+
+	// The innermost fields win, and the ones of the message are added to them
+	if fields := entries[0]["fields"].(map[string]interface{}); len(fields) != 2 || fields["session"] != "1" || fields["idekey"] != "b" {
+		t.Errorf("unexpected fields %v", fields)
+	}
+	if fields := entries[1]["fields"].(map[string]interface{}); len(fields) != 3 || fields["command"] != "run" {
+		t.Errorf("unexpected fields %v", fields)
+	}
+
+	// Loggers that don't know about fields get them in the message
+	text := &bytes.Buffer{}
+	WithFields(NewTextLogger(text), Fields{"session": "1", "address": "10.0.0.1"}).LogError("proxy", "Failed")
+
+	if !strings.Contains(text.String(), "Failed address=10.0.0.1 session=1\n") {
+		t.Errorf("the fields are not in the message: %q", text.String())
+	}
+}
+
+func TestMultiLogger(t *testing.T) {
+	jsonOutput := &bytes.Buffer{}
+	textOutput := &bytes.Buffer{}
+	multi := NewMultiLogger(NewJSONLogger(jsonOutput), NewLevelFilter(NewTextLogger(textOutput), LevelError))
+
+	multi.LogFields(LevelInfo, "proxy", "", Fields{"session": "1"}, "Started")
+	multi.LogError("proxy", "Failed")
+
+	if entries := decodeLines(t, jsonOutput); len(entries) != 2 || entries[0]["fields"] == nil {
+		t.Errorf("the JSON logger did not get every message: %q", jsonOutput.String())
+	}
+	if text := textOutput.String(); strings.Contains(text, "Started") || !strings.Contains(text, "Failed") {
+		t.Errorf("the filtered logger got %q", text)
+	}
+}
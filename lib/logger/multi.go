@@ -0,0 +1,40 @@
+package logger
+
+// Passes every message on to several loggers, such as the console and a log file
+type MultiLogger struct {
+	loggers []Logger
+}
+
+func NewMultiLogger(loggers ...Logger) *MultiLogger {
+	return &MultiLogger{loggers: loggers}
+}
+
+func (multi *MultiLogger) LogFields(level Level, category string, user string, fields Fields, format string, data ...interface{}) {
+	for _, logger := range multi.loggers {
+		logWithFields(logger, level, category, user, fields, format, data)
+	}
+}
+
+func (multi *MultiLogger) LogInfo(category string, format string, data ...interface{}) {
+	multi.LogFields(LevelInfo, category, "", nil, format, data...)
+}
+
+func (multi *MultiLogger) LogWarning(category string, format string, data ...interface{}) {
+	multi.LogFields(LevelWarning, category, "", nil, format, data...)
+}
+
+func (multi *MultiLogger) LogError(category string, format string, data ...interface{}) {
+	multi.LogFields(LevelError, category, "", nil, format, data...)
+}
+
+func (multi *MultiLogger) LogUserInfo(category string, user string, format string, data ...interface{}) {
+	multi.LogFields(LevelInfo, category, user, nil, format, data...)
+}
+
+func (multi *MultiLogger) LogUserWarning(category string, user string, format string, data ...interface{}) {
+	multi.LogFields(LevelWarning, category, user, nil, format, data...)
+}
+
+func (multi *MultiLogger) LogUserError(category string, user string, format string, data ...interface{}) {
+	multi.LogFields(LevelError, category, user, nil, format, data...)
+}
@@ -0,0 +1,11 @@
+// +build windows plan9
+
+package logger
+
+import (
+	"fmt"
+)
+
+func NewSyslogLogger(tag string) (Logger, error) {
+	return nil, fmt.Errorf("Logging to syslog is not supported on this platform")
+}
@@ -0,0 +1,65 @@
+// +build !windows,!plan9
+
+package logger
+
+import (
+	"fmt"
+	"log/syslog"
+)
+
+// Writes to the local syslog daemon, which is also where journald picks up messages from
+type SyslogLogger struct {
+	writer *syslog.Writer
+}
+
+func NewSyslogLogger(tag string) (Logger, error) {
+	writer, err := syslog.New(syslog.LOG_DAEMON|syslog.LOG_INFO, tag)
+	if err != nil {
+		return nil, fmt.Errorf("Can not connect to syslog: %w", err)
+	}
+
+	return &SyslogLogger{writer: writer}, nil
+}
+
+func (logger *SyslogLogger) LogFields(level Level, category string, user string, fields Fields, format string, data ...interface{}) {
+	message := fmt.Sprintf(format, data...) + fields.String()
+
+	if len(user) > 0 {
+		message = fmt.Sprintf("[%s] [%s] %s", category, user, message)
+	} else {
+		message = fmt.Sprintf("[%s] %s", category, message)
+	}
+
+	switch level {
+	case LevelError:
+		logger.writer.Err(message)
+	case LevelWarning:
+		logger.writer.Warning(message)
+	default:
+		logger.writer.Info(message)
+	}
+}
+
+func (logger *SyslogLogger) LogInfo(category string, format string, data ...interface{}) {
+	logger.LogFields(LevelInfo, category, "", nil, format, data...)
+}
+
+func (logger *SyslogLogger) LogWarning(category string, format string, data ...interface{}) {
+	logger.LogFields(LevelWarning, category, "", nil, format, data...)
+}
+
+func (logger *SyslogLogger) LogError(category string, format string, data ...interface{}) {
+	logger.LogFields(LevelError, category, "", nil, format, data...)
+}
+
+func (logger *SyslogLogger) LogUserInfo(category string, user string, format string, data ...interface{}) {
+	logger.LogFields(LevelInfo, category, user, nil, format, data...)
+}
+
+func (logger *SyslogLogger) LogUserWarning(category string, user string, format string, data ...interface{}) {
+	logger.LogFields(LevelWarning, category, user, nil, format, data...)
+}
+
+func (logger *SyslogLogger) LogUserError(category string, user string, format string, data ...interface{}) {
+	logger.LogFields(LevelError, category, user, nil, format, data...)
+}
@@ -0,0 +1,87 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync/atomic"
+)
+
+type counter struct {
+	name  string
+	help  string
+	value atomic.Uint64
+}
+
+func (c *counter) Inc() {
+	c.value.Add(1)
+}
+
+func (c *counter) Add(n uint64) {
+	c.value.Add(n)
+}
+
+func (c *counter) Get() uint64 {
+	return c.value.Load()
+}
+
+// Counters that dbgpProxy keeps about the traffic it handles
+type Metrics struct {
+	EngineConnections  counter
+	ForwardedPackets   counter
+	ForwardedBytes     counter
+	DetachesNoIDE      counter
+	IDEConnectFailures counter
+}
+
+func NewMetrics() *Metrics {
+	metrics := &Metrics{}
+
+	metrics.EngineConnections.name = "dbgpproxy_engine_connections_total"
+	metrics.EngineConnections.help = "Debugging sessions started by engines"
+	metrics.ForwardedPackets.name = "dbgpproxy_forwarded_packets_total"
+	metrics.ForwardedPackets.help = "Packets forwarded from engines to IDEs"
+	metrics.ForwardedBytes.name = "dbgpproxy_forwarded_bytes_total"
+	metrics.ForwardedBytes.help = "Bytes forwarded from engines to IDEs"
+	metrics.DetachesNoIDE.name = "dbgpproxy_detaches_no_ide_total"
+	metrics.DetachesNoIDE.help = "Detaches sent to engines because no IDE was available"
+	metrics.IDEConnectFailures.name = "dbgpproxy_ide_connect_failures_total"
+	metrics.IDEConnectFailures.help = "Failed attempts to connect to a registered IDE"
+
+	return metrics
+}
+
+func (metrics *Metrics) counters() []*counter {
+	return []*counter{
+		&metrics.EngineConnections,
+		&metrics.ForwardedPackets,
+		&metrics.ForwardedBytes,
+		&metrics.DetachesNoIDE,
+		&metrics.IDEConnectFailures,
+	}
+}
+
+// A value that is only known when the metrics are written, such as the number of active sessions
+type Gauge struct {
+	Name  string
+	Help  string
+	Value int
+}
+
+// Writes all counters, and the extra gauges, in the Prometheus text exposition format
+func (metrics *Metrics) WritePrometheus(w io.Writer, gauges []Gauge) {
+	for _, c := range metrics.counters() {
+		fmt.Fprintf(w, "# HELP %s %s\n", c.name, c.help)
+		fmt.Fprintf(w, "# TYPE %s counter\n", c.name)
+		fmt.Fprintf(w, "%s %d\n", c.name, c.Get())
+	}
+
+	sorted := append([]Gauge{}, gauges...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Name < sorted[j].Name })
+
+	for _, g := range sorted {
+		fmt.Fprintf(w, "# HELP %s %s\n", g.Name, g.Help)
+		fmt.Fprintf(w, "# TYPE %s gauge\n", g.Name)
+		fmt.Fprintf(w, "%s %d\n", g.Name, g.Value)
+	}
+}
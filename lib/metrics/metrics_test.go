@@ -0,0 +1,32 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestWritePrometheus(t *testing.T) {
+	metrics := NewMetrics()
+	metrics.EngineConnections.Inc()
+	metrics.ForwardedBytes.Add(42)
+
+	var output strings.Builder
+	metrics.WritePrometheus(&output, []Gauge{
+		{Name: "b_gauge", Help: "The second gauge", Value: 2},
+		{Name: "a_gauge", Help: "The first gauge", Value: 1},
+	})
+
+	expected := []string{
+		"# HELP dbgpproxy_engine_connections_total Debugging sessions started by engines",
+		"# TYPE dbgpproxy_engine_connections_total counter",
+		"dbgpproxy_engine_connections_total 1",
+		"dbgpproxy_forwarded_bytes_total 42",
+		"# HELP a_gauge The first gauge\n# TYPE a_gauge gauge\na_gauge 1\n# HELP b_gauge The second gauge\n# TYPE b_gauge gauge\nb_gauge 2\n",
+	}
+
+	for _, line := range expected {
+		if !strings.Contains(output.String(), line) {
+			t.Errorf("The output does not contain %q:\n%s", line, output.String())
+		}
+	}
+}
@@ -0,0 +1,218 @@
+package pathmap
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+/*
+ * When PHP runs in a container or on another machine, the file:// URIs that
+ * the engine uses do not match the files on the local machine. A Mapping
+ * connects a directory on the engine's side, such as /var/www, with the
+ * local directory that has the same files, such as /home/derick/project.
+ */
+type Mapping struct {
+	Remote string // a path, as used in the engine's file:// URIs
+	Local  string // an absolute local path
+}
+
+type Mappings []Mapping
+
+func (mapping Mapping) String() string {
+	return fmt.Sprintf("%s=%s", mapping.Remote, mapping.Local)
+}
+
+// Parses a mapping in the form "remote=local", where remote is a path or a file:// URI
+func Parse(spec string) (Mapping, error) {
+	remote, local, found := strings.Cut(spec, "=")
+	if !found || strings.TrimSpace(remote) == "" || strings.TrimSpace(local) == "" {
+		return Mapping{}, fmt.Errorf("The path mapping '%s' is not in the form 'remote=local'", spec)
+	}
+
+	remote = strings.TrimSpace(remote)
+	if strings.Contains(remote, "://") {
+		remotePath, ok := uriPath(remote)
+		if !ok {
+			return Mapping{}, fmt.Errorf("The remote side of the path mapping '%s' is not a file:// URI", spec)
+		}
+		remote = remotePath
+	}
+
+	local, err := filepath.Abs(strings.TrimSpace(local))
+	if err != nil {
+		return Mapping{}, err
+	}
+
+	return Mapping{Remote: trimSlash(path.Clean(remote)), Local: trimSlash(local)}, nil
+}
+
+// Parses a list of mappings, separated by commas, as used in configuration files
+func ParseList(specs string) (Mappings, error) {
+	mappings := Mappings{}
+
+	for _, spec := range strings.Split(specs, ",") {
+		if strings.TrimSpace(spec) == "" {
+			continue
+		}
+
+		mapping, err := Parse(spec)
+		if err != nil {
+			return nil, err
+		}
+		mappings = append(mappings, mapping)
+	}
+
+	return mappings, nil
+}
+
+func trimSlash(name string) string {
+	if len(name) > 1 {
+		return strings.TrimRight(name, "/\\")
+	}
+
+	return name
+}
+
+func uriPath(uri string) (string, bool) {
+	parsed, err := url.Parse(uri)
+	if err != nil || parsed.Scheme != "file" {
+		return "", false
+	}
+
+	return parsed.Path, true
+}
+
+func fileURI(name string) string {
+	name = filepath.ToSlash(name)
+	if !strings.HasPrefix(name, "/") {
+		name = "/" + name // Windows paths, such as C:/www/index.php
+	}
+
+	return (&url.URL{Scheme: "file", Path: name}).String()
+}
+
+// Turns a local file name into a file:// URI, leaving URIs alone
+func FileURI(name string) string {
+	if strings.Contains(name, "://") {
+		return name
+	}
+
+	if absolute, err := filepath.Abs(name); err == nil {
+		name = absolute
+	}
+
+	return fileURI(name)
+}
+
+// Returns the local file name of a file:// URI. URIs of other types, such as 'dbgp://' for
+// code that was created with eval(), are returned unchanged, with 'false'.
+func URIPath(uri string) (string, bool) {
+	name, ok := uriPath(uri)
+	if !ok {
+		return uri, false
+	}
+
+	if len(name) > 2 && name[0] == '/' && name[2] == ':' {
+		name = name[1:] // Windows paths, such as /C:/www/index.php
+	}
+
+	return filepath.FromSlash(name), true
+}
+
+// Whether 'name' is 'prefix', or inside the directory 'prefix', with the rest of the path
+func cutDirectory(name string, prefix string, separator string) (string, bool) {
+	if name == prefix {
+		return "", true
+	}
+
+	if prefix == separator {
+		return strings.TrimPrefix(name, separator), strings.HasPrefix(name, separator)
+	}
+
+	rest, found := strings.CutPrefix(name, prefix+separator)
+
+	return rest, found
+}
+
+// Converts a local file name, or a file:// URI of a local file, to the URI that the engine uses
+func (mappings Mappings) ToRemote(name string) string {
+	if strings.Contains(name, "://") {
+		localPath, ok := URIPath(name)
+		if !ok {
+			return name
+		}
+		name = localPath
+	} else if absolute, err := filepath.Abs(name); err == nil {
+		name = absolute
+	}
+
+	// The most specific mapping wins
+	sorted := append(Mappings{}, mappings...)
+	sort.SliceStable(sorted, func(i, j int) bool { return len(sorted[i].Local) > len(sorted[j].Local) })
+
+	for _, mapping := range sorted {
+		if rest, found := cutDirectory(name, mapping.Local, string(filepath.Separator)); found {
+			return fileURI(path.Join(mapping.Remote, filepath.ToSlash(rest)))
+		}
+	}
+
+	return fileURI(name)
+}
+
+// Converts a URI that the engine uses to a local file name. URIs that no mapping applies
+// to are returned unchanged, with 'false'.
+func (mappings Mappings) ToLocal(uri string) (string, bool) {
+	remotePath, ok := uriPath(uri)
+	if !ok {
+		return uri, false
+	}
+
+	sorted := append(Mappings{}, mappings...)
+	sort.SliceStable(sorted, func(i, j int) bool { return len(sorted[i].Remote) > len(sorted[j].Remote) })
+
+	for _, mapping := range sorted {
+		if rest, found := cutDirectory(remotePath, mapping.Remote, "/"); found {
+			return filepath.Join(mapping.Local, filepath.FromSlash(rest)), true
+		}
+	}
+
+	return uri, false
+}
+
+/*
+ * Guesses a mapping from the file that the engine started with (the 'fileuri'
+ * of the <init> packet), by looking for the longest trailing part of its path
+ * that exists as a file in the local directory 'root'. For example, with the
+ * URI file:///var/www/html/public/index.php, and a checkout in
+ * /home/derick/project that has public/index.php, the guess is
+ * /var/www/html=/home/derick/project.
+ */
+func Guess(initFileURI string, root string) (Mapping, bool) {
+	remotePath, ok := uriPath(initFileURI)
+	if !ok {
+		return Mapping{}, false
+	}
+
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return Mapping{}, false
+	}
+
+	parts := strings.Split(strings.TrimPrefix(remotePath, "/"), "/")
+
+	for i := 0; i < len(parts); i++ {
+		candidate := filepath.Join(root, filepath.Join(parts[i:]...))
+
+		if info, err := os.Stat(candidate); err == nil && !info.IsDir() {
+			remote := "/" + path.Join(parts[:i]...)
+			return Mapping{Remote: remote, Local: trimSlash(root)}, true
+		}
+	}
+
+	return Mapping{}, false
+}
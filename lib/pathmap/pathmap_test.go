@@ -0,0 +1,141 @@
+package pathmap
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		spec     string
+		expected Mapping
+	}{
+		{"/var/www=/home/derick/project", Mapping{Remote: "/var/www", Local: "/home/derick/project"}},
+		{" /var/www/ = /home/derick/project/ ", Mapping{Remote: "/var/www", Local: "/home/derick/project"}},
+		{"file:///var/www=/home/derick/project", Mapping{Remote: "/var/www", Local: "/home/derick/project"}},
+		{"/=/srv/app", Mapping{Remote: "/", Local: "/srv/app"}},
+	}
+
+	for _, test := range tests {
+		mapping, err := Parse(test.spec)
+		if err != nil {
+			t.Errorf("'%s': %s", test.spec, err)
+			continue
+		}
+		if mapping != test.expected {
+			t.Errorf("'%s': got %+v, expected %+v", test.spec, mapping, test.expected)
+		}
+	}
+
+	for _, spec := range []string{"", "/var/www", "=/home/derick", "/var/www=", "dbgp://1=/home/derick"} {
+		if mapping, err := Parse(spec); err == nil {
+			t.Errorf("'%s' is not a valid mapping, but was parsed as %+v", spec, mapping)
+		}
+	}
+}
+
+func TestParseList(t *testing.T) {
+	mappings, err := ParseList("/var/www=/home/derick/project, ,/usr/share/php=/home/derick/php,")
+	if err != nil {
+		t.Fatalf("parsing failed: %s", err)
+	}
+
+	expected := Mappings{{Remote: "/var/www", Local: "/home/derick/project"}, {Remote: "/usr/share/php", Local: "/home/derick/php"}}
+	if len(mappings) != len(expected) || mappings[0] != expected[0] || mappings[1] != expected[1] {
+		t.Errorf("got %+v, expected %+v", mappings, expected)
+	}
+
+	if _, err := ParseList("/var/www=/home/derick/project,/usr/share/php"); err == nil {
+		t.Errorf("a list with an invalid mapping was accepted")
+	}
+}
+
+func TestToRemoteAndToLocal(t *testing.T) {
+	mappings := Mappings{
+		{Remote: "/var/www", Local: "/home/derick/project"},
+		{Remote: "/opt/vendor", Local: "/home/derick/project/vendor"},
+	}
+
+	tests := []struct {
+		local  string
+		remote string
+	}{
+		{"/home/derick/project/index.php", "file:///var/www/index.php"},
+		{"/home/derick/project", "file:///var/www"},
+		{"/home/derick/project/vendor/autoload.php", "file:///opt/vendor/autoload.php"}, // the longest prefix wins
+		{"/home/derick/projects/index.php", "file:///home/derick/projects/index.php"},
+	}
+
+	for _, test := range tests {
+		if remote := mappings.ToRemote(test.local); remote != test.remote {
+			t.Errorf("ToRemote('%s'): got '%s', expected '%s'", test.local, remote, test.remote)
+		}
+		if remote := mappings.ToRemote(FileURI(test.local)); remote != test.remote {
+			t.Errorf("ToRemote('%s'): got '%s', expected '%s'", FileURI(test.local), remote, test.remote)
+		}
+	}
+
+	for _, test := range tests[:3] {
+		if local, ok := mappings.ToLocal(test.remote); !ok || local != test.local {
+			t.Errorf("ToLocal('%s'): got '%s' (%v), expected '%s'", test.remote, local, ok, test.local)
+		}
+	}
+
+	// A mapping for /var/www does not apply to /var/wwwx, and other URIs are left alone
+	for _, uri := range []string{"file:///var/wwwx/index.php", "dbgp://1", "file:///var"} {
+		if local, ok := mappings.ToLocal(uri); ok || local != uri {
+			t.Errorf("ToLocal('%s'): got '%s' (%v), expected no mapping to apply", uri, local, ok)
+		}
+	}
+}
+
+func TestURIPath(t *testing.T) {
+	tests := []struct {
+		uri      string
+		expected string
+		ok       bool
+	}{
+		{"file:///var/www/index.php", filepath.FromSlash("/var/www/index.php"), true},
+		{"file:///var/www/my%20file.php", filepath.FromSlash("/var/www/my file.php"), true},
+		{"file:///C:/www/index.php", filepath.FromSlash("C:/www/index.php"), true},
+		{"dbgp://1", "dbgp://1", false},
+	}
+
+	for _, test := range tests {
+		name, ok := URIPath(test.uri)
+		if name != test.expected || ok != test.ok {
+			t.Errorf("'%s': got '%s' (%v), expected '%s' (%v)", test.uri, name, ok, test.expected, test.ok)
+		}
+	}
+}
+
+func TestGuess(t *testing.T) {
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "public"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"index.php", filepath.Join("public", "index.php")} {
+		if err := os.WriteFile(filepath.Join(root, name), []byte("<?php\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		uri      string
+		expected Mapping
+		ok       bool
+	}{
+		{"file:///var/www/html/public/index.php", Mapping{Remote: "/var/www/html", Local: root}, true},
+		{"file:///index.php", Mapping{Remote: "/", Local: root}, true},
+		{"file:///var/www/html/public/missing.php", Mapping{}, false},
+		{"dbgp://1", Mapping{}, false},
+	}
+
+	for _, test := range tests {
+		mapping, ok := Guess(test.uri, root)
+		if mapping != test.expected || ok != test.ok {
+			t.Errorf("'%s': got %+v (%v), expected %+v (%v)", test.uri, mapping, ok, test.expected, test.ok)
+		}
+	}
+}
@@ -0,0 +1,182 @@
+package protocol
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+/*
+ * A DBGp command line looks like:
+ *
+ *   command [-a value] [-b "value with spaces"] [-- base64-data]
+ *
+ * Values may be surrounded by double quotes, in which case a backslash
+ * escapes the next '"' or '\'. Everything after "--" is the data argument,
+ * which is transmitted base64 encoded.
+ */
+type CommandLine struct {
+	Name        string
+	Arguments   []string
+	Data        string
+	HasData     bool
+	DataEncoded bool
+
+	parsed bool // read with ParseCommandLine, so the data is as a user typed it
+}
+
+func NewCommandLine(name string, arguments ...string) *CommandLine {
+	return &CommandLine{Name: name, Arguments: arguments}
+}
+
+func ParseCommandLine(line string) (*CommandLine, error) {
+	var current strings.Builder
+
+	cl := &CommandLine{parsed: true}
+	tokens := []string{}
+
+	inToken := false
+	inQuotes := false
+	line = strings.TrimLeft(line, " ")
+
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+
+		if inQuotes {
+			switch c {
+			case '\\':
+				if i+1 < len(line) && (line[i+1] == '"' || line[i+1] == '\\') {
+					i++
+					current.WriteByte(line[i])
+				} else {
+					current.WriteByte(c)
+				}
+			case '"':
+				inQuotes = false
+			default:
+				current.WriteByte(c)
+			}
+			continue
+		}
+
+		switch c {
+		case ' ':
+			if inToken {
+				tokens = append(tokens, current.String())
+				current.Reset()
+				inToken = false
+			}
+		case '"':
+			inToken = true
+			inQuotes = true
+		case '-':
+			if !inToken && strings.HasPrefix(line[i:], "--") && (i+2 == len(line) || line[i+2] == ' ') {
+				cl.HasData = true
+				if i+2 < len(line) {
+					cl.Data = line[i+3:]
+				}
+				i = len(line)
+				continue
+			}
+			inToken = true
+			current.WriteByte(c)
+		default:
+			inToken = true
+			current.WriteByte(c)
+		}
+	}
+
+	if inQuotes {
+		return nil, fmt.Errorf("Unterminated quoted argument in '%s'", line)
+	}
+	if inToken {
+		tokens = append(tokens, current.String())
+	}
+
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("No command given")
+	}
+
+	cl.Name = tokens[0]
+	cl.Arguments = tokens[1:]
+
+	return cl, nil
+}
+
+// DBGp options are a dash followed by a single letter, so that "-1" is a value
+func IsOption(argument string) bool {
+	return len(argument) == 2 && argument[0] == '-' && unicode.IsLetter(rune(argument[1]))
+}
+
+// Returns the value of the option 'flag' (such as "-i"), and whether it was present
+func (cl *CommandLine) GetOption(flag string) (string, bool) {
+	for i, item := range cl.Arguments {
+		if item == flag {
+			if i+1 < len(cl.Arguments) {
+				return cl.Arguments[i+1], true
+			}
+			return "", true
+		}
+	}
+
+	return "", false
+}
+
+func (cl *CommandLine) HasOption(flag string) bool {
+	_, ok := cl.GetOption(flag)
+
+	return ok
+}
+
+// Replaces the value of an existing option, or prepends the option if it is not present yet
+func (cl *CommandLine) SetOption(flag string, value string) {
+	for i, item := range cl.Arguments {
+		if item == flag && i+1 < len(cl.Arguments) {
+			cl.Arguments[i+1] = value
+			return
+		}
+	}
+
+	cl.Arguments = append([]string{flag, value}, cl.Arguments...)
+}
+
+func (cl *CommandLine) SetData(data string) {
+	cl.Data = data
+	cl.HasData = true
+	cl.DataEncoded = false
+}
+
+func quoteArgument(argument string) string {
+	// A bare "--" would start the data argument
+	if argument != "" && argument != "--" && !strings.ContainsAny(argument, " \"") {
+		return argument
+	}
+
+	argument = strings.ReplaceAll(argument, "\\", "\\\\")
+	argument = strings.ReplaceAll(argument, "\"", "\\\"")
+
+	return "\"" + argument + "\""
+}
+
+// Serialises the command line as it should be sent over the wire
+func (cl *CommandLine) String() string {
+	parts := []string{cl.Name}
+
+	for _, argument := range cl.Arguments {
+		parts = append(parts, quoteArgument(argument))
+	}
+
+	if cl.HasData {
+		data := cl.Data
+		if !cl.DataEncoded {
+			data = base64.StdEncoding.EncodeToString([]byte(data))
+		}
+		parts = append(parts, "--")
+		if data != "" {
+			parts = append(parts, data)
+		}
+	}
+
+	return strings.Join(parts, " ")
+}
@@ -0,0 +1,132 @@
+package protocol
+
+import (
+	"encoding/base64"
+	"reflect"
+	"testing"
+)
+
+func TestParseCommandLine(t *testing.T) {
+	tests := []struct {
+		line      string
+		name      string
+		arguments []string
+		hasData   bool
+		data      string
+	}{
+		{"run", "run", []string{}, false, ""},
+		{"  run -i 1", "run", []string{"-i", "1"}, false, ""},
+		{"source -f file:///tmp/a.php -b 5", "source", []string{"-f", "file:///tmp/a.php", "-b", "5"}, false, ""},
+		{`property_get -n "$a b"`, "property_get", []string{"-n", "$a b"}, false, ""},
+		{`property_get -n "$a[\"key\"]"`, "property_get", []string{"-n", `$a["key"]`}, false, ""},
+		{`property_get -n "a\\b"`, "property_get", []string{"-n", `a\b`}, false, ""},
+		{`property_get -n "a\nb"`, "property_get", []string{"-n", `a\nb`}, false, ""},
+		{`property_get -n a\b`, "property_get", []string{"-n", `a\b`}, false, ""},
+		{`property_get -n ""`, "property_get", []string{"-n", ""}, false, ""},
+		{"eval -i 1 -- JHg=", "eval", []string{"-i", "1"}, true, "JHg="},
+		{"eval -i 1 --", "eval", []string{"-i", "1"}, true, ""},
+		{"eval -i 1 -- ", "eval", []string{"-i", "1"}, true, ""},
+		{"eval -- $a + $b", "eval", []string{}, true, "$a + $b"},
+		{"eval -- a -- b", "eval", []string{}, true, "a -- b"},
+		{"breakpoint_set -t line --x", "breakpoint_set", []string{"-t", "line", "--x"}, false, ""},
+		{`eval "--"`, "eval", []string{"--"}, false, ""},
+	}
+
+	for _, test := range tests {
+		cl, err := ParseCommandLine(test.line)
+		if err != nil {
+			t.Errorf("ParseCommandLine(%q): unexpected error: %s", test.line, err)
+			continue
+		}
+
+		if cl.Name != test.name || !reflect.DeepEqual(cl.Arguments, test.arguments) || cl.HasData != test.hasData || cl.Data != test.data {
+			t.Errorf("ParseCommandLine(%q) = %q %q %v %q, expected %q %q %v %q",
+				test.line, cl.Name, cl.Arguments, cl.HasData, cl.Data,
+				test.name, test.arguments, test.hasData, test.data)
+		}
+	}
+}
+
+func TestParseCommandLineErrors(t *testing.T) {
+	for _, line := range []string{"", "   ", `property_get -n "$a`, `property_get -n "$a\"`} {
+		if cl, err := ParseCommandLine(line); err == nil {
+			t.Errorf("ParseCommandLine(%q) = %q, expected an error", line, cl.String())
+		}
+	}
+}
+
+func TestQuoteArgument(t *testing.T) {
+	tests := []struct {
+		argument string
+		expected string
+	}{
+		{"plain", "plain"},
+		{"", `""`},
+		{"with space", `"with space"`},
+		{`with"quote`, `"with\"quote"`},
+		{`back\slash`, `back\slash`},
+		{`back\slash and space`, `"back\\slash and space"`},
+		{"--", `"--"`},
+	}
+
+	for _, test := range tests {
+		if actual := quoteArgument(test.argument); actual != test.expected {
+			t.Errorf("quoteArgument(%q) = %s, expected %s", test.argument, actual, test.expected)
+		}
+	}
+}
+
+func TestCommandLineRoundTrip(t *testing.T) {
+	tests := []*CommandLine{
+		NewCommandLine("run"),
+		NewCommandLine("property_get", "-n", "$a b", "-d", "0"),
+		NewCommandLine("property_get", "-n", `$a["key with \"quotes\""]`),
+		NewCommandLine("property_get", "-n", `C:\www\index.php`),
+		NewCommandLine("property_get", "-n", `C:\Program Files\x\`),
+		NewCommandLine("property_get", "-n", ""),
+		NewCommandLine("eval", "--"),
+		{Name: "eval", Arguments: []string{"-i", "1"}, HasData: true},
+		{Name: "eval", Arguments: []string{"-i", "1"}, HasData: true, Data: "$a . ' ' . $b"},
+		{Name: "eval", Arguments: []string{"-i", "1"}, HasData: true, Data: "already encoded", DataEncoded: true},
+	}
+
+	for _, original := range tests {
+		line := original.String()
+
+		parsed, err := ParseCommandLine(line)
+		if err != nil {
+			t.Errorf("ParseCommandLine(%q): unexpected error: %s", line, err)
+			continue
+		}
+
+		// What comes after "--" on the wire is always encoded already
+		parsed.DataEncoded = true
+
+		if parsed.String() != line {
+			t.Errorf("%q does not survive a round trip, it came back as %q", line, parsed.String())
+		}
+		if parsed.Name != original.Name || len(parsed.Arguments) != len(original.Arguments) || parsed.HasData != original.HasData {
+			t.Errorf("%q parsed as %q %q %v", line, parsed.Name, parsed.Arguments, parsed.HasData)
+			continue
+		}
+		if original.HasData && !original.DataEncoded {
+			if decoded, _ := base64.StdEncoding.DecodeString(parsed.Data); string(decoded) != original.Data {
+				t.Errorf("%q: the data decodes to %q, expected %q", line, decoded, original.Data)
+			}
+		}
+		for i := range original.Arguments {
+			if parsed.Arguments[i] != original.Arguments[i] {
+				t.Errorf("%q: argument %d is %q, expected %q", line, i, parsed.Arguments[i], original.Arguments[i])
+			}
+		}
+	}
+}
+
+func TestCommandLineStringEncodesData(t *testing.T) {
+	cl := NewCommandLine("eval", "-i", "1")
+	cl.SetData("$a + $b")
+
+	if actual, expected := cl.String(), "eval -i 1 -- JGEgKyAkYg=="; actual != expected {
+		t.Errorf("String() = %q, expected %q", actual, expected)
+	}
+}
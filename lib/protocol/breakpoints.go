@@ -0,0 +1,151 @@
+package protocol
+
+import (
+	"strconv"
+)
+
+// Options that every breakpoint type supports
+type BreakpointOptions struct {
+	Disabled     bool
+	Temporary    bool
+	HitValue     int
+	HitCondition string // ">=", "==", or "%"
+}
+
+func (options BreakpointOptions) apply(cl *CommandLine) {
+	if options.Disabled {
+		cl.Arguments = append(cl.Arguments, "-s", "disabled")
+	}
+	if options.Temporary {
+		cl.Arguments = append(cl.Arguments, "-r", "1")
+	}
+	if options.HitValue > 0 {
+		cl.Arguments = append(cl.Arguments, "-h", strconv.Itoa(options.HitValue))
+	}
+	if options.HitCondition != "" {
+		cl.Arguments = append(cl.Arguments, "-o", options.HitCondition)
+	}
+}
+
+type BreakpointSpec interface {
+	commandLine() *CommandLine
+}
+
+// The 'breakpoint_set' command for a breakpoint, for sending it without a Session
+func BreakpointSetCommand(bp BreakpointSpec) *CommandLine {
+	return bp.commandLine()
+}
+
+type LineBreakpoint struct {
+	BreakpointOptions
+	Filename string
+	LineNo   int
+}
+
+func (bp LineBreakpoint) commandLine() *CommandLine {
+	cl := NewCommandLine("breakpoint_set", "-t", "line", "-f", bp.Filename, "-n", strconv.Itoa(bp.LineNo))
+	bp.apply(cl)
+
+	return cl
+}
+
+type ConditionalBreakpoint struct {
+	BreakpointOptions
+	Filename   string
+	LineNo     int
+	Expression string
+}
+
+func (bp ConditionalBreakpoint) commandLine() *CommandLine {
+	cl := NewCommandLine("breakpoint_set", "-t", "conditional")
+	if bp.Filename != "" {
+		cl.Arguments = append(cl.Arguments, "-f", bp.Filename)
+	}
+	if bp.LineNo > 0 {
+		cl.Arguments = append(cl.Arguments, "-n", strconv.Itoa(bp.LineNo))
+	}
+	bp.apply(cl)
+	cl.SetData(bp.Expression)
+
+	return cl
+}
+
+type CallBreakpoint struct {
+	BreakpointOptions
+	Classname string
+	Function  string
+}
+
+func (bp CallBreakpoint) commandLine() *CommandLine {
+	cl := NewCommandLine("breakpoint_set", "-t", "call", "-m", bp.Function)
+	if bp.Classname != "" {
+		cl.Arguments = append(cl.Arguments, "-a", bp.Classname)
+	}
+	bp.apply(cl)
+
+	return cl
+}
+
+type ReturnBreakpoint struct {
+	BreakpointOptions
+	Classname string
+	Function  string
+}
+
+func (bp ReturnBreakpoint) commandLine() *CommandLine {
+	cl := NewCommandLine("breakpoint_set", "-t", "return", "-m", bp.Function)
+	if bp.Classname != "" {
+		cl.Arguments = append(cl.Arguments, "-a", bp.Classname)
+	}
+	bp.apply(cl)
+
+	return cl
+}
+
+type ExceptionBreakpoint struct {
+	BreakpointOptions
+	Exception string
+}
+
+func (bp ExceptionBreakpoint) commandLine() *CommandLine {
+	cl := NewCommandLine("breakpoint_set", "-t", "exception", "-x", bp.Exception)
+	bp.apply(cl)
+
+	return cl
+}
+
+type WatchBreakpoint struct {
+	BreakpointOptions
+	Expression string
+}
+
+func (bp WatchBreakpoint) commandLine() *CommandLine {
+	cl := NewCommandLine("breakpoint_set", "-t", "watch")
+	bp.apply(cl)
+	cl.SetData(bp.Expression)
+
+	return cl
+}
+
+// The fields of an existing breakpoint that 'breakpoint_update' can change. Empty values are left alone.
+type BreakpointChanges struct {
+	State        string // "enabled" or "disabled"
+	LineNo       int
+	HitValue     int
+	HitCondition string
+}
+
+func (changes BreakpointChanges) apply(cl *CommandLine) {
+	if changes.State != "" {
+		cl.Arguments = append(cl.Arguments, "-s", changes.State)
+	}
+	if changes.LineNo > 0 {
+		cl.Arguments = append(cl.Arguments, "-n", strconv.Itoa(changes.LineNo))
+	}
+	if changes.HitValue > 0 {
+		cl.Arguments = append(cl.Arguments, "-h", strconv.Itoa(changes.HitValue))
+	}
+	if changes.HitCondition != "" {
+		cl.Arguments = append(cl.Arguments, "-o", changes.HitCondition)
+	}
+}
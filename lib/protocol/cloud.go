@@ -3,11 +3,47 @@ package protocol
 import (
 	"fmt"
 	"github.com/derickr/dbgp-tools/lib/connections"
+	"github.com/derickr/dbgp-tools/lib/dbgpxml"
 	"github.com/derickr/dbgp-tools/lib/logger"
 	. "github.com/logrusorgru/aurora" // WTFPL
 	"io"
+	"net"
+	"time"
 )
 
+// Registers with Xdebug Cloud on a fresh connection, and returns a Session for the debugging
+// sessions that then arrive on it, one after the other
+func NewCloudSession(conn net.Conn, cloudUser string, logger logger.Logger) (*Session, error) {
+	client := NewDbgpClient(conn, logger)
+
+	if err := client.SendCommandLine(NewCommandLine("cloudinit", "-u", cloudUser)); err != nil {
+		return nil, fmt.Errorf("Could not send 'cloudinit' command: %w", err)
+	}
+
+	response, err, timedOut := client.ReadResponseWithTimeout(10 * time.Second)
+	if timedOut {
+		return nil, fmt.Errorf("Xdebug Cloud did not respond to 'cloudinit'")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Could not read the response to 'cloudinit': %w", err)
+	}
+
+	packet, err := client.ParseXML(response)
+	if err != nil {
+		return nil, err
+	}
+
+	init, ok := packet.(dbgpxml.CloudInit)
+	if !ok {
+		return nil, fmt.Errorf("Expected a response to 'cloudinit', but received %T", packet)
+	}
+	if !init.IsSuccess() {
+		return nil, fmt.Errorf("Xdebug Cloud refused 'cloudinit': %s", init.GetErrorMessage())
+	}
+
+	return newSession(client, logger), nil
+}
+
 func UnregisterCloudClient(cloudDomain string, cloudPort string, cloudUser string, output io.Writer, logger logger.Logger) {
 	conn, err := connections.ConnectToCloud(cloudDomain, cloudPort, cloudUser, logger)
 	if err != nil {
@@ -16,7 +52,7 @@ func UnregisterCloudClient(cloudDomain string, cloudPort string, cloudUser strin
 	}
 	defer conn.Close()
 
-	command := "cloudstop -u " + cloudUser
+	command := NewCommandLine("cloudstop", "-u", cloudUser).String()
 
 	_ = RunAndQuit(conn, command, output, logger, false)
 }
@@ -0,0 +1,252 @@
+package protocol
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strconv"
+
+	"github.com/derickr/dbgp-tools/lib/dbgpxml"
+)
+
+// An error returned by the debugging engine, with the DBGp error code
+type CommandError struct {
+	Command string
+	Code    int
+	Message string
+}
+
+func (err *CommandError) Error() string {
+	return fmt.Sprintf("%s failed with error %d: %s", err.Command, err.Code, err.Message)
+}
+
+func (session *Session) execute(cl *CommandLine) (dbgpxml.Response, error) {
+	response, err := session.ExecuteCommandLine(cl)
+	if err != nil {
+		return response, err
+	}
+
+	if response.Error != nil && response.Error.Code != 0 {
+		return response, &CommandError{Command: cl.Name, Code: response.Error.Code, Message: response.Error.Message.Text}
+	}
+
+	return response, nil
+}
+
+func (session *Session) Status() (dbgpxml.Response, error) {
+	return session.execute(NewCommandLine("status"))
+}
+
+func (session *Session) Run() (dbgpxml.Response, error) {
+	return session.execute(NewCommandLine("run"))
+}
+
+func (session *Session) StepInto() (dbgpxml.Response, error) {
+	return session.execute(NewCommandLine("step_into"))
+}
+
+func (session *Session) StepOver() (dbgpxml.Response, error) {
+	return session.execute(NewCommandLine("step_over"))
+}
+
+func (session *Session) StepOut() (dbgpxml.Response, error) {
+	return session.execute(NewCommandLine("step_out"))
+}
+
+func (session *Session) Stop() (dbgpxml.Response, error) {
+	return session.execute(NewCommandLine("stop"))
+}
+
+func (session *Session) Detach() (dbgpxml.Response, error) {
+	return session.execute(NewCommandLine("detach"))
+}
+
+func (session *Session) FeatureGet(name string) (string, bool, error) {
+	response, err := session.execute(NewCommandLine("feature_get", "-n", name))
+	if err != nil {
+		return "", false, err
+	}
+
+	return response.Value, response.Supported == 1, nil
+}
+
+func (session *Session) FeatureSet(name string, value string) error {
+	response, err := session.execute(NewCommandLine("feature_set", "-n", name, "-v", value))
+	if err != nil {
+		return err
+	}
+	if response.Success != 1 {
+		return &CommandError{Command: "feature_set", Message: fmt.Sprintf("Could not set feature '%s' to '%s'", name, value)}
+	}
+
+	return nil
+}
+
+// Sets how the engine handles stdout: 0 (disable), 1 (copy), or 2 (redirect)
+func (session *Session) Stdout(mode int) error {
+	_, err := session.execute(NewCommandLine("stdout", "-c", strconv.Itoa(mode)))
+
+	return err
+}
+
+func (session *Session) Stderr(mode int) error {
+	_, err := session.execute(NewCommandLine("stderr", "-c", strconv.Itoa(mode)))
+
+	return err
+}
+
+func (session *Session) StackDepth() (int, error) {
+	response, err := session.execute(NewCommandLine("stack_depth"))
+
+	return response.Depth, err
+}
+
+// Returns the frame at 'depth', or all frames when depth is negative
+func (session *Session) StackGet(depth int) ([]dbgpxml.Stack, error) {
+	cl := NewCommandLine("stack_get")
+	if depth >= 0 {
+		cl.Arguments = append(cl.Arguments, "-d", strconv.Itoa(depth))
+	}
+
+	response, err := session.execute(cl)
+
+	return response.Stack, err
+}
+
+func (session *Session) ContextNames(depth int) ([]dbgpxml.Context, error) {
+	response, err := session.execute(NewCommandLine("context_names", "-d", strconv.Itoa(depth)))
+
+	return response.Contexts, err
+}
+
+func (session *Session) ContextGet(context int, depth int) ([]dbgpxml.Property, error) {
+	response, err := session.execute(NewCommandLine("context_get", "-c", strconv.Itoa(context), "-d", strconv.Itoa(depth)))
+
+	return response.Property, err
+}
+
+func (session *Session) TypemapGet() ([]dbgpxml.Typemap, error) {
+	response, err := session.execute(NewCommandLine("typemap_get"))
+
+	return response.Typemap, err
+}
+
+func onlyProperty(command string, response dbgpxml.Response) (dbgpxml.Property, error) {
+	if len(response.Property) == 0 {
+		return dbgpxml.Property{}, &CommandError{Command: command, Message: "The response did not contain a property"}
+	}
+
+	return response.Property[0], nil
+}
+
+// Fetches a property from the local context of the top most stack frame
+func (session *Session) PropertyGet(name string, page int) (dbgpxml.Property, error) {
+	return session.PropertyGetInContext(name, 0, 0, page)
+}
+
+func (session *Session) PropertyGetInContext(name string, context int, depth int, page int) (dbgpxml.Property, error) {
+	cl := NewCommandLine("property_get", "-n", name, "-c", strconv.Itoa(context), "-d", strconv.Itoa(depth))
+	if page > 0 {
+		cl.Arguments = append(cl.Arguments, "-p", strconv.Itoa(page))
+	}
+
+	response, err := session.execute(cl)
+	if err != nil {
+		return dbgpxml.Property{}, err
+	}
+
+	return onlyProperty("property_get", response)
+}
+
+func (session *Session) PropertySet(name string, value string) error {
+	return session.PropertySetInContext(name, 0, 0, value)
+}
+
+func (session *Session) PropertySetInContext(name string, context int, depth int, value string) error {
+	cl := NewCommandLine("property_set", "-n", name, "-c", strconv.Itoa(context), "-d", strconv.Itoa(depth))
+	cl.SetData(value)
+
+	response, err := session.execute(cl)
+	if err != nil {
+		return err
+	}
+	if response.Success != 1 {
+		return &CommandError{Command: "property_set", Message: fmt.Sprintf("Could not set '%s'", name)}
+	}
+
+	return nil
+}
+
+func (session *Session) Eval(expression string) (dbgpxml.Property, error) {
+	cl := NewCommandLine("eval")
+	cl.SetData(expression)
+
+	response, err := session.execute(cl)
+	if err != nil {
+		return dbgpxml.Property{}, err
+	}
+
+	return onlyProperty("eval", response)
+}
+
+// Returns lines 'begin' to 'end' of the file. Zero values select the start or end of the file.
+func (session *Session) Source(fileURI string, begin int, end int) (string, error) {
+	cl := NewCommandLine("source", "-f", fileURI)
+	if begin > 0 {
+		cl.Arguments = append(cl.Arguments, "-b", strconv.Itoa(begin))
+	}
+	if end > 0 {
+		cl.Arguments = append(cl.Arguments, "-e", strconv.Itoa(end))
+	}
+
+	response, err := session.execute(cl)
+	if err != nil {
+		return "", err
+	}
+
+	if response.Encoding == "base64" {
+		value, err := base64.StdEncoding.DecodeString(response.Value)
+		return string(value), err
+	}
+
+	return response.Value, nil
+}
+
+// Sets a breakpoint, and returns the ID the engine assigned to it
+func (session *Session) BreakpointSet(bp BreakpointSpec) (string, error) {
+	response, err := session.execute(bp.commandLine())
+
+	return response.ID, err
+}
+
+func (session *Session) BreakpointGet(id string) (dbgpxml.Breakpoint, error) {
+	response, err := session.execute(NewCommandLine("breakpoint_get", "-d", id))
+	if err != nil {
+		return dbgpxml.Breakpoint{}, err
+	}
+	if len(response.Breakpoints) == 0 {
+		return dbgpxml.Breakpoint{}, &CommandError{Command: "breakpoint_get", Message: fmt.Sprintf("No breakpoint with ID '%s'", id)}
+	}
+
+	return response.Breakpoints[0], nil
+}
+
+func (session *Session) BreakpointUpdate(id string, changes BreakpointChanges) error {
+	cl := NewCommandLine("breakpoint_update", "-d", id)
+	changes.apply(cl)
+
+	_, err := session.execute(cl)
+
+	return err
+}
+
+func (session *Session) BreakpointRemove(id string) error {
+	_, err := session.execute(NewCommandLine("breakpoint_remove", "-d", id))
+
+	return err
+}
+
+func (session *Session) BreakpointList() ([]dbgpxml.Breakpoint, error) {
+	response, err := session.execute(NewCommandLine("breakpoint_list"))
+
+	return response.Breakpoints, err
+}
@@ -2,7 +2,9 @@ package protocol
 
 import (
 	"bufio"
+	"bytes"
 	"encoding/xml"
+	"errors"
 	"fmt"
 	"io"
 	"net"
@@ -12,6 +14,7 @@ import (
 
 	"github.com/derickr/dbgp-tools/lib/dbgpxml"
 	"github.com/derickr/dbgp-tools/lib/logger"
+	"github.com/derickr/dbgp-tools/lib/recorder"
 	. "github.com/logrusorgru/aurora" // WTFPL
 	"golang.org/x/net/html/charset"
 )
@@ -34,8 +37,16 @@ type dbgpClient struct {
 	lastSourceBegin int
 	abortRequested  bool
 	commandsToRun   []string
+	rawData         bool
+	partialLength   []byte
+	recorder        *recorder.Recorder
+	deadline        time.Time
 }
 
+// With ReadResponseWithTimeout, how long reading the rest of a packet may take once its length
+// has arrived
+var packetBodyTimeout = 10 * time.Second
+
 func NewDbgpClient(c net.Conn, logger logger.Logger) *dbgpClient {
 	var tmp dbgpClient
 
@@ -46,80 +57,23 @@ func NewDbgpClient(c net.Conn, logger logger.Logger) *dbgpClient {
 	tmp.counter = 1
 	tmp.lastSourceBegin = 1
 	tmp.abortRequested = false
+	tmp.rawData = false
 
 	return &tmp
 }
 
-func (dbgp *dbgpClient) ParseInitXML(rawXmlData string) (dbgpxml.Init, error) {
-	init := dbgpxml.Init{}
-
-	reader := strings.NewReader(rawXmlData)
-
-	decoder := xml.NewDecoder(reader)
-	decoder.CharsetReader = charset.NewReaderLabel
-
-	err := decoder.Decode(&init)
-
-	if err != nil {
-		return init, err
-	}
-
-	return init, nil
-}
-
-func (dbgp *dbgpClient) parseProxyInitXML(rawXmlData string) (dbgpxml.ProxyInit, error) {
-	init := dbgpxml.ProxyInit{}
-
-	reader := strings.NewReader(rawXmlData)
-
-	decoder := xml.NewDecoder(reader)
-	decoder.CharsetReader = charset.NewReaderLabel
-
-	err := decoder.Decode(&init)
-
-	if err != nil {
-		return init, err
-	}
-
-	return init, nil
+// Records all packets that are sent and received from now on
+func (dbgp *dbgpClient) SetRecorder(recorder *recorder.Recorder) {
+	dbgp.recorder = recorder
 }
 
-func (dbgp *dbgpClient) parseProxyStopXML(rawXmlData string) (dbgpxml.ProxyStop, error) {
-	init := dbgpxml.ProxyStop{}
-
-	reader := strings.NewReader(rawXmlData)
-
-	decoder := xml.NewDecoder(reader)
-	decoder.CharsetReader = charset.NewReaderLabel
-
-	err := decoder.Decode(&init)
-
-	if err != nil {
-		return init, err
-	}
-
-	return init, nil
+// When set, data after "--" is sent as-is, as the user has already base64 encoded it
+func (dbgp *dbgpClient) SetRawData(rawData bool) {
+	dbgp.rawData = rawData
 }
 
-func (dbgp *dbgpClient) parseCloudInitXML(rawXmlData string) (dbgpxml.CloudInit, error) {
-	init := dbgpxml.CloudInit{}
-
-	reader := strings.NewReader(rawXmlData)
-
-	decoder := xml.NewDecoder(reader)
-	decoder.CharsetReader = charset.NewReaderLabel
-
-	err := decoder.Decode(&init)
-
-	if err != nil {
-		return init, err
-	}
-
-	return init, nil
-}
-
-func (dbgp *dbgpClient) parseCloudStopXML(rawXmlData string) (dbgpxml.CloudStop, error) {
-	init := dbgpxml.CloudStop{}
+func (dbgp *dbgpClient) ParseInitXML(rawXmlData string) (dbgpxml.Init, error) {
+	init := dbgpxml.Init{}
 
 	reader := strings.NewReader(rawXmlData)
 
@@ -135,23 +89,6 @@ func (dbgp *dbgpClient) parseCloudStopXML(rawXmlData string) (dbgpxml.CloudStop,
 	return init, nil
 }
 
-func (dbgp *dbgpClient) parseNotifyXML(rawXmlData string) (dbgpxml.Notify, error) {
-	notify := dbgpxml.Notify{}
-
-	reader := strings.NewReader(rawXmlData)
-
-	decoder := xml.NewDecoder(reader)
-	decoder.CharsetReader = charset.NewReaderLabel
-
-	err := decoder.Decode(&notify)
-
-	if err != nil {
-		return notify, err
-	}
-
-	return notify, nil
-}
-
 func (dbgp *dbgpClient) SignalAbort() {
 	dbgp.abortRequested = true
 }
@@ -160,82 +97,78 @@ func (dbgp *dbgpClient) HasAbortBeenSignalled() bool {
 	return dbgp.abortRequested
 }
 
-func (dbgp *dbgpClient) parseResponseXML(rawXmlData string) (dbgpxml.Response, error) {
-	response := dbgpxml.Response{}
-
-	reader := strings.NewReader(rawXmlData)
-
-	decoder := xml.NewDecoder(reader)
-	decoder.CharsetReader = charset.NewReaderLabel
-
-	err := decoder.Decode(&response)
-
-	response.LastSourceBegin = dbgp.lastSourceBegin
-	if err != nil {
-		return response, err
-	}
-
-	return response, nil
+// Returned when a packet does not follow the "length NUL data NUL" format
+type FramingError struct {
+	Reason string
+	Data   []byte
 }
 
-func (dbgp *dbgpClient) parseCtrlResponseXML(rawXmlData string) (dbgpxml.CtrlResponse, error) {
-	response := dbgpxml.CtrlResponse{}
-
-	reader := strings.NewReader(rawXmlData)
-
-	decoder := xml.NewDecoder(reader)
-	decoder.CharsetReader = charset.NewReaderLabel
-
-	err := decoder.Decode(&response)
-
-	if err != nil {
-		return response, err
+func (err *FramingError) Error() string {
+	data := err.Data
+	if len(data) > 64 {
+		data = data[:64]
 	}
 
-	return response, nil
-}
-
-func (dbgp *dbgpClient) parseStreamXML(rawXmlData string) (dbgpxml.Stream, error) {
-	stream := dbgpxml.Stream{}
-
-	reader := strings.NewReader(rawXmlData)
-
-	decoder := xml.NewDecoder(reader)
-	decoder.CharsetReader = charset.NewReaderLabel
-
-	err := decoder.Decode(&stream)
-
-	if err != nil {
-		return stream, err
-	}
-
-	return stream, nil
+	return fmt.Sprintf("Framing error: %s (%d bytes: %q)", err.Reason, len(err.Data), data)
 }
 
 func (dbgp *dbgpClient) readResponse() (string, error, bool) {
 	/* Read length */
-	_, err := dbgp.reader.ReadBytes('\000')
+	lengthBytes, err := dbgp.reader.ReadBytes('\000')
+	dbgp.partialLength = append(dbgp.partialLength, lengthBytes...)
 
 	if err != nil {
 		if err, ok := err.(net.Error); ok && err.Timeout() {
 			return "", err, true
 		}
 
+		dbgp.partialLength = nil
 		return "", fmt.Errorf("Error reading length: %w", err), false
 	}
 
-	/* Read data */
-	data, err := dbgp.reader.ReadBytes('\000')
+	lengthBytes = dbgp.partialLength
+	dbgp.partialLength = nil
+
+	length, err := strconv.Atoi(string(lengthBytes[:len(lengthBytes)-1]))
+	if err != nil || length < 0 {
+		return "", &FramingError{Reason: "invalid length", Data: lengthBytes}, false
+	}
+
+	/* The data follows the length immediately, so give it some more time, but not forever */
+	if !dbgp.deadline.IsZero() {
+		dbgp.connection.SetReadDeadline(time.Now().Add(packetBodyTimeout))
+	}
+
+	/* Read data, and the trailing NUL */
+	data := make([]byte, length+1)
+	n, err := io.ReadFull(dbgp.reader, data)
 
 	if err != nil {
+		if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+			return "", &FramingError{Reason: fmt.Sprintf("timed out after %d of %d bytes", n, length+1), Data: data[:n]}, false
+		}
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			return "", &FramingError{Reason: fmt.Sprintf("truncated packet, expected %d bytes", length), Data: data[:n]}, false
+		}
 		return "", fmt.Errorf("Error reading data: %w", err), false
 	}
 
-	return string(data), nil, false
+	if data[length] != '\000' {
+		return "", &FramingError{Reason: "packet is not terminated by a NUL byte", Data: data}, false
+	}
+
+	if bytes.IndexByte(data[:length], '\000') != -1 {
+		return "", &FramingError{Reason: "packet contains a NUL byte", Data: data}, false
+	}
+
+	dbgp.recorder.RecordResponse(string(data[:length]))
+
+	return string(data[:length]), nil, false
 }
 
 func (dbgp *dbgpClient) ReadResponse() (string, error) {
-	dbgp.connection.SetReadDeadline(time.Time{})
+	dbgp.deadline = time.Time{}
+	dbgp.connection.SetReadDeadline(dbgp.deadline)
 
 	response, err, _ := dbgp.readResponse()
 
@@ -243,62 +176,81 @@ func (dbgp *dbgpClient) ReadResponse() (string, error) {
 }
 
 func (dbgp *dbgpClient) ReadResponseWithTimeout(d time.Duration) (string, error, bool) {
-	dbgp.connection.SetReadDeadline(time.Now().Add(d))
+	dbgp.deadline = time.Now().Add(d)
+	dbgp.connection.SetReadDeadline(dbgp.deadline)
 
 	return dbgp.readResponse()
 }
 
-func (dbgp *dbgpClient) injectIIfNeeded(parts []string) []string {
-	for _, item := range parts {
-		if item == "-i" {
-			return parts
-		}
+func (dbgp *dbgpClient) injectIIfNeeded(cl *CommandLine) *CommandLine {
+	if cl.HasOption("-i") {
+		return cl
 	}
 
-	var newParts []string
-	newParts = append(newParts, parts[0])
-	newParts = append(newParts, "-i", fmt.Sprintf("%d", dbgp.counter))
-	newParts = append(newParts, parts[1:]...)
+	cl.SetOption("-i", fmt.Sprintf("%d", dbgp.counter))
 
 	dbgp.counter++
 
-	return newParts
+	return cl
 }
 
-func (dbgp *dbgpClient) storeSourceBeginIfPresent(parts []string) []string {
-	s_found := false
-
+func (dbgp *dbgpClient) storeSourceBeginIfPresent(cl *CommandLine) *CommandLine {
 	dbgp.lastSourceBegin = 1
 
-	for _, item := range parts {
-		if s_found {
-			value, err := strconv.Atoi(item)
-			if err == nil && value > 0 {
-				dbgp.lastSourceBegin = value
-			}
-			s_found = false
-		}
-		if item == "-b" {
-			s_found = true
+	if item, ok := cl.GetOption("-b"); ok {
+		value, err := strconv.Atoi(item)
+		if err == nil && value > 0 {
+			dbgp.lastSourceBegin = value
 		}
 	}
 
-	return parts
+	return cl
+}
+
+// Only for data that a user typed, which DBGp always wants base64 encoded, unless the user
+// already did that. Commands that are built with SetData are always encoded.
+func (dbgp *dbgpClient) encodeDataIfNeeded(cl *CommandLine) *CommandLine {
+	if cl.HasData && cl.parsed {
+		cl.DataEncoded = dbgp.rawData
+	}
+
+	return cl
+}
+
+func (dbgp *dbgpClient) prepareCommand(line string) (*CommandLine, error) {
+	cl, err := ParseCommandLine(strings.TrimSpace(line))
+	if err != nil {
+		return nil, err
+	}
+
+	return dbgp.prepareCommandLine(cl), nil
 }
 
-func (dbgp *dbgpClient) processLine(line string) string {
-	parts := strings.Split(strings.TrimSpace(line), " ")
+func (dbgp *dbgpClient) prepareCommandLine(cl *CommandLine) *CommandLine {
+	cl = dbgp.injectIIfNeeded(cl)
+	cl = dbgp.storeSourceBeginIfPresent(cl)
+	cl = dbgp.encodeDataIfNeeded(cl)
+
+	return cl
+}
 
-	parts = dbgp.injectIIfNeeded(parts)
-	parts = dbgp.storeSourceBeginIfPresent(parts)
+func (dbgp *dbgpClient) processLine(line string) (string, error) {
+	cl, err := dbgp.prepareCommand(line)
+	if err != nil {
+		return "", err
+	}
 
-	return strings.Join(parts, " ")
+	return cl.String(), nil
 }
 
 func (dbgp *dbgpClient) SendCommand(line string) error {
-	line = dbgp.processLine(line)
+	line, err := dbgp.processLine(line)
+	if err != nil {
+		dbgp.logger.LogError("dbgp-client", "Error parsing command: %s", err.Error())
+		return err
+	}
 
-	_, err := dbgp.writer.Write([]byte(line + "\000"))
+	err = dbgp.writeCommand(line)
 	if err != nil {
 		dbgp.logger.LogError("dbgp-client", "Error writing data '%s': %s", line, err.Error())
 	}
@@ -306,64 +258,137 @@ func (dbgp *dbgpClient) SendCommand(line string) error {
 	return err
 }
 
-func (dbgp *dbgpClient) FormatXML(rawXmlData string) Response {
-	var response Response
-
-	response, err := dbgp.parseResponseXML(rawXmlData)
+// Like SendCommand, for commands that are built with NewCommandLine, so that their data is not parsed again
+func (dbgp *dbgpClient) SendCommandLine(cl *CommandLine) error {
+	line := dbgp.prepareCommandLine(cl).String()
 
-	if err == nil {
-		return response
+	err := dbgp.writeCommand(line)
+	if err != nil {
+		dbgp.logger.LogError("dbgp-client", "Error writing data '%s': %s", line, err.Error())
 	}
 
-	response, err = dbgp.parseCtrlResponseXML(rawXmlData)
-
-	if err == nil {
-		return response
-	}
+	return err
+}
 
-	response, err = dbgp.ParseInitXML(rawXmlData)
+func (dbgp *dbgpClient) writeCommand(line string) error {
+	dbgp.recorder.RecordCommand(line)
+	_, err := dbgp.writer.Write([]byte(line + "\000"))
 
-	if err == nil {
-		return response
-	}
+	return err
+}
 
-	response, err = dbgp.parseNotifyXML(rawXmlData)
+// Returned when a packet's root element is not one of the known DBGp packet types
+type UnknownPacketError struct {
+	Root string
+}
 
-	if err == nil {
-		return response
-	}
+func (err *UnknownPacketError) Error() string {
+	return fmt.Sprintf("Unknown packet type with root element '<%s>'", err.Root)
+}
 
-	response, err = dbgp.parseStreamXML(rawXmlData)
+// Decodes a packet by looking at its root element only once
+func (dbgp *dbgpClient) ParseXML(rawXmlData string) (Response, error) {
+	var packet Response
 
-	if err == nil {
-		return response
-	}
+	reader := strings.NewReader(rawXmlData)
 
-	response, err = dbgp.parseProxyInitXML(rawXmlData)
+	decoder := xml.NewDecoder(reader)
+	decoder.CharsetReader = charset.NewReaderLabel
 
-	if err == nil {
-		return response
+	start, err := findRootElement(decoder)
+	if err != nil {
+		return nil, err
+	}
+
+	switch start.Name.Local {
+	case "response":
+		response := dbgpxml.Response{}
+		err = decoder.DecodeElement(&response, &start)
+		response.LastSourceBegin = dbgp.lastSourceBegin
+		packet = response
+
+	case "ctrl-response":
+		response := dbgpxml.CtrlResponse{}
+		err = decoder.DecodeElement(&response, &start)
+		packet = response
+
+	case "init":
+		init := dbgpxml.Init{}
+		err = decoder.DecodeElement(&init, &start)
+		packet = init
+
+	case "notify":
+		notify := dbgpxml.Notify{}
+		err = decoder.DecodeElement(&notify, &start)
+		packet = notify
+
+	case "stream":
+		stream := dbgpxml.Stream{}
+		err = decoder.DecodeElement(&stream, &start)
+		packet = stream
+
+	case "proxyinit":
+		init := dbgpxml.ProxyInit{}
+		err = decoder.DecodeElement(&init, &start)
+		packet = init
+
+	case "proxystop":
+		stop := dbgpxml.ProxyStop{}
+		err = decoder.DecodeElement(&stop, &start)
+		packet = stop
+
+	case "proxylist":
+		list := dbgpxml.ProxyList{}
+		err = decoder.DecodeElement(&list, &start)
+		packet = list
+
+	case "proxyinfo":
+		info := dbgpxml.ProxyInfo{}
+		err = decoder.DecodeElement(&info, &start)
+		packet = info
+
+	case "cloudinit":
+		init := dbgpxml.CloudInit{}
+		err = decoder.DecodeElement(&init, &start)
+		packet = init
+
+	case "cloudstop":
+		stop := dbgpxml.CloudStop{}
+		err = decoder.DecodeElement(&stop, &start)
+		packet = stop
+
+	default:
+		return nil, &UnknownPacketError{Root: start.Name.Local}
 	}
 
-	response, err = dbgp.parseProxyStopXML(rawXmlData)
-
-	if err == nil {
-		return response
+	if err != nil {
+		return nil, fmt.Errorf("Could not decode '<%s>' packet: %w", start.Name.Local, err)
 	}
 
-	response, err = dbgp.parseCloudInitXML(rawXmlData)
+	return packet, nil
+}
 
-	if err == nil {
-		return response
+func findRootElement(decoder *xml.Decoder) (xml.StartElement, error) {
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return xml.StartElement{}, fmt.Errorf("Could not find root element: %w", err)
+		}
+
+		if start, ok := token.(xml.StartElement); ok {
+			return start, nil
+		}
 	}
+}
 
-	response, err = dbgp.parseCloudStopXML(rawXmlData)
+func (dbgp *dbgpClient) FormatXML(rawXmlData string) Response {
+	response, err := dbgp.ParseXML(rawXmlData)
 
-	if err == nil {
-		return response
+	if err != nil {
+		return nil
 	}
 
-	return nil
+	return response
 }
 
 func (dbgp *dbgpClient) RunCommand(command string) error {
@@ -373,6 +398,22 @@ func (dbgp *dbgpClient) RunCommand(command string) error {
 		return err
 	}
 
+	return dbgp.checkResponse()
+}
+
+// Like RunCommand, for commands that are built with NewCommandLine
+func (dbgp *dbgpClient) RunCommandLine(cl *CommandLine) error {
+	err := dbgp.SendCommandLine(cl)
+
+	if err != nil { // writing failed
+		return err
+	}
+
+	return dbgp.checkResponse()
+}
+
+// Reads the response to the command that was just sent, and returns its error, if any
+func (dbgp *dbgpClient) checkResponse() error {
 	response, err := dbgp.ReadResponse()
 
 	if err != nil { // reading failed
@@ -383,14 +424,14 @@ func (dbgp *dbgpClient) RunCommand(command string) error {
 		return fmt.Errorf("The received XML is not valid, closing connection: %s", response)
 	}
 
-	formattedResponse := dbgp.FormatXML(response)
+	formattedResponse, err := dbgp.ParseXML(response)
 
-	if formattedResponse.IsSuccess() == false {
-		return fmt.Errorf("%s", formattedResponse.GetErrorMessage())
+	if err != nil {
+		return fmt.Errorf("Could not interpret XML, closing connection: %w", err)
 	}
 
-	if formattedResponse == nil {
-		return fmt.Errorf("Could not interpret XML, closing connection.")
+	if formattedResponse.IsSuccess() == false {
+		return fmt.Errorf("%s", formattedResponse.GetErrorMessage())
 	}
 
 	return nil
@@ -419,10 +460,10 @@ func RunAndQuit(conn net.Conn, command string, output io.Writer, logOutput logge
 		fmt.Fprintf(output, "%s\n", Faint(response))
 	}
 
-	formatted := proto.FormatXML(response)
+	formatted, err := proto.ParseXML(response)
 
-	if formatted == nil {
-		fmt.Fprintf(output, "Could not interpret XML")
+	if err != nil {
+		fmt.Fprintf(output, "Could not interpret XML: %s", err)
 		return nil
 	}
 	fmt.Fprintln(output, formatted)
@@ -0,0 +1,185 @@
+package protocol
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/derickr/dbgp-tools/lib/dbgpxml"
+	"github.com/derickr/dbgp-tools/lib/logger"
+)
+
+func TestPrepareCommandEncodesData(t *testing.T) {
+	tests := []struct {
+		line     string
+		rawData  bool
+		expected string
+	}{
+		{"eval -- $a", false, "eval -i 1 -- JGE="},
+		{"eval -- JGE=", true, "eval -i 1 -- JGE="},
+		{"detach -- going away", false, "detach -i 1 -- Z29pbmcgYXdheQ=="},
+		{"stdin -c 1 -- input", false, "stdin -i 1 -c 1 -- aW5wdXQ="},
+		{"breakpoint_set -t conditional -f file:///a.php -n 3 -- $a == 1", false, "breakpoint_set -i 1 -t conditional -f file:///a.php -n 3 -- JGEgPT0gMQ=="},
+		{"run --", false, "run -i 1 --"},
+	}
+
+	for _, test := range tests {
+		client := &dbgpClient{counter: 1, rawData: test.rawData}
+
+		actual, err := client.processLine(test.line)
+		if err != nil {
+			t.Errorf("processLine(%q): unexpected error: %s", test.line, err)
+			continue
+		}
+		if actual != test.expected {
+			t.Errorf("processLine(%q) = %q, expected %q", test.line, actual, test.expected)
+		}
+	}
+}
+
+func TestSetDataIsAlwaysEncoded(t *testing.T) {
+	client := &dbgpClient{counter: 1, rawData: true}
+
+	cl := NewCommandLine("breakpoint_set", "-t", "watch")
+	cl.SetData("$a")
+
+	if actual, expected := client.prepareCommandLine(cl).String(), "breakpoint_set -i 1 -t watch -- JGE="; actual != expected {
+		t.Errorf("prepareCommandLine() = %q, expected %q", actual, expected)
+	}
+}
+
+func TestReadResponseWithTimeout(t *testing.T) {
+	packetBodyTimeout = 100 * time.Millisecond
+	defer func() { packetBodyTimeout = 10 * time.Second }()
+
+	tests := []struct {
+		name     string
+		written  string
+		response string
+		timedOut bool
+		framing  bool
+	}{
+		{"complete packet", "5\000<xml>\000", "<xml>", false, false},
+		{"nothing", "", "", true, false},
+		{"only the length", "5\000", "", false, true},
+		{"half a packet", "5\000<x", "", false, true},
+		{"invalid length", "five\000<xml>\000", "", false, true},
+		{"no terminating NUL", "5\000<xml>x", "", false, true},
+		{"next packet instead of the terminating NUL", "5\000<xml>5\000<xml>\000", "", false, true},
+		{"embedded NUL", "5\000<x\000l>\000", "", false, true},
+		{"only NULs", "2\000\000\000\000", "", false, true},
+	}
+
+	for _, test := range tests {
+		engine, ide := net.Pipe()
+		client := NewDbgpClient(ide, logger.NewConsoleLogger(io.Discard))
+
+		go func() {
+			engine.Write([]byte(test.written))
+		}()
+
+		response, err, timedOut := client.ReadResponseWithTimeout(50 * time.Millisecond)
+
+		var framingError *FramingError
+		if response != test.response || timedOut != test.timedOut || errors.As(err, &framingError) != test.framing {
+			t.Errorf("%s: got %q, %v, timed out: %v", test.name, response, err, timedOut)
+		}
+
+		engine.Close()
+		ide.Close()
+	}
+}
+
+func TestParseXML(t *testing.T) {
+	const header = `<?xml version="1.0" encoding="iso-8859-1"?>` + "\n"
+	const namespaces = `xmlns="urn:debugger_protocol_v1" xmlns:xdebug="https://xdebug.org/dbgp/xdebug"`
+
+	tests := []struct {
+		packet   string
+		expected string // the type of the decoded packet
+		check    func(Response) bool
+	}{
+		{
+			`<response ` + namespaces + ` command="status" transaction_id="3" status="break" reason="ok"></response>`,
+			"dbgpxml.Response",
+			func(packet Response) bool { return packet.(dbgpxml.Response).Status == "break" },
+		},
+		{
+			`<ctrl-response ` + namespaces + ` command="ctrl-ping" transaction_id="1" success="1"></ctrl-response>`,
+			"dbgpxml.CtrlResponse",
+			nil,
+		},
+		{
+			`<init ` + namespaces + ` fileuri="file:///app/index.php" language="PHP" protocol_version="1.0" appid="1" idekey="PHPSTORM"></init>`,
+			"dbgpxml.Init",
+			func(packet Response) bool { return packet.(dbgpxml.Init).FileURI == "file:///app/index.php" },
+		},
+		{
+			`<notify ` + namespaces + ` name="breakpoint_resolved"><breakpoint id="2" type="line" lineno="8"></breakpoint></notify>`,
+			"dbgpxml.Notify",
+			func(packet Response) bool { return packet.(dbgpxml.Notify).Name == "breakpoint_resolved" },
+		},
+		{
+			`<stream ` + namespaces + ` type="stdout" encoding="base64"><![CDATA[aGVsbG8=]]></stream>`,
+			"dbgpxml.Stream",
+			func(packet Response) bool { return packet.(dbgpxml.Stream).Type == "stdout" },
+		},
+		{
+			`<proxyinit ` + namespaces + ` success="1" idekey="PHPSTORM" address="127.0.0.1" port="9003"></proxyinit>`,
+			"dbgpxml.ProxyInit",
+			func(packet Response) bool { return packet.(dbgpxml.ProxyInit).IDEKey == "PHPSTORM" },
+		},
+		{
+			`<proxystop ` + namespaces + ` success="1" idekey="PHPSTORM"></proxystop>`,
+			"dbgpxml.ProxyStop",
+			func(packet Response) bool { return packet.(dbgpxml.ProxyStop).Success == 1 },
+		},
+		{
+			`<proxylist ` + namespaces + ` success="1"><connection idekey="a" address="10.0.0.1" port="9003"></connection><connection idekey="b" address="10.0.0.2" port="9003"></connection></proxylist>`,
+			"dbgpxml.ProxyList",
+			func(packet Response) bool { return len(packet.(dbgpxml.ProxyList).Connections) == 2 },
+		},
+		{
+			`<proxyinfo ` + namespaces + ` success="1" idekey="a"><connection idekey="a" address="10.0.0.1" port="9003"></connection></proxyinfo>`,
+			"dbgpxml.ProxyInfo",
+			func(packet Response) bool { return packet.(dbgpxml.ProxyInfo).IDEKey == "a" },
+		},
+		{
+			`<cloudinit ` + namespaces + ` success="1" userid="user"></cloudinit>`,
+			"dbgpxml.CloudInit",
+			nil,
+		},
+		{
+			`<cloudstop ` + namespaces + ` success="1" userid="user"></cloudstop>`,
+			"dbgpxml.CloudStop",
+			nil,
+		},
+	}
+
+	client := &dbgpClient{}
+
+	for _, test := range tests {
+		packet, err := client.ParseXML(header + test.packet)
+		if err != nil {
+			t.Errorf("%s: %s", test.expected, err)
+			continue
+		}
+		if actual := fmt.Sprintf("%T", packet); actual != test.expected {
+			t.Errorf("%s: decoded as %s", test.expected, actual)
+			continue
+		}
+		if test.check != nil && !test.check(packet) {
+			t.Errorf("%s: unexpected contents %+v", test.expected, packet)
+		}
+	}
+
+	packet, err := client.ParseXML(header + `<unknown ` + namespaces + `></unknown>`)
+
+	var unknownPacket *UnknownPacketError
+	if !errors.As(err, &unknownPacket) || unknownPacket.Root != "unknown" {
+		t.Errorf("an unknown root element returned %+v, %v", packet, err)
+	}
+}
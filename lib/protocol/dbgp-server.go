@@ -33,31 +33,44 @@ func NewDbgpServer(c net.Conn, connectionList *connections.ConnectionList, logge
 }
 
 func (dbgp *DbgpServer) parseLine(data string) (command.DbgpCommand, error) {
-	parts := strings.Split(data, " ")
+	cl, err := ParseCommandLine(data)
+	if err != nil {
+		return nil, err
+	}
 
-	switch parts[0] {
+	switch cl.Name {
 	case "proxyinit":
 		host, _, _ := net.SplitHostPort(dbgp.connection.RemoteAddr().String())
-		return command.CreateProxyInit(host, dbgp.connectionList, parts[1:], dbgp.logger)
+		return command.CreateProxyInit(host, dbgp.connectionList, cl.Arguments, dbgp.logger)
 
 	case "proxystop":
-		return command.CreateProxyStop(dbgp.connectionList, parts[1:], dbgp.logger)
+		host, _, _ := net.SplitHostPort(dbgp.connection.RemoteAddr().String())
+		return command.CreateProxyStop(host, dbgp.connectionList, cl.Arguments, dbgp.logger)
+
+	case "proxylist":
+		return command.CreateProxyList(dbgp.connectionList, cl.Arguments, dbgp.logger)
+
+	case "proxyinfo":
+		return command.CreateProxyInfo(dbgp.connectionList, cl.Arguments, dbgp.logger)
 	}
 
-	return nil, fmt.Errorf("Don't understand command '%s'", parts)
+	return nil, fmt.Errorf("Don't understand command '%s'", cl.Name)
 }
 
 func (dbgp *DbgpServer) parseCloudLine(data string) (command.DbgpCloudCommand, error) {
-	parts := strings.Split(data, " ")
+	cl, err := ParseCommandLine(data)
+	if err != nil {
+		return nil, err
+	}
 
-	switch parts[0] {
+	switch cl.Name {
 	case "cloudinit":
-		return command.CreateCloudInit(dbgp.connectionList, &dbgp.connection, parts[1:], dbgp.logger)
+		return command.CreateCloudInit(dbgp.connectionList, &dbgp.connection, cl.Arguments, dbgp.logger)
 	case "cloudstop":
-		return command.CreateCloudStop(dbgp.connectionList, &dbgp.connection, parts[1:], dbgp.logger)
+		return command.CreateCloudStop(dbgp.connectionList, &dbgp.connection, cl.Arguments, dbgp.logger)
 	}
 
-	return nil, fmt.Errorf("Don't understand command '%s'", parts)
+	return nil, fmt.Errorf("Don't understand command '%s'", cl.Name)
 }
 
 func (dbgp *DbgpServer) ReadCommand() (command.DbgpCommand, error) {
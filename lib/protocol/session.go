@@ -0,0 +1,372 @@
+package protocol
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/derickr/dbgp-tools/lib/dbgpxml"
+	"github.com/derickr/dbgp-tools/lib/logger"
+)
+
+var ErrSessionClosed = errors.New("The debugging session has been closed")
+
+const subscriberQueueLength = 64
+
+/*
+ * A Session wraps a connection from a debugging engine. A single goroutine
+ * reads all packets from the engine, and routes each <response> to the caller
+ * that sent the command with the same transaction ID. <notify> and <stream>
+ * packets are delivered to their own subscribers.
+ *
+ * Commands can be sent from multiple goroutines at once, which means that a
+ * 'break' can be issued while a 'run' is still waiting for its response, if
+ * the engine supports it.
+ */
+type Session struct {
+	mu        sync.Mutex
+	client    *dbgpClient
+	logger    logger.Logger
+	writeLock sync.Mutex
+
+	pending       map[string]chan dbgpxml.Response
+	notifications []chan dbgpxml.Notify
+	streams       []chan dbgpxml.Stream
+	init          chan dbgpxml.Init
+
+	done          chan struct{}
+	err           error
+	supportsAsync *bool
+}
+
+func NewSession(c net.Conn, logger logger.Logger) *Session {
+	return newSession(NewDbgpClient(c, logger), logger)
+}
+
+func newSession(client *dbgpClient, logger logger.Logger) *Session {
+	session := &Session{
+		client:  client,
+		logger:  logger,
+		pending: map[string]chan dbgpxml.Response{},
+		init:    make(chan dbgpxml.Init, 1),
+		done:    make(chan struct{}),
+	}
+
+	go session.readLoop()
+
+	return session
+}
+
+func (session *Session) readLoop() {
+	for {
+		data, err := session.client.ReadResponse()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				err = ErrSessionClosed
+			}
+			session.shutdown(err)
+			return
+		}
+
+		if !dbgpxml.IsValidXml(data) {
+			session.logger.LogWarning("session", "Ignoring invalid XML packet: %s", data)
+			continue
+		}
+
+		session.mu.Lock()
+		packet, err := session.client.ParseXML(data)
+		session.mu.Unlock()
+
+		if err != nil {
+			session.logger.LogWarning("session", "Could not interpret packet from engine: %s", err)
+			continue
+		}
+
+		session.dispatch(packet)
+	}
+}
+
+func (session *Session) dispatch(packet Response) {
+	switch p := packet.(type) {
+	case dbgpxml.Response:
+		session.mu.Lock()
+		waiter, ok := session.pending[p.TID]
+		delete(session.pending, p.TID)
+		session.mu.Unlock()
+
+		if !ok {
+			session.logger.LogWarning("session", "Received response for unknown transaction ID '%s' (%s)", p.TID, p.Command)
+			return
+		}
+		waiter <- p
+		close(waiter)
+
+	case dbgpxml.Notify:
+		session.mu.Lock()
+		for _, subscriber := range session.notifications {
+			select {
+			case subscriber <- p:
+			default:
+				session.logger.LogWarning("session", "Notification subscriber is not keeping up, dropping '%s'", p.Name)
+			}
+		}
+		session.mu.Unlock()
+
+	case dbgpxml.Stream:
+		session.mu.Lock()
+		for _, subscriber := range session.streams {
+			select {
+			case subscriber <- p:
+			default:
+				session.logger.LogWarning("session", "Stream subscriber is not keeping up, dropping '%s' output", p.Type)
+			}
+		}
+		session.mu.Unlock()
+
+	case dbgpxml.Init:
+		select {
+		case session.init <- p:
+		default:
+			session.logger.LogWarning("session", "Ignoring duplicate init packet")
+		}
+
+	default:
+		session.logger.LogWarning("session", "Ignoring unexpected packet of type %T", packet)
+	}
+}
+
+func (session *Session) shutdown(err error) {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+
+	select {
+	case <-session.done:
+		return
+	default:
+	}
+
+	session.err = err
+	close(session.done)
+
+	for tid, waiter := range session.pending {
+		close(waiter)
+		delete(session.pending, tid)
+	}
+	for _, subscriber := range session.notifications {
+		close(subscriber)
+	}
+	for _, subscriber := range session.streams {
+		close(subscriber)
+	}
+	session.notifications = nil
+	session.streams = nil
+}
+
+// Waits for the engine's <init> packet, which is the first thing it sends after connecting
+func (session *Session) WaitForInit(timeout time.Duration) (dbgpxml.Init, error) {
+	select {
+	case init := <-session.init:
+		// The engine is idle right after init, so this is the moment to find out
+		// whether 'break' can be sent later while a continuation command runs. With
+		// Xdebug Cloud, each init packet is from another engine, which is asked again.
+		session.mu.Lock()
+		session.supportsAsync = nil
+		session.mu.Unlock()
+
+		session.SupportsAsync()
+		return init, nil
+	case <-session.done:
+		return dbgpxml.Init{}, session.Err()
+	case <-time.After(timeout):
+		return dbgpxml.Init{}, fmt.Errorf("No init packet received within %s", timeout)
+	}
+}
+
+func (session *Session) SubscribeNotifications() <-chan dbgpxml.Notify {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+
+	subscriber := make(chan dbgpxml.Notify, subscriberQueueLength)
+	if session.isClosed() {
+		close(subscriber)
+	} else {
+		session.notifications = append(session.notifications, subscriber)
+	}
+
+	return subscriber
+}
+
+func (session *Session) SubscribeStreams() <-chan dbgpxml.Stream {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+
+	subscriber := make(chan dbgpxml.Stream, subscriberQueueLength)
+	if session.isClosed() {
+		close(subscriber)
+	} else {
+		session.streams = append(session.streams, subscriber)
+	}
+
+	return subscriber
+}
+
+// Stops delivering notifications to a channel from SubscribeNotifications, and closes it
+func (session *Session) UnsubscribeNotifications(subscriber <-chan dbgpxml.Notify) {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+
+	for i, other := range session.notifications {
+		if other == subscriber {
+			session.notifications = append(session.notifications[:i:i], session.notifications[i+1:]...)
+			close(other)
+			return
+		}
+	}
+}
+
+// Stops delivering streams to a channel from SubscribeStreams, and closes it
+func (session *Session) UnsubscribeStreams(subscriber <-chan dbgpxml.Stream) {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+
+	for i, other := range session.streams {
+		if other == subscriber {
+			session.streams = append(session.streams[:i:i], session.streams[i+1:]...)
+			close(other)
+			return
+		}
+	}
+}
+
+func (session *Session) isClosed() bool {
+	select {
+	case <-session.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// Sends a command, and returns a channel on which its response will be delivered
+func (session *Session) Send(line string) (<-chan dbgpxml.Response, error) {
+	cl, err := ParseCommandLine(line)
+	if err != nil {
+		return nil, err
+	}
+
+	return session.SendCommandLine(cl)
+}
+
+func (session *Session) SendCommandLine(cl *CommandLine) (<-chan dbgpxml.Response, error) {
+	session.mu.Lock()
+
+	if session.isClosed() {
+		session.mu.Unlock()
+		return nil, session.Err()
+	}
+
+	cl = session.client.prepareCommandLine(cl)
+
+	tid, _ := cl.GetOption("-i")
+	if _, ok := session.pending[tid]; ok {
+		session.mu.Unlock()
+		return nil, fmt.Errorf("Transaction ID '%s' is already in use", tid)
+	}
+
+	waiter := make(chan dbgpxml.Response, 1)
+	session.pending[tid] = waiter
+	session.mu.Unlock()
+
+	session.writeLock.Lock()
+	err := session.client.writeCommand(cl.String())
+	session.writeLock.Unlock()
+
+	if err != nil {
+		session.mu.Lock()
+		delete(session.pending, tid)
+		session.mu.Unlock()
+		session.logger.LogError("session", "Error writing data '%s': %s", cl.String(), err.Error())
+		return nil, err
+	}
+
+	return waiter, nil
+}
+
+// Sends a command and waits for its response
+func (session *Session) Execute(line string) (dbgpxml.Response, error) {
+	cl, err := ParseCommandLine(line)
+	if err != nil {
+		return dbgpxml.Response{}, err
+	}
+
+	return session.ExecuteCommandLine(cl)
+}
+
+func (session *Session) ExecuteCommandLine(cl *CommandLine) (dbgpxml.Response, error) {
+	waiter, err := session.SendCommandLine(cl)
+	if err != nil {
+		return dbgpxml.Response{}, err
+	}
+
+	response, ok := <-waiter
+	if !ok {
+		return dbgpxml.Response{}, session.Err()
+	}
+
+	return response, nil
+}
+
+// Whether the engine allows commands to be sent while a continuation command is still running.
+// The result is cached, and determined by WaitForInit already.
+func (session *Session) SupportsAsync() bool {
+	session.mu.Lock()
+	cached := session.supportsAsync
+	session.mu.Unlock()
+
+	if cached != nil {
+		return *cached
+	}
+
+	response, err := session.Execute("feature_get -n supports_async")
+	supported := err == nil && response.Supported == 1 && response.Value == "1"
+
+	session.mu.Lock()
+	session.supportsAsync = &supported
+	session.mu.Unlock()
+
+	return supported
+}
+
+// Interrupts a running script. Only possible when the engine supports 'supports_async'.
+func (session *Session) Break() (dbgpxml.Response, error) {
+	if !session.SupportsAsync() {
+		return dbgpxml.Response{}, fmt.Errorf("The debugging engine does not support asynchronous commands")
+	}
+
+	return session.execute(NewCommandLine("break"))
+}
+
+func (session *Session) Done() <-chan struct{} {
+	return session.done
+}
+
+func (session *Session) Err() error {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+
+	if session.err == nil && session.isClosed() {
+		return ErrSessionClosed
+	}
+
+	return session.err
+}
+
+func (session *Session) Close() error {
+	err := session.client.connection.Close()
+	session.shutdown(ErrSessionClosed)
+
+	return err
+}
@@ -0,0 +1,354 @@
+package protocol
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/derickr/dbgp-tools/lib/dbgpxml"
+	"github.com/derickr/dbgp-tools/lib/logger"
+)
+
+// The engine's side of a session: it hands each command to the test, and sends the packets it is told to
+type fakeEngine struct {
+	t        *testing.T
+	conn     net.Conn
+	commands chan *CommandLine
+}
+
+func newFakeEngine(t *testing.T) (*fakeEngine, *Session) {
+	engineSide, ideSide := net.Pipe()
+
+	engine := &fakeEngine{t: t, conn: engineSide, commands: make(chan *CommandLine, 10)}
+	session := NewSession(ideSide, logger.NewTextLogger(io.Discard))
+	t.Cleanup(func() { engineSide.Close(); session.Close() })
+
+	go func() {
+		defer close(engine.commands)
+
+		reader := bufio.NewReader(engineSide)
+		for {
+			line, err := reader.ReadString(0)
+			if err != nil {
+				return
+			}
+
+			cl, err := ParseCommandLine(line[:len(line)-1])
+			if err != nil {
+				t.Errorf("the session sent an invalid command %q: %s", line, err)
+				return
+			}
+			engine.commands <- cl
+		}
+	}()
+
+	return engine, session
+}
+
+// Returns the next command that the session sent, and its transaction ID
+func (engine *fakeEngine) expect(name string) string {
+	engine.t.Helper()
+
+	select {
+	case cl := <-engine.commands:
+		if cl == nil || cl.Name != name {
+			engine.t.Fatalf("expected '%s', received %+v", name, cl)
+		}
+		tid, _ := cl.GetOption("-i")
+		return tid
+	case <-time.After(5 * time.Second):
+		engine.t.Fatalf("'%s' was not sent", name)
+	}
+
+	return ""
+}
+
+func (engine *fakeEngine) send(packet string) {
+	engine.t.Helper()
+
+	packet = `<?xml version="1.0" encoding="iso-8859-1"?>` + "\n" + packet
+	if _, err := fmt.Fprintf(engine.conn, "%d\000%s\000", len(packet), packet); err != nil {
+		engine.t.Fatalf("could not send %q: %s", packet, err)
+	}
+}
+
+func (engine *fakeEngine) respond(command string, tid string, attributes string) {
+	engine.t.Helper()
+
+	engine.send(fmt.Sprintf(`<response xmlns="urn:debugger_protocol_v1" command="%s" transaction_id="%s" %s></response>`, command, tid, attributes))
+}
+
+func receive(t *testing.T, waiter <-chan dbgpxml.Response) (dbgpxml.Response, bool) {
+	t.Helper()
+
+	select {
+	case response, ok := <-waiter:
+		return response, ok
+	case <-time.After(5 * time.Second):
+		t.Fatalf("no response was delivered")
+	}
+
+	return dbgpxml.Response{}, false
+}
+
+func TestSessionRoutesResponsesOutOfOrder(t *testing.T) {
+	engine, session := newFakeEngine(t)
+
+	first, err := session.Send("status")
+	if err != nil {
+		t.Fatalf("sending 'status' failed: %s", err)
+	}
+	firstTID := engine.expect("status")
+
+	second, err := session.Send("stack_depth")
+	if err != nil {
+		t.Fatalf("sending 'stack_depth' failed: %s", err)
+	}
+	secondTID := engine.expect("stack_depth")
+
+	// A response nobody waits for is dropped, without disturbing the others
+	engine.respond("status", "999", `status="break"`)
+	engine.respond("stack_depth", secondTID, `depth="3"`)
+	engine.respond("status", firstTID, `status="break" reason="ok"`)
+
+	if response, ok := receive(t, second); !ok || response.Command != "stack_depth" || response.Depth != 3 {
+		t.Errorf("unexpected response to 'stack_depth': %+v", response)
+	}
+	if response, ok := receive(t, first); !ok || response.Command != "status" || response.TID != firstTID {
+		t.Errorf("unexpected response to 'status': %+v", response)
+	}
+}
+
+func TestSessionRefusesDuplicateTransactionIDs(t *testing.T) {
+	engine, session := newFakeEngine(t)
+
+	if _, err := session.Send("status -i 5"); err != nil {
+		t.Fatalf("sending 'status' failed: %s", err)
+	}
+	engine.expect("status")
+
+	if _, err := session.Send("run -i 5"); err == nil {
+		t.Errorf("a transaction ID that is still waiting for its response was used again")
+	}
+}
+
+func TestSessionDeliversToAllSubscribers(t *testing.T) {
+	engine, session := newFakeEngine(t)
+
+	notifications := []<-chan dbgpxml.Notify{session.SubscribeNotifications(), session.SubscribeNotifications()}
+	streams := []<-chan dbgpxml.Stream{session.SubscribeStreams(), session.SubscribeStreams()}
+
+	engine.send(`<notify xmlns="urn:debugger_protocol_v1" name="breakpoint_resolved"><breakpoint id="1" type="line" lineno="8"></breakpoint></notify>`)
+	engine.send(`<stream xmlns="urn:debugger_protocol_v1" type="stdout" encoding="base64"><![CDATA[aGVsbG8=]]></stream>`)
+
+	for i, subscriber := range notifications {
+		select {
+		case notify := <-subscriber:
+			if notify.Name != "breakpoint_resolved" || notify.Breakpoint.ID != 1 {
+				t.Errorf("notification subscriber %d: unexpected %+v", i, notify)
+			}
+		case <-time.After(5 * time.Second):
+			t.Errorf("notification subscriber %d received nothing", i)
+		}
+	}
+
+	for i, subscriber := range streams {
+		select {
+		case stream := <-subscriber:
+			if stream.Type != "stdout" || stream.Value != "aGVsbG8=" {
+				t.Errorf("stream subscriber %d: unexpected %+v", i, stream)
+			}
+		case <-time.After(5 * time.Second):
+			t.Errorf("stream subscriber %d received nothing", i)
+		}
+	}
+}
+
+func TestSessionBreakWhileRunning(t *testing.T) {
+	engine, session := newFakeEngine(t)
+
+	ran := make(chan dbgpxml.Response, 1)
+	go func() {
+		response, err := session.Run()
+		if err != nil {
+			t.Errorf("'run' failed: %s", err)
+		}
+		ran <- response
+	}()
+	runTID := engine.expect("run")
+
+	broke := make(chan error, 1)
+	go func() {
+		_, err := session.Break()
+		broke <- err
+	}()
+
+	// Whether the engine supports it is asked while 'run' still waits for its response
+	featureTID := engine.expect("feature_get")
+	engine.send(fmt.Sprintf(`<response xmlns="urn:debugger_protocol_v1" command="feature_get" transaction_id="%s" feature_name="supports_async" supported="1"><![CDATA[1]]></response>`, featureTID))
+
+	breakTID := engine.expect("break")
+	engine.respond("break", breakTID, `success="1"`)
+	engine.respond("run", runTID, `status="break" reason="ok"`)
+
+	select {
+	case err := <-broke:
+		if err != nil {
+			t.Errorf("'break' failed: %s", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("'break' did not return")
+	}
+
+	select {
+	case response := <-ran:
+		if response.Status != "break" {
+			t.Errorf("'run' ended with status '%s'", response.Status)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("'run' did not return")
+	}
+}
+
+func TestSessionBreakWithoutAsyncSupport(t *testing.T) {
+	engine, session := newFakeEngine(t)
+
+	broke := make(chan error, 1)
+	go func() {
+		_, err := session.Break()
+		broke <- err
+	}()
+
+	tid := engine.expect("feature_get")
+	engine.respond("feature_get", tid, `feature_name="supports_async" supported="0"`)
+
+	if err := <-broke; err == nil {
+		t.Errorf("'break' was sent to an engine that does not support it")
+	}
+
+	// The answer is cached, so the engine is not asked again
+	if _, err := session.Break(); err == nil {
+		t.Errorf("'break' was sent to an engine that does not support it")
+	}
+	select {
+	case cl := <-engine.commands:
+		t.Errorf("unexpected command: %+v", cl)
+	default:
+	}
+}
+
+func TestSessionShutdownClosesWaiters(t *testing.T) {
+	engine, session := newFakeEngine(t)
+
+	notifications := session.SubscribeNotifications()
+	streams := session.SubscribeStreams()
+
+	waiter, err := session.Send("run")
+	if err != nil {
+		t.Fatalf("sending 'run' failed: %s", err)
+	}
+	engine.expect("run")
+
+	engine.conn.Close()
+
+	if _, ok := receive(t, waiter); ok {
+		t.Errorf("a response was delivered after the engine went away")
+	}
+
+	select {
+	case <-session.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatalf("the session did not end")
+	}
+
+	if _, ok := <-notifications; ok {
+		t.Errorf("the notification subscriber is still open")
+	}
+	if _, ok := <-streams; ok {
+		t.Errorf("the stream subscriber is still open")
+	}
+	if _, ok := <-session.SubscribeNotifications(); ok {
+		t.Errorf("a subscription after the end is still open")
+	}
+
+	if session.Err() != ErrSessionClosed {
+		t.Errorf("unexpected error: %v", session.Err())
+	}
+	if _, err := session.Execute("status"); err != ErrSessionClosed {
+		t.Errorf("a command after the end returned %v", err)
+	}
+}
+
+func TestSessionUnsubscribe(t *testing.T) {
+	engine, session := newFakeEngine(t)
+
+	ended := session.SubscribeStreams()
+	active := session.SubscribeStreams()
+	session.UnsubscribeStreams(ended)
+
+	if _, ok := <-ended; ok {
+		t.Errorf("the channel of an ended subscription is still open")
+	}
+
+	engine.send(`<stream xmlns="urn:debugger_protocol_v1" type="stdout" encoding="base64"><![CDATA[aGVsbG8=]]></stream>`)
+
+	select {
+	case stream := <-active:
+		if stream.Value != "aGVsbG8=" {
+			t.Errorf("unexpected stream %+v", stream)
+		}
+	case <-time.After(5 * time.Second):
+		t.Errorf("the remaining subscriber received nothing")
+	}
+}
+
+func TestSessionAsksForAsyncSupportWithEachInit(t *testing.T) {
+	engine, session := newFakeEngine(t)
+
+	for i, supported := range []string{"1", "0"} {
+		initialized := make(chan error, 1)
+		go func() {
+			_, err := session.WaitForInit(5 * time.Second)
+			initialized <- err
+		}()
+
+		engine.send(`<init xmlns="urn:debugger_protocol_v1" fileuri="file:///app/index.php" idekey="cloud" language="PHP" protocol_version="1.0"></init>`)
+		tid := engine.expect("feature_get")
+		engine.send(fmt.Sprintf(`<response xmlns="urn:debugger_protocol_v1" command="feature_get" transaction_id="%s" feature_name="supports_async" supported="1"><![CDATA[%s]]></response>`, tid, supported))
+
+		if err := <-initialized; err != nil {
+			t.Fatalf("init %d: %s", i+1, err)
+		}
+		if async := session.SupportsAsync(); async != (supported == "1") {
+			t.Errorf("init %d: the engine's answer was '%s', but SupportsAsync() returned %v", i+1, supported, async)
+		}
+	}
+}
+
+func TestCloudSessionRefused(t *testing.T) {
+	cloud, ide := net.Pipe()
+	defer cloud.Close()
+	defer ide.Close()
+
+	go func() {
+		if _, err := bufio.NewReader(cloud).ReadString(0); err != nil {
+			return
+		}
+
+		packet := `<?xml version="1.0" encoding="iso-8859-1"?>` + "\n" + `<cloudinit xmlns="urn:debugger_protocol_v1" success="0" userid="user"><error id="CLOUD-ERR-03"><message>A client for 'user' is already connected</message></error></cloudinit>`
+		fmt.Fprintf(cloud, "%d\000%s\000", len(packet), packet)
+	}()
+
+	session, err := NewCloudSession(ide, "user", logger.NewTextLogger(io.Discard))
+	if err == nil {
+		session.Close()
+		t.Fatalf("a refused 'cloudinit' returned a session")
+	}
+	if !strings.Contains(err.Error(), "already connected") {
+		t.Errorf("the error does not say why: %s", err)
+	}
+}
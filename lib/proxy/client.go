@@ -1,19 +1,21 @@
 package proxy
 
 import (
+	"bufio"
 	"crypto/tls"
 	"errors"
 	"fmt"
 	"io"
 	"net"
-	"os"
-	"os/signal"
-	"syscall"
+	"sync/atomic"
 	"time"
 
 	"github.com/derickr/dbgp-tools/lib/connections"
+	"github.com/derickr/dbgp-tools/lib/dbgpxml"
 	"github.com/derickr/dbgp-tools/lib/logger"
+	"github.com/derickr/dbgp-tools/lib/metrics"
 	"github.com/derickr/dbgp-tools/lib/protocol"
+	"github.com/derickr/dbgp-tools/lib/recorder"
 )
 
 const sleepTimeout = time.Millisecond * 50
@@ -21,57 +23,106 @@ const sleepTimeout = time.Millisecond * 50
 type ServerHandler struct {
 	logger         logger.Logger
 	connectionList *connections.ConnectionList
+	sessions       *SessionList
+	metrics        *metrics.Metrics
+	ideTLSConfig   atomic.Pointer[tls.Config]
+	recordDir      atomic.Pointer[string]
 }
 
-func NewServerHandler(connectionList *connections.ConnectionList, logger logger.Logger) *ServerHandler {
-	return &ServerHandler{connectionList: connectionList, logger: logger}
+func NewServerHandler(connectionList *connections.ConnectionList, sessions *SessionList, metrics *metrics.Metrics, logger logger.Logger) *ServerHandler {
+	return &ServerHandler{connectionList: connectionList, sessions: sessions, metrics: metrics, logger: logger}
 }
 
-func connectToIDE(clientConnection *connections.Connection) (net.Conn, error) {
+// Sets the configuration for connecting to IDEs that registered with 'proxyinit -s 1'. It can be
+// changed while sessions are active, as it is only used for new connections.
+func (handler *ServerHandler) SetIDETLSConfig(config *tls.Config) {
+	handler.ideTLSConfig.Store(config)
+}
+
+// Records the traffic of every session to a file in 'directory'
+func (handler *ServerHandler) SetRecordDirectory(directory string) {
+	handler.recordDir.Store(&directory)
+}
+
+func (handler *ServerHandler) connectToIDE(clientConnection *connections.Connection) (net.Conn, error) {
 	if clientConnection.IsSSL() {
-		cert, err := tls.LoadX509KeyPair("client-certs/client.pem", "client-certs/client.key")
-		if err != nil {
-			return nil, err
+		config := handler.ideTLSConfig.Load()
+		if config == nil {
+			return nil, fmt.Errorf("The IDE registered for SSL connections, but SSL is not configured for connecting to IDEs")
 		}
-		config := tls.Config{Certificates: []tls.Certificate{cert}, InsecureSkipVerify: true}
-		return tls.Dial("tcp", clientConnection.FullAddress(), &config)
+		return tls.Dial("tcp", clientConnection.FullAddress(), config)
 	} else {
 		return net.Dial("tcp", clientConnection.FullAddress())
 	}
 }
 
+// Returned by setupForwarder when nothing has been forwarded yet, so that
+// another IDE registered with the same key can still be tried
+type ideConnectError struct {
+	err error
+}
+
+func (err *ideConnectError) Error() string {
+	return err.err.Error()
+}
+
+func (err *ideConnectError) Unwrap() error {
+	return err.err
+}
+
 // Connects to IDE, sends the Init packet and proxies messages between IDE and Xdebug
 func (handler *ServerHandler) setupForwarder(conn net.Conn, initialPacket []byte, clientConnection *connections.Connection) error {
 	handler.logger.LogUserInfo("proxy-client", clientConnection.GetKey(), "Connecting to %s", clientConnection.FullAddress())
-	client, err := connectToIDE(clientConnection)
+	client, err := handler.connectToIDE(clientConnection)
 
 	if err != nil {
 		handler.logger.LogUserError("proxy-client", clientConnection.GetKey(), "IDE not connected: %s", err)
-		return err
+		handler.metrics.IDEConnectFailures.Inc()
+		return &ideConnectError{err}
 	}
 
 	handler.logger.LogUserInfo("proxy-client", clientConnection.GetKey(), "IDE connected")
-	reassembledPacket := fmt.Sprintf("%d\000%s", len(initialPacket)-1, initialPacket)
+	reassembledPacket := fmt.Sprintf("%d\000%s\000", len(initialPacket), initialPacket)
 	_, err = client.Write([]byte(reassembledPacket))
 	if err != nil {
 		_ = client.Close()
-		return err
+		handler.metrics.IDEConnectFailures.Inc()
+		return &ideConnectError{err}
 	}
 
 	handler.logger.LogUserInfo("proxy-client", clientConnection.GetKey(), "Init forwarded, start pipe")
 	serverChan := make(chan error)
 
+	session := handler.sessions.add(clientConnection.GetKey(), conn, client, clientConnection.FullAddress())
+	defer handler.sessions.remove(session)
+
+	sessionLogger := logger.WithFields(handler.logger, logger.Fields{"session": session.ID, "engine": session.EngineAddress, "ide": session.IDEAddress})
+	if recordDir := handler.recordDir.Load(); recordDir != nil && *recordDir != "" {
+		session.recorder, err = recorder.NewRecorder(*recordDir, clientConnection.GetKey())
+		if err != nil {
+			sessionLogger.LogUserError("proxy-client", clientConnection.GetKey(), "Not recording session: %s", err)
+		} else {
+			sessionLogger.LogUserInfo("proxy-client", clientConnection.GetKey(), "Recording session to '%s'", session.recorder.Path())
+			session.recorder.RecordResponse(string(initialPacket))
+			defer session.recorder.Close()
+		}
+	}
+
+	defer func() {
+		sessionLogger.LogUserInfo("proxy-client", clientConnection.GetKey(), "Session ended after %s, forwarded %d packet(s)", time.Since(session.Started).Round(time.Millisecond), session.GetPacketCount())
+	}()
+
 	defer func(closer io.Closer) {
 		err := closer.Close()
 		if err != nil {
-			handler.logger.LogUserError("proxy-client", clientConnection.GetKey(), "Closer didn't work: %v", err)
+			sessionLogger.LogUserError("proxy-client", clientConnection.GetKey(), "Closer didn't work: %v", err)
 		}
 		<-serverChan
 	}(client)
 
 	go func() {
 		// client read loop
-		if _, err = io.Copy(conn, client); err != nil {
+		if err := forwardCommands(session, client); err != nil {
 			serverChan <- err
 		}
 		close(serverChan)
@@ -86,35 +137,84 @@ func (handler *ServerHandler) setupForwarder(conn net.Conn, initialPacket []byte
 			// has client disconnected or had a fatal error?
 			select {
 			case err := <-serverChan:
-				handler.logger.LogUserInfo("proxy-client", clientConnection.GetKey(), "IDE closed connection")
+				sessionLogger.LogUserInfo("proxy-client", clientConnection.GetKey(), "IDE closed connection")
 				return fmt.Errorf("Client read error: %s", err)
 			default:
 			}
+
+			if handler.sessions.IsDraining() && session.IsIdle() && !session.detached.Load() {
+				sessionLogger.LogUserInfo("proxy-client", clientConnection.GetKey(), "Detaching idle session, as the proxy is shutting down")
+				if err := session.detach("dbgpProxy is shutting down"); err != nil {
+					return fmt.Errorf("Could not detach: %w", err)
+				}
+			}
 			continue
 		}
 
 		if err != nil {
 			// protocol error or EOF
 			// force close and return for cleanup
-			handler.logger.LogError("proxy-client", "Protocol error reading from server: %s", err)
+			var framingError *protocol.FramingError
+			if errors.As(err, &framingError) {
+				sessionLogger.LogUserError("proxy-client", clientConnection.GetKey(), "Not forwarding corrupt packet from server: %s", err)
+			} else {
+				sessionLogger.LogError("proxy-client", "Protocol error reading from server: %s", err)
+			}
 			conn.Close()
 			return nil
 		}
 
-		if packet := reader.FormatXML(response); packet != nil && packet.ShouldCloseConnection() {
+		session.recorder.RecordResponse(response)
+
+		packet := reader.FormatXML(response)
+		if dbgpResponse, ok := packet.(dbgpxml.Response); ok {
+			if dbgpResponse.TID == "0" && session.detached.Load() {
+				// the response to the proxy's own 'detach', which the IDE did not ask for
+				return nil
+			}
+			session.receivedResponse()
+		}
+
+		if packet != nil && packet.ShouldCloseConnection() {
 			// dbgp done, calling function will close connection or read next init packet from cloud
 			return nil
 		}
 
 		// forward packet
-		reassembledPacket := fmt.Sprintf("%d\000%s", len(response)-1, response)
-		client.Write([]byte(reassembledPacket))
+		reassembledPacket := fmt.Sprintf("%d\000%s\000", len(response), response)
+		session.writeToIDE([]byte(reassembledPacket))
+		session.countPacket(len(reassembledPacket))
+		handler.metrics.ForwardedPackets.Inc()
+		handler.metrics.ForwardedBytes.Add(uint64(len(reassembledPacket)))
 		// any error will re-appear on the top of the loop
 	}
 }
 
-func (handler *ServerHandler) sendDetach(conn net.Conn) {
-	err := protocol.NewDbgpClient(conn, handler.logger).RunCommand("detach -- \"dbgpProxy has no IDE connected to it\"")
+// Forwards the NUL terminated commands from the IDE to the engine, keeping track of
+// which ones still need a response
+func forwardCommands(session *Session, ide net.Conn) error {
+	reader := bufio.NewReader(ide)
+
+	for {
+		command, err := reader.ReadBytes(0)
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+
+		if err := session.sendCommand(command); err != nil {
+			return err
+		}
+	}
+}
+
+func (handler *ServerHandler) sendDetach(conn net.Conn, reason string) {
+	command := protocol.NewCommandLine("detach")
+	command.SetData(reason)
+
+	err := protocol.NewDbgpClient(conn, handler.logger).RunCommandLine(command)
 	if err != nil {
 		handler.logger.LogError("proxy-client", "Could not send 'detach': %s", err)
 		return
@@ -127,23 +227,18 @@ func (handler *ServerHandler) Handle(conn net.Conn) error {
 	var key string
 	var connType string
 
-	/* Set up interrupt handler */
-	signals := make(chan os.Signal, 1)
-	signal.Notify(signals, os.Interrupt, syscall.SIGINT, syscall.SIGTERM)
-
 	reader := protocol.NewDbgpClient(conn, handler.logger)
 
 ConnectionsLoop:
 	for {
 		response, err, timeout := reader.ReadResponseWithTimeout(2 * time.Second)
 
-		select {
-		case <-signals:
-			break ConnectionsLoop
-		default:
-			if timeout {
-				continue
+		if timeout {
+			// dbgpProxy drains the sessions when it shuts down, after which no new ones are started
+			if handler.sessions.IsDraining() {
+				break ConnectionsLoop
 			}
+			continue
 		}
 
 		if errors.Is(err, io.EOF) {
@@ -165,22 +260,48 @@ ConnectionsLoop:
 			return fmt.Errorf("Both IDE Key and Cloud User are unset")
 		}
 
-		client, ok := handler.connectionList.FindByKey(key)
+		handler.metrics.EngineConnections.Inc()
 
-		if ok {
+		if handler.sessions.IsDraining() {
+			handler.logger.LogUserInfo("proxy-client", key, "Not connecting %s '%s' to an IDE, as the proxy is shutting down", connType, key)
+			handler.sendDetach(conn, "dbgpProxy is shutting down")
+			return nil
+		}
+
+		candidates := handler.connectionList.SelectByKey(key)
+
+		if len(candidates) == 0 {
+			handler.logger.LogUserInfo("proxy-client", key, "Could not find IDE connection for %s '%s'", connType, key)
+			handler.metrics.DetachesNoIDE.Inc()
+			handler.sendDetach(conn, "dbgpProxy has no IDE connected to it")
+			continue
+		}
+
+		for i, client := range candidates {
 			handler.logger.LogUserInfo("proxy-client", key, "Found connection for %s '%s': %s", connType, key, client.FullAddress())
-			if err := handler.setupForwarder(conn, []byte(response), client); err != nil {
-				// Error indicates
-				// - IDE connection failed or init packet send error - Xdebug/Cloud should be "released"
-				// - Client disconnected and left Xdebug/Cloud in non-stopped status
-				// - Connection to Xdebug/Cloud failed
-				handler.logger.LogUserWarning("proxy-client", key, "Removed connection information for '%s': %s", key, err)
-				handler.connectionList.RemoveByKey(key)
-				handler.sendDetach(conn)
+			err := handler.setupForwarder(conn, []byte(response), client)
+			if err == nil {
+				break
 			}
-		} else {
-			handler.logger.LogUserInfo("proxy-client", key, "Could not find IDE connection for %s '%s'", connType, key)
-			handler.sendDetach(conn)
+
+			// Error indicates
+			// - IDE connection failed or init packet send error - Xdebug/Cloud should be "released"
+			// - Client disconnected and left Xdebug/Cloud in non-stopped status
+			// - Connection to Xdebug/Cloud failed
+			handler.logger.LogUserWarning("proxy-client", key, "Removed connection information for '%s' at %s: %s", key, client.FullAddress(), err)
+			handler.connectionList.Remove(client)
+
+			var connectError *ideConnectError
+			if errors.As(err, &connectError) && i+1 < len(candidates) {
+				handler.logger.LogUserInfo("proxy-client", key, "Trying the next IDE registered for '%s'", key)
+				continue
+			}
+
+			if errors.As(err, &connectError) {
+				handler.metrics.DetachesNoIDE.Inc()
+			}
+			handler.sendDetach(conn, "dbgpProxy has no IDE connected to it")
+			break
 		}
 	}
 
@@ -0,0 +1,124 @@
+package proxy
+
+import (
+	"encoding/base64"
+	"io"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/derickr/dbgp-tools/lib/connections"
+	"github.com/derickr/dbgp-tools/lib/engine"
+	"github.com/derickr/dbgp-tools/lib/logger"
+	"github.com/derickr/dbgp-tools/lib/metrics"
+	"github.com/derickr/dbgp-tools/lib/protocol"
+)
+
+func encode(text string) string {
+	return base64.StdEncoding.EncodeToString([]byte(text))
+}
+
+// Connects the simulated engine to a proxy, and returns the proxy's handler
+func startEngine(t *testing.T, ideKey string, list *connections.ConnectionList) (*ServerHandler, <-chan error) {
+	t.Helper()
+
+	handler := NewServerHandler(list, NewSessionList(), metrics.NewMetrics(), logger.NewTextLogger(io.Discard))
+	engineSide, proxySide := net.Pipe()
+	t.Cleanup(func() { proxySide.Close() })
+
+	go handler.Handle(proxySide)
+
+	served := make(chan error, 1)
+	go func() {
+		served <- engine.NewEngine(engine.DemoProgram(), engine.Options{IDEKey: ideKey}).Serve(engineSide)
+		engineSide.Close()
+	}()
+
+	return handler, served
+}
+
+func TestLoopbackSession(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("can not listen: %s", err)
+	}
+	defer listener.Close()
+
+	list := connections.NewConnectionList(false)
+	port := strconv.Itoa(listener.Addr().(*net.TCPAddr).Port)
+	list.Add(connections.NewConnection("loopback", "127.0.0.1", port, false, nil))
+
+	handler, served := startEngine(t, "loopback", list)
+
+	conn, err := listener.Accept()
+	if err != nil {
+		t.Fatalf("the proxy did not connect: %s", err)
+	}
+	ide := protocol.NewSession(conn, logger.NewTextLogger(io.Discard))
+	defer ide.Close()
+
+	init, err := ide.WaitForInit(5 * time.Second)
+	if err != nil {
+		t.Fatalf("no init packet: %s", err)
+	}
+	if init.IDEKey != "loopback" || init.FileURI != "file:///var/www/demo.php" {
+		t.Errorf("unexpected init packet: %+v", init)
+	}
+
+	command := protocol.NewCommandLine("breakpoint_set", "-t", "conditional", "-f", "file:///var/www/demo.php", "-n", "4")
+	command.SetData("$name == 'Xdebug'")
+
+	breakpoint, err := ide.ExecuteCommandLine(command)
+	if err != nil || breakpoint.Error != nil {
+		t.Fatalf("breakpoint_set failed: %v %+v", err, breakpoint.Error)
+	}
+
+	response, err := ide.Execute("run")
+	if err != nil {
+		t.Fatalf("run failed: %s", err)
+	}
+	if response.Status != "break" || response.Message.LineNo != 4 {
+		t.Errorf("expected a break on line 4, got status '%s' on line %d", response.Status, response.Message.LineNo)
+	}
+
+	property, err := ide.Execute("property_value -n $name")
+	if err != nil || property.Value != encode("Xdebug") {
+		t.Errorf("unexpected value of $name: %q (%v)", property.Value, err)
+	}
+
+	// The proxy closes the IDE's connection instead of forwarding the response to 'stop'
+	ide.Send("stop")
+
+	select {
+	case <-ide.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatalf("the proxy did not close the IDE's connection")
+	}
+
+	if err := <-served; err != nil {
+		t.Errorf("the engine failed: %s", err)
+	}
+
+	// WaitForInit also asks whether the engine supports asynchronous commands
+	if packets := handler.metrics.ForwardedPackets.Get(); packets != 4 {
+		t.Errorf("%d packets forwarded, expected 4", packets)
+	}
+}
+
+func TestLoopbackWithoutIDE(t *testing.T) {
+	handler, served := startEngine(t, "nobody", connections.NewConnectionList(false))
+
+	select {
+	case err := <-served:
+		if err != nil {
+			t.Errorf("the engine failed: %s", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("the proxy did not detach the engine")
+	}
+
+	if detaches := handler.metrics.DetachesNoIDE.Get(); detaches != 1 {
+		t.Errorf("%d detaches, expected 1", detaches)
+	}
+}
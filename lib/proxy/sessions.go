@@ -0,0 +1,228 @@
+package proxy
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/derickr/dbgp-tools/lib/dbgpxml"
+	"github.com/derickr/dbgp-tools/lib/protocol"
+	"github.com/derickr/dbgp-tools/lib/recorder"
+)
+
+// The DBGp error code for a command that can not be run in the current state
+const errorCommandNotAvailable = 5
+
+// A debugging session that is being forwarded between an engine and an IDE
+type Session struct {
+	ID            int
+	IDEKey        string
+	EngineAddress string
+	IDEAddress    string
+	Started       time.Time
+
+	packets atomic.Uint64
+	bytes   atomic.Uint64
+
+	engine       net.Conn
+	ide          net.Conn
+	writeLock    sync.Mutex   // for writing to the engine
+	ideWriteLock sync.Mutex   // for writing to the IDE
+	pending      atomic.Int32 // commands that the engine has not responded to yet
+	detached     atomic.Bool  // set when the proxy detached the engine, after which IDE commands are refused
+	recorder     *recorder.Recorder
+}
+
+func (session *Session) countPacket(size int) {
+	session.packets.Add(1)
+	session.bytes.Add(uint64(size))
+}
+
+func (session *Session) GetPacketCount() uint64 {
+	return session.packets.Load()
+}
+
+func (session *Session) GetByteCount() uint64 {
+	return session.bytes.Load()
+}
+
+// Whether the engine is waiting for the IDE to send a command
+func (session *Session) IsIdle() bool {
+	return session.pending.Load() == 0
+}
+
+// Forwards a command from the IDE, unless the proxy has detached the engine already, in
+// which case the IDE gets an error response instead
+func (session *Session) sendCommand(command []byte) error {
+	session.writeLock.Lock()
+	defer session.writeLock.Unlock()
+
+	if session.detached.Load() {
+		return session.refuseCommand(string(bytes.TrimRight(command, "\000")))
+	}
+
+	session.pending.Add(1)
+	session.recorder.RecordCommand(string(command))
+	_, err := session.engine.Write(command)
+
+	return err
+}
+
+func (session *Session) receivedResponse() {
+	if session.pending.Add(-1) < 0 {
+		session.pending.Store(0)
+	}
+}
+
+func (session *Session) refuseCommand(command string) error {
+	name, tid := "", ""
+	if cl, err := protocol.ParseCommandLine(command); err == nil {
+		name = cl.Name
+		tid, _ = cl.GetOption("-i")
+	}
+
+	response := dbgpxml.ErrorResponse(name, tid, errorCommandNotAvailable, "The proxy detached the engine")
+
+	_, err := session.writeToIDE([]byte(fmt.Sprintf("%d\000%s\000", len(response), response)))
+
+	return err
+}
+
+func (session *Session) writeToIDE(packet []byte) (int, error) {
+	session.ideWriteLock.Lock()
+	defer session.ideWriteLock.Unlock()
+
+	return session.ide.Write(packet)
+}
+
+// Sends 'detach' to the engine on behalf of the proxy. Its response has transaction ID 0,
+// and is not forwarded to the IDE.
+func (session *Session) detach(reason string) error {
+	session.writeLock.Lock()
+	defer session.writeLock.Unlock()
+
+	session.detached.Store(true)
+	command := protocol.NewCommandLine("detach", "-i", "0")
+	command.SetData(reason)
+	session.recorder.RecordCommand(command.String())
+	_, err := session.engine.Write([]byte(command.String() + "\000"))
+
+	return err
+}
+
+func (session *Session) forceClose() {
+	session.engine.Close()
+	session.ide.Close()
+}
+
+// What happened to the sessions that were active when the proxy shut down
+type DrainSummary struct {
+	Detached int // idle, and detached by the proxy
+	Finished int // ended by the engine or IDE during the grace period
+	TimedOut int // still active at the end of the grace period, and closed
+}
+
+type SessionList struct {
+	sync.Mutex
+	lastID   int
+	sessions map[int]*Session
+	draining chan struct{}
+	detached int
+}
+
+func NewSessionList() *SessionList {
+	return &SessionList{sessions: map[int]*Session{}, draining: make(chan struct{})}
+}
+
+func (list *SessionList) add(ideKey string, engine net.Conn, ide net.Conn, ideAddress string) *Session {
+	list.Lock()
+	defer list.Unlock()
+
+	list.lastID++
+	session := &Session{
+		ID:            list.lastID,
+		IDEKey:        ideKey,
+		EngineAddress: engine.RemoteAddr().String(),
+		IDEAddress:    ideAddress,
+		Started:       time.Now(),
+		engine:        engine,
+		ide:           ide,
+	}
+	list.sessions[session.ID] = session
+
+	return session
+}
+
+func (list *SessionList) remove(session *Session) {
+	list.Lock()
+	defer list.Unlock()
+
+	if session.detached.Load() {
+		list.detached++
+	}
+	delete(list.sessions, session.ID)
+}
+
+func (list *SessionList) IsDraining() bool {
+	select {
+	case <-list.draining:
+		return true
+	default:
+		return false
+	}
+}
+
+// Asks all sessions to end: idle sessions are detached from their engine, and
+// the others get until 'grace' has passed to finish, after which they are closed.
+func (list *SessionList) Drain(grace time.Duration) DrainSummary {
+	list.Lock()
+	close(list.draining)
+	total := len(list.sessions)
+	list.Unlock()
+
+	deadline := time.Now().Add(grace)
+	for list.Count() > 0 && time.Now().Before(deadline) {
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	remaining := list.All()
+	for _, session := range remaining {
+		session.forceClose()
+	}
+
+	list.Lock()
+	defer list.Unlock()
+
+	summary := DrainSummary{Detached: list.detached, TimedOut: len(remaining)}
+	summary.Finished = total - summary.Detached - summary.TimedOut
+
+	return summary
+}
+
+// Returns a snapshot of all active sessions, oldest first
+func (list *SessionList) All() []*Session {
+	list.Lock()
+	defer list.Unlock()
+
+	all := []*Session{}
+	for _, session := range list.sessions {
+		all = append(all, session)
+	}
+
+	sort.Slice(all, func(i, j int) bool {
+		return all[i].ID < all[j].ID
+	})
+
+	return all
+}
+
+func (list *SessionList) Count() int {
+	list.Lock()
+	defer list.Unlock()
+
+	return len(list.sessions)
+}
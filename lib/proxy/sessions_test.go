@@ -0,0 +1,120 @@
+package proxy
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func readNulTerminated(t *testing.T, reader *bufio.Reader) string {
+	t.Helper()
+
+	data, err := reader.ReadString(0)
+	if err != nil {
+		t.Fatalf("reading failed: %s", err)
+	}
+
+	return strings.TrimSuffix(data, "\000")
+}
+
+func TestDetachIsEncoded(t *testing.T) {
+	engine, engineSide := net.Pipe()
+	defer engine.Close()
+	defer engineSide.Close()
+
+	session := &Session{engine: engineSide}
+
+	go session.detach("dbgpProxy is shutting down")
+
+	command := readNulTerminated(t, bufio.NewReader(engine))
+	expected := "detach -i 0 -- ZGJncFByb3h5IGlzIHNodXR0aW5nIGRvd24="
+
+	if command != expected {
+		t.Errorf("sent %q, expected %q", command, expected)
+	}
+}
+
+func TestCommandsAfterDetachAreRefused(t *testing.T) {
+	ide, ideSide := net.Pipe()
+	defer ide.Close()
+	defer ideSide.Close()
+
+	session := &Session{ide: ideSide}
+	session.detached.Store(true)
+
+	go session.sendCommand([]byte("stack_get -i 7\000"))
+
+	reader := bufio.NewReader(ide)
+	readNulTerminated(t, reader) // the length
+	response := readNulTerminated(t, reader)
+
+	for _, expected := range []string{`command="stack_get"`, `transaction_id="7"`, `<error code="5">`} {
+		if !strings.Contains(response, expected) {
+			t.Errorf("response %q does not contain %q", response, expected)
+		}
+	}
+
+	if !session.IsIdle() {
+		t.Errorf("a refused command is waiting for a response")
+	}
+}
+
+func TestDrain(t *testing.T) {
+	list := NewSessionList()
+
+	add := func() *Session {
+		engine, engineSide := net.Pipe()
+		ide, ideSide := net.Pipe()
+		t.Cleanup(func() { engine.Close(); ide.Close() })
+
+		return list.add("key", engineSide, ideSide, "127.0.0.1:9003")
+	}
+
+	detached := add()
+	finished := add()
+	stuck := add()
+
+	// What the forwarders do, once they notice that the proxy is shutting down
+	go func() {
+		for !list.IsDraining() {
+			time.Sleep(time.Millisecond)
+		}
+
+		detached.detached.Store(true)
+		list.remove(detached)
+		list.remove(finished)
+	}()
+
+	summary := list.Drain(200 * time.Millisecond)
+
+	expected := DrainSummary{Detached: 1, Finished: 1, TimedOut: 1}
+	if summary != expected {
+		t.Errorf("summary %+v, expected %+v", summary, expected)
+	}
+
+	if _, err := stuck.engine.Write([]byte("x")); err == nil {
+		t.Errorf("the engine connection of the timed out session is still open")
+	}
+	if _, err := stuck.ide.Write([]byte("x")); err == nil {
+		t.Errorf("the IDE connection of the timed out session is still open")
+	}
+}
+
+func TestDrainWithoutSessions(t *testing.T) {
+	list := NewSessionList()
+
+	start := time.Now()
+	summary := list.Drain(time.Minute)
+
+	if summary != (DrainSummary{}) {
+		t.Errorf("summary %+v, expected nothing", summary)
+	}
+	if time.Since(start) > time.Second {
+		t.Errorf("waited for sessions that did not exist")
+	}
+	if !list.IsDraining() {
+		t.Errorf("the list is not draining")
+	}
+}
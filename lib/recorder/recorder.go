@@ -0,0 +1,151 @@
+package recorder
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Direction string
+
+const (
+	ToEngine   Direction = "ide-to-engine"
+	FromEngine Direction = "engine-to-ide"
+)
+
+// One packet, as it was sent over the wire, but without its framing
+type Entry struct {
+	Time      time.Time `json:"time"`
+	Direction Direction `json:"direction"`
+	IDEKey    string    `json:"idekey,omitempty"`
+	Data      string    `json:"data"`
+}
+
+/*
+ * Writes all packets of one debugging session to a file, with one JSON
+ * encoded Entry per line. All methods can be called on a nil *Recorder, so
+ * that callers don't need to check whether recording is enabled.
+ */
+type Recorder struct {
+	sync.Mutex
+	file    *os.File
+	encoder *json.Encoder
+	ideKey  string
+}
+
+var unsafeCharacters = regexp.MustCompile(`[^A-Za-z0-9_.-]+`)
+
+// Creates a new recording in 'directory', named after the current time and the IDE key
+func NewRecorder(directory string, ideKey string) (*Recorder, error) {
+	name := time.Now().Format("20060102-150405")
+	if ideKey != "" {
+		name += "-" + unsafeCharacters.ReplaceAllString(ideKey, "_")
+	}
+
+	file, err := os.CreateTemp(directory, name+"-*.jsonl")
+	if err != nil {
+		return nil, fmt.Errorf("Can not create recording: %w", err)
+	}
+
+	encoder := json.NewEncoder(file)
+	encoder.SetEscapeHTML(false)
+
+	return &Recorder{file: file, encoder: encoder, ideKey: ideKey}, nil
+}
+
+func (recorder *Recorder) Path() string {
+	if recorder == nil {
+		return ""
+	}
+
+	return recorder.file.Name()
+}
+
+// Sets the IDE key for the packets recorded from now on, for when it is only known after the init packet
+func (recorder *Recorder) SetIDEKey(ideKey string) {
+	if recorder == nil {
+		return
+	}
+
+	recorder.Lock()
+	defer recorder.Unlock()
+
+	recorder.ideKey = ideKey
+}
+
+func (recorder *Recorder) record(direction Direction, data string) {
+	if recorder == nil {
+		return
+	}
+
+	recorder.Lock()
+	defer recorder.Unlock()
+
+	// Errors are ignored, as a failing recording should not break the debugging session
+	recorder.encoder.Encode(Entry{Time: time.Now().UTC(), Direction: direction, IDEKey: recorder.ideKey, Data: data})
+}
+
+// Records a command. The trailing NUL byte is removed, if present.
+func (recorder *Recorder) RecordCommand(command string) {
+	recorder.record(ToEngine, strings.TrimSuffix(command, "\000"))
+}
+
+// Records an XML packet from the engine, without the length and NUL bytes that frame it
+func (recorder *Recorder) RecordResponse(xml string) {
+	recorder.record(FromEngine, xml)
+}
+
+func (recorder *Recorder) Close() error {
+	if recorder == nil {
+		return nil
+	}
+
+	recorder.Lock()
+	defer recorder.Unlock()
+
+	return recorder.file.Close()
+}
+
+// Reads all entries from a recording
+func ReadRecording(path string) ([]Entry, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("Can not read recording: %w", err)
+	}
+	defer file.Close()
+
+	var entries []Entry
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		if len(strings.TrimSpace(scanner.Text())) == 0 {
+			continue
+		}
+
+		var entry Entry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("%s:%d: Can not parse entry: %w", filepath.Base(path), lineNo, err)
+		}
+		if entry.Direction != ToEngine && entry.Direction != FromEngine {
+			return nil, fmt.Errorf("%s:%d: Unknown direction '%s'", filepath.Base(path), lineNo, entry.Direction)
+		}
+
+		entries = append(entries, entry)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("Can not read recording: %w", err)
+	}
+
+	return entries, nil
+}
@@ -0,0 +1,205 @@
+package replay
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/derickr/dbgp-tools/lib/dbgpxml"
+	"github.com/derickr/dbgp-tools/lib/protocol"
+	"github.com/derickr/dbgp-tools/lib/recorder"
+)
+
+// A recorded command, and the packets that the engine sent until the next command
+type exchange struct {
+	key       string
+	responses []string
+	replayed  bool
+}
+
+/*
+ * Plays the engine side of a recording back. Incoming commands are matched
+ * against the recorded ones by their name and arguments, but not by their
+ * transaction ID, which is rewritten in the recorded responses. Commands are
+ * preferably matched in the order in which they were recorded, so that a
+ * repeated command, such as "step_into", gets the next recorded response.
+ */
+type Script struct {
+	sync.Mutex
+	init      []string
+	exchanges []*exchange
+}
+
+// The transaction ID of a response's root element, after the XML declaration and any other
+// attributes, which are kept in the first group
+var transactionID = regexp.MustCompile(`^((?:<\?xml[^>]*>\s*)?<response\b[^>]*?)transaction_id="[^"]*"`)
+
+// The DBGp error code for commands that the engine does not implement
+const errorUnimplemented = 4
+
+func NewScript(entries []recorder.Entry) (*Script, error) {
+	script := &Script{}
+
+	var current *exchange
+
+	for _, entry := range entries {
+		switch entry.Direction {
+		case recorder.ToEngine:
+			key, err := commandKey(entry.Data)
+			if err != nil {
+				return nil, fmt.Errorf("Can not parse recorded command '%s': %w", entry.Data, err)
+			}
+			current = &exchange{key: key}
+			script.exchanges = append(script.exchanges, current)
+
+		case recorder.FromEngine:
+			if current == nil {
+				script.init = append(script.init, entry.Data)
+			} else {
+				current.responses = append(current.responses, entry.Data)
+			}
+		}
+	}
+
+	if len(script.init) == 0 {
+		return nil, fmt.Errorf("The recording does not start with an init packet")
+	}
+
+	return script, nil
+}
+
+// Reads a recording made with recorder.Recorder
+func Load(path string) (*Script, error) {
+	entries, err := recorder.ReadRecording(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewScript(entries)
+}
+
+// Returns the command's name and arguments in a normalised form, without its transaction ID
+func commandKey(line string) (string, error) {
+	cl, err := protocol.ParseCommandLine(strings.TrimSpace(line))
+	if err != nil {
+		return "", err
+	}
+
+	options := []string{}
+	for i := 0; i < len(cl.Arguments); i++ {
+		option := cl.Arguments[i]
+		value := ""
+		if i+1 < len(cl.Arguments) && !protocol.IsOption(cl.Arguments[i+1]) {
+			i++
+			value = cl.Arguments[i]
+		}
+		if option == "-i" {
+			continue
+		}
+		options = append(options, option+" "+value)
+	}
+	sort.Strings(options)
+
+	key := cl.Name + " " + strings.Join(options, " ")
+	if cl.HasData {
+		key += " -- " + cl.Data
+	}
+
+	return key, nil
+}
+
+// The packets that the engine sent after connecting, before receiving any command
+func (script *Script) Init() []string {
+	return script.init
+}
+
+// The number of recorded commands that have not been replayed yet
+func (script *Script) Remaining() int {
+	script.Lock()
+	defer script.Unlock()
+
+	remaining := 0
+	for _, exchange := range script.exchanges {
+		if !exchange.replayed {
+			remaining++
+		}
+	}
+
+	return remaining
+}
+
+// Marks all recorded commands as not replayed, to play the recording back again
+func (script *Script) Rewind() {
+	script.Lock()
+	defer script.Unlock()
+
+	for _, exchange := range script.exchanges {
+		exchange.replayed = false
+	}
+}
+
+func (script *Script) find(key string) *exchange {
+	var last *exchange
+
+	for _, exchange := range script.exchanges {
+		if exchange.key != key {
+			continue
+		}
+		if !exchange.replayed {
+			return exchange
+		}
+		last = exchange
+	}
+
+	// All recorded occurrences have been used, so repeat the last one
+	return last
+}
+
+/*
+ * Returns the packets to send in reply to 'command', with the transaction ID
+ * of the command. When no matching command was recorded, the reply is an
+ * error response, and 'matched' is false.
+ */
+func (script *Script) Answer(command string) (responses []string, matched bool, err error) {
+	command = strings.TrimSuffix(command, "\000")
+
+	cl, err := protocol.ParseCommandLine(strings.TrimSpace(command))
+	if err != nil {
+		return nil, false, err
+	}
+	tid, _ := cl.GetOption("-i")
+
+	key, err := commandKey(command)
+	if err != nil {
+		return nil, false, err
+	}
+
+	script.Lock()
+	exchange := script.find(key)
+	if exchange != nil {
+		exchange.replayed = true
+	}
+	script.Unlock()
+
+	if exchange == nil {
+		response := dbgpxml.ErrorResponse(cl.Name, tid, errorUnimplemented, fmt.Sprintf("The command '%s' does not occur in the recording", cl.Name))
+
+		return []string{response}, false, nil
+	}
+
+	replacement := fmt.Sprintf(`transaction_id="%s"`, dbgpxml.Escape(tid))
+
+	responses = make([]string, len(exchange.responses))
+	for i, response := range exchange.responses {
+		// Only the root element's attribute, and not text that happens to look like it. Stream
+		// and notify packets do not have one.
+		if location := transactionID.FindStringSubmatchIndex(response); location != nil {
+			response = response[:location[3]] + replacement + response[location[1]:]
+		}
+		responses[i] = response
+	}
+
+	return responses, true, nil
+}
@@ -0,0 +1,83 @@
+package replay
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/derickr/dbgp-tools/lib/recorder"
+)
+
+const header = `<?xml version="1.0" encoding="iso-8859-1"?>` + "\n"
+
+func newScript(t *testing.T) *Script {
+	t.Helper()
+
+	script, err := NewScript([]recorder.Entry{
+		{Direction: recorder.FromEngine, Data: header + `<init xmlns="urn:debugger_protocol_v1" idekey="test"></init>`},
+		{Direction: recorder.ToEngine, Data: "step_into -i 1"},
+		{Direction: recorder.FromEngine, Data: header + `<response xmlns="urn:debugger_protocol_v1" command="step_into" transaction_id="1" status="break" reason="ok"></response>`},
+		{Direction: recorder.ToEngine, Data: "step_into -i 2"},
+		{Direction: recorder.FromEngine, Data: header + `<response xmlns="urn:debugger_protocol_v1" command="step_into" transaction_id="2" status="stopping" reason="ok"></response>`},
+		{Direction: recorder.ToEngine, Data: "property_get -i 3 -n $a -d 0"},
+		{Direction: recorder.FromEngine, Data: header + `<notify xmlns="urn:debugger_protocol_v1" name="error"><message><![CDATA[transaction_id="3"]]></message></notify>`},
+		{Direction: recorder.FromEngine, Data: header + `<response xmlns="urn:debugger_protocol_v1" command="property_get" transaction_id="3"><property name="$a" type="string"><![CDATA[transaction_id="3"]]></property></response>`},
+	})
+	if err != nil {
+		t.Fatalf("can not create script: %s", err)
+	}
+
+	return script
+}
+
+func TestAnswerRewritesTransactionID(t *testing.T) {
+	script := newScript(t)
+
+	responses, matched, err := script.Answer("property_get -d 0 -n $a -i 42\000")
+	if err != nil || !matched {
+		t.Fatalf("not answered: matched %v, error %v", matched, err)
+	}
+	if len(responses) != 2 {
+		t.Fatalf("%d responses, expected 2", len(responses))
+	}
+
+	if strings.Contains(responses[0], `transaction_id="42"`) {
+		t.Errorf("the notify packet was changed: %s", responses[0])
+	}
+	if !strings.Contains(responses[1], `command="property_get" transaction_id="42">`) {
+		t.Errorf("the transaction ID was not replaced: %s", responses[1])
+	}
+	if !strings.Contains(responses[1], `<![CDATA[transaction_id="3"]]>`) {
+		t.Errorf("the property value was changed: %s", responses[1])
+	}
+}
+
+func TestAnswerInRecordedOrder(t *testing.T) {
+	script := newScript(t)
+
+	for i, expected := range []string{`status="break"`, `status="stopping"`, `status="stopping"`} {
+		responses, matched, _ := script.Answer("step_into -i 9")
+		if !matched || len(responses) != 1 || !strings.Contains(responses[0], expected) {
+			t.Errorf("step %d: got %v, expected a response with %s", i, responses, expected)
+		}
+	}
+
+	if remaining := script.Remaining(); remaining != 1 {
+		t.Errorf("%d commands remaining, expected 1", remaining)
+	}
+
+	script.Rewind()
+	if remaining := script.Remaining(); remaining != 3 {
+		t.Errorf("%d commands remaining after rewinding, expected 3", remaining)
+	}
+}
+
+func TestAnswerUnknownCommand(t *testing.T) {
+	responses, matched, err := newScript(t).Answer("stack_get -i 5")
+
+	if err != nil || matched {
+		t.Fatalf("expected no match, got matched %v, error %v", matched, err)
+	}
+	if len(responses) != 1 || !strings.Contains(responses[0], `transaction_id="5"`) || !strings.Contains(responses[0], `<error code="4">`) {
+		t.Errorf("unexpected response: %v", responses)
+	}
+}
@@ -2,6 +2,7 @@ package server
 
 import (
 	"crypto/tls"
+	"errors"
 	"fmt"
 	"github.com/derickr/dbgp-tools/lib/connections"
 	"github.com/derickr/dbgp-tools/lib/logger"
@@ -69,17 +70,10 @@ func (server *Server) Listen(handler Handler) {
 	server.logger.LogInfo("server", "Shutdown %s server", server.serverType)
 }
 
-func (server *Server) ListenSSL(handler Handler) {
+func (server *Server) ListenSSL(handler Handler, config *tls.Config) {
 	server.group.Add(1)
 	defer server.group.Done()
 
-	cert, err := tls.LoadX509KeyPair("certs/fullchain.pem", "certs/privkey.pem")
-	if err != nil {
-		server.logger.LogError("server", "Can not load SSL keys: %s", err)
-		panic(err)
-	}
-	config := tls.Config{Certificates: []tls.Certificate{cert}}
-
 	listener, err := net.ListenTCP("tcp", server.address)
 	if err != nil {
 		panic(err)
@@ -105,14 +99,14 @@ func (server *Server) ListenSSL(handler Handler) {
 		}
 		conn.SetKeepAlive(true)
 		conn.SetKeepAlivePeriod(time.Millisecond * 3)
-		go server.handleConnection(tls.Server(conn, &config), handler, nil)
+		go server.handleConnection(tls.Server(conn, config), handler, nil)
 	}
 
 	server.logger.LogInfo("server", "Shutdown %s SSL server", server.serverType)
 }
 
 func (server *Server) CloudConnect(handler Handler, cloudUser string, shutdownSignal chan int) error {
-	connToCloud, err := connections.ConnectTo(server.address.String(), true)
+	connToCloud, err := connections.ConnectTo(server.address.String(), &tls.Config{MinVersion: tls.VersionTLS12})
 
 	if err != nil {
 		server.logger.LogUserError("server", cloudUser, "Can not connect to Xdebug Cloud: %s", err)
@@ -121,7 +115,7 @@ func (server *Server) CloudConnect(handler Handler, cloudUser string, shutdownSi
 
 	server.logger.LogUserInfo("server", cloudUser, "Connected to Xdebug Cloud on %s", server.address)
 
-	err = protocol.NewDbgpClient(connToCloud, server.logger).RunCommand("cloudinit -u " + cloudUser)
+	err = protocol.NewDbgpClient(connToCloud, server.logger).RunCommand(protocol.NewCommandLine("cloudinit", "-u", cloudUser).String())
 	if err != nil {
 		server.logger.LogUserError("server", cloudUser, "Not connected to Xdebug Cloud: %s", err)
 		return err
@@ -153,7 +147,7 @@ func (server *Server) handleConnection(conn net.Conn, handler Handler, shutdownS
 
 func (server *Server) closeConnection(closer io.Closer) {
 	err := closer.Close()
-	if err != nil {
+	if err != nil && !errors.Is(err, net.ErrClosed) {
 		server.logger.LogWarning("server", "Couldn't close connection: %s", err)
 	}
 }
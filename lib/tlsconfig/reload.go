@@ -0,0 +1,112 @@
+package tlsconfig
+
+import (
+	"crypto/tls"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/derickr/dbgp-tools/lib/logger"
+)
+
+// Serves the certificate for new TLS connections through tls.Config.GetCertificate, so that
+// renewed certificates can be picked up without closing the listeners or existing connections
+type CertificateReloader struct {
+	sync.RWMutex
+	logger      logger.Logger
+	certFile    string
+	keyFile     string
+	certificate *tls.Certificate
+	modTime     time.Time
+}
+
+func NewCertificateReloader(certFile string, keyFile string, logger logger.Logger) (*CertificateReloader, error) {
+	reloader := &CertificateReloader{logger: logger}
+
+	if err := reloader.SetFiles(certFile, keyFile); err != nil {
+		return nil, err
+	}
+
+	return reloader, nil
+}
+
+// Returns the time the certificate or key file was last changed
+func lastModified(files ...string) (time.Time, error) {
+	var latest time.Time
+
+	for _, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			return latest, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+
+	return latest, nil
+}
+
+// Loads the certificate from a (new) pair of files. The current certificate stays in use if
+// they can not be loaded.
+func (reloader *CertificateReloader) SetFiles(certFile string, keyFile string) error {
+	modTime, err := lastModified(certFile, keyFile)
+	if err != nil {
+		return fmt.Errorf("Can not load SSL keys: %w", err)
+	}
+
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return fmt.Errorf("Can not load SSL keys: %w", err)
+	}
+
+	reloader.Lock()
+	defer reloader.Unlock()
+
+	reloader.certFile = certFile
+	reloader.keyFile = keyFile
+	reloader.certificate = &cert
+	reloader.modTime = modTime
+
+	return nil
+}
+
+func (reloader *CertificateReloader) Reload() error {
+	reloader.RLock()
+	certFile, keyFile := reloader.certFile, reloader.keyFile
+	reloader.RUnlock()
+
+	err := reloader.SetFiles(certFile, keyFile)
+	if err != nil {
+		return err
+	}
+
+	reloader.logger.LogInfo("SSL", "Reloaded certificate from '%s'", certFile)
+
+	return nil
+}
+
+// Reloads the certificate if the certificate or key file changed since it was loaded
+func (reloader *CertificateReloader) ReloadIfChanged() error {
+	reloader.RLock()
+	certFile, keyFile, loaded := reloader.certFile, reloader.keyFile, reloader.modTime
+	reloader.RUnlock()
+
+	modTime, err := lastModified(certFile, keyFile)
+	if err != nil {
+		return fmt.Errorf("Can not check SSL keys: %w", err)
+	}
+	if !modTime.After(loaded) {
+		return nil
+	}
+
+	return reloader.Reload()
+}
+
+func (reloader *CertificateReloader) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	reloader.RLock()
+	defer reloader.RUnlock()
+
+	return reloader.certificate, nil
+}
@@ -0,0 +1,138 @@
+package tlsconfig
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+)
+
+var versions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// The TLS material and policy for one side of a connection
+type Settings struct {
+	CertFile   string
+	KeyFile    string
+	CAFile     string // CA bundle to verify the peer with, instead of the system roots
+	MinVersion string // "1.0", "1.1", "1.2", or "1.3"
+
+	// Only for servers: require clients to present a certificate signed by a CA from CAFile
+	VerifyClients bool
+
+	// Only for clients: do not verify the server's certificate
+	Insecure bool
+
+	// Only for clients: the name to verify the server's certificate against, instead of the
+	// host that is connected to, which is often an IP address
+	ServerName string
+}
+
+func ParseVersion(version string) (uint16, error) {
+	if version == "" {
+		return tls.VersionTLS12, nil
+	}
+
+	value, ok := versions[version]
+	if !ok {
+		return 0, fmt.Errorf("The TLS version '%s' is not supported, use one of 1.0, 1.1, 1.2, or 1.3", version)
+	}
+
+	return value, nil
+}
+
+func loadCAFile(path string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Can not read CA file: %w", err)
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("The CA file '%s' does not contain any PEM encoded certificates", path)
+	}
+
+	return pool, nil
+}
+
+// Creates the configuration for accepting TLS connections
+func (settings Settings) ServerConfig() (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(settings.CertFile, settings.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("Can not load SSL keys: %w", err)
+	}
+
+	config, err := settings.serverConfig()
+	if err != nil {
+		return nil, err
+	}
+	config.Certificates = []tls.Certificate{cert}
+
+	return config, nil
+}
+
+// Like ServerConfig, but the certificate is provided by 'reloader', instead of being loaded
+// from CertFile and KeyFile
+func (settings Settings) ReloadableServerConfig(reloader *CertificateReloader) (*tls.Config, error) {
+	config, err := settings.serverConfig()
+	if err != nil {
+		return nil, err
+	}
+	config.GetCertificate = reloader.GetCertificate
+
+	return config, nil
+}
+
+func (settings Settings) serverConfig() (*tls.Config, error) {
+	minVersion, err := ParseVersion(settings.MinVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &tls.Config{MinVersion: minVersion}
+
+	if settings.VerifyClients {
+		if settings.CAFile == "" {
+			return nil, fmt.Errorf("Verifying client certificates requires a CA file")
+		}
+
+		config.ClientCAs, err = loadCAFile(settings.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return config, nil
+}
+
+// Creates the configuration for making TLS connections. The client certificate is optional.
+func (settings Settings) ClientConfig() (*tls.Config, error) {
+	minVersion, err := ParseVersion(settings.MinVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &tls.Config{MinVersion: minVersion, InsecureSkipVerify: settings.Insecure, ServerName: settings.ServerName}
+
+	if settings.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(settings.CertFile, settings.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("Can not load SSL client keys: %w", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	if settings.CAFile != "" {
+		config.RootCAs, err = loadCAFile(settings.CAFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return config, nil
+}